@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"path/filepath"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	cacheCommand = &cobra.Command{
+		Use:   "cache",
+		Short: "Manage cached instances in ~/.gossm/cache",
+		Long:  "Manage cached instances in ~/.gossm/cache, instances are cached per profile and region.",
+	}
+
+	cacheClearCommand = &cobra.Command{
+		Use:   "clear",
+		Short: "Clear cached instances of current profile",
+		Long:  "Clear cached instances of current profile, or all of profiles with --all.",
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := _credential.awsProfile
+			if viper.GetBool("cache-clear-all") {
+				profile = ""
+			}
+
+			if err := internal.ClearTargetCache(filepath.Join(_credential.gossmHomePath, "cache"), profile); err != nil {
+				panicRed(err)
+			}
+			if profile == "" {
+				color.Green("[cache] cleared all of cached instances")
+			} else {
+				color.Green("[cache] cleared cached instances of profile %s", profile)
+			}
+		},
+	}
+)
+
+func init() {
+	cacheClearCommand.Flags().BoolP("all", "a", false, "[optional] clear cached instances of all profiles")
+	viper.BindPFlag("cache-clear-all", cacheClearCommand.Flags().Lookup("all"))
+
+	cacheCommand.AddCommand(cacheClearCommand)
+	rootCmd.AddCommand(cacheCommand)
+}
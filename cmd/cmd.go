@@ -3,13 +3,16 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"strings"
-	"time"
+	"sync"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/fatih/color"
 	"github.com/gjbae1212/gossm/internal"
+	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -21,74 +24,247 @@ var (
 		Short: "Exec `run command` under AWS SSM with interactive CLI",
 		Long:  "Exec `run command` under AWS SSM with interactive CLI",
 		Run: func(cmd *cobra.Command, args []string) {
-			var (
-				targets []*internal.Target
-				err     error
-			)
 			ctx := context.Background()
 
-			exec := strings.TrimSpace(viper.GetString("cmd-exec"))
-			if exec == "" {
-				panicRed(fmt.Errorf("[err] not found exec command"))
+			input, label, err := getCommandInput()
+			if err != nil {
+				panicRed(err)
 			}
 
-			// get targets
-			argTarget := strings.TrimSpace(viper.GetString("cmd-target"))
-			if argTarget != "" {
-				table, err := internal.FindInstances(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
-				for _, t := range table {
-					if t.Name == argTarget {
-						targets = append(targets, t)
-						break
-					}
+			// results are printed as soon as each instance is over, and a summary is printed after all of them.
+			// structured output is written at once after all of them.
+			var mu sync.Mutex
+			onResult := func(r *internal.CommandResult) {
+				if _output.Structured() {
+					return
 				}
+				mu.Lock()
+				defer mu.Unlock()
+				internal.PrintCommandResult(_output.Writer(), r)
 			}
 
-			if len(targets) == 0 {
-				targets, err = internal.AskMultiTarget(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
+			var (
+				results []*internal.CommandResult
+				failed  bool
+			)
+			if len(input.Targets) > 0 {
+				results, failed = runCommandOnTargets(ctx, input, label, onResult)
+			} else {
+				results = runCommandOnInstances(ctx, input, label, onResult)
 			}
+			writeCommandResults(results)
 
-			var targetName string
-			for _, t := range targets {
-				targetName += " " + t.Name + " "
+			// exit status is non-zero if command fails on any of targets, so it can be used in scripts.
+			for _, r := range results {
+				failed = failed || !r.Succeeded()
+			}
+			if failed {
+				os.Exit(1)
 			}
+		},
+	}
+)
+
+// cmdGroup is targets in the same profile and region, which receive a command together.
+type cmdGroup struct {
+	cfg     aws.Config
+	targets []*internal.Target
+}
+
+// runCommandOnInstances sends command to selected instances, and rate is controlled by gossm.
+// Instances in flight are at most the smaller of batch-size and max-concurrency, and the rest are skipped when failed instances exceed max-errors.
+func runCommandOnInstances(ctx context.Context, input *internal.CommandInput, label string, onResult func(*internal.CommandResult)) []*internal.CommandResult {
+	// get targets
+	argTarget := strings.TrimSpace(viper.GetString("cmd-target"))
+	targets := findTargets(ctx, "cmd", argTarget)
+
+	// batch-size and max-concurrency both limit instances in flight.
+	window := viper.GetInt("cmd-batch-size")
+	maxConcurrency, err := internal.ParseCommandLimit(viper.GetString("cmd-max-concurrency"), len(targets))
+	if err != nil {
+		panicRed(err)
+	}
+	if maxConcurrency == 0 {
+		panicRed(fmt.Errorf("[err] max-concurrency must be greater than 0"))
+	}
+	if maxConcurrency > 0 && (window <= 0 || maxConcurrency < window) {
+		window = maxConcurrency
+	}
+	maxErrors, err := internal.ParseCommandLimit(viper.GetString("cmd-max-errors"), len(targets))
+	if err != nil {
+		panicRed(err)
+	}
+
+	// targets are grouped by scope, because a command is sent with config of profile and region.
+	var (
+		groups []*cmdGroup
+		table  = map[string]*cmdGroup{}
+	)
+	for _, t := range targets {
+		key := t.Profile + "/" + t.Region
+		if _, ok := table[key]; !ok {
+			table[key] = &cmdGroup{cfg: targetConfig(t)}
+			groups = append(groups, table[key])
+		}
+		table[key].targets = append(table[key].targets, t)
+	}
+
+	for _, g := range groups {
+		var targetName string
+		for _, t := range g.targets {
+			targetName += " " + t.Name + " "
+		}
+		internal.PrintReady(label, g.cfg.Region, targetName)
+
+		// document is validated per scope, because documents differ by account and region.
+		if err := internal.ValidateCommand(ctx, g.cfg, input); err != nil {
+			panicRed(err)
+		}
+	}
+
+	fmt.Fprintln(color.Output, color.YellowString("Waiting Response ..."))
 
-			internal.PrintReady(exec, _credential.awsConfig.Region, targetName)
+	return internal.RunCommandInWindow(ctx, targets, targetConfig, input, window, maxErrors, onResult)
+}
+
+// runCommandOnTargets sends command to targets such as tags in every scope, and rate is controlled by SSM.
+// It returns whether command couldn't be sent to any of scopes.
+func runCommandOnTargets(ctx context.Context, input *internal.CommandInput, label string, onResult func(*internal.CommandResult)) ([]*internal.CommandResult, bool) {
+	if strings.TrimSpace(viper.GetString("cmd-target")) != "" {
+		panicRed(fmt.Errorf("[err] target and targets can't be used together"))
+	}
+	if viper.GetInt("cmd-batch-size") > 0 {
+		panicRed(fmt.Errorf("[err] batch-size can't be used with targets, use max-concurrency instead"))
+	}
+
+	var specs []string
+	for _, t := range input.Targets {
+		specs = append(specs, aws.ToString(t.Key)+"="+strings.Join(t.Values, ","))
+	}
+	scopes := getTargetScopes(ctx)
+	for _, scope := range scopes.Items {
+		internal.PrintReady(label, scope.Region(), strings.Join(specs, " "))
+		if err := internal.ValidateCommand(ctx, scope.Config, input); err != nil {
+			panicRed(err)
+		}
+	}
 
-			sendOutput, err := internal.SendCommand(ctx, *_credential.awsConfig, targets, exec)
+	fmt.Fprintln(color.Output, color.YellowString("Waiting Response ..."))
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		results []*internal.CommandResult
+		failed  bool
+	)
+	for _, scope := range scopes.Items {
+		wg.Add(1)
+		go func(scope *internal.Scope) {
+			defer wg.Done()
+			rs, err := internal.RunCommandOnTargets(ctx, scope.Config, input, onResult)
+			mu.Lock()
+			defer mu.Unlock()
+			results = append(results, rs...)
 			if err != nil {
-				panicRed(err)
+				failed = true
+				fmt.Fprintln(color.Output, color.RedString("[err] %s %s", scope, err.Error()))
 			}
+		}(scope)
+	}
+	wg.Wait()
+	return results, failed
+}
 
-			fmt.Printf("%s\n", color.YellowString("Waiting Response ..."))
-			// wait 3 seconds
-			time.Sleep(time.Second * 3)
-
-			// show result
-			var inputs []*ssm.GetCommandInvocationInput
-			for _, inst := range sendOutput.Command.InstanceIds {
-				inputs = append(inputs, &ssm.GetCommandInvocationInput{
-					CommandId:  sendOutput.Command.CommandId,
-					InstanceId: aws.String(inst),
-				})
-			}
-			internal.PrintCommandInvocation(ctx, *_credential.awsConfig, inputs)
-		},
+// writeCommandResults writes results of command, a summary is printed as a table.
+func writeCommandResults(results []*internal.CommandResult) {
+	if results == nil {
+		results = []*internal.CommandResult{}
 	}
-)
+	if err := _output.Write(results, func(w io.Writer) {
+		fmt.Fprintln(w)
+		internal.PrintCommandSummary(w, results)
+	}); err != nil {
+		panicRed(err)
+	}
+}
+
+// getCommandInput returns document and parameters from flags, and a label of command which is printed.
+// exec and script are commands of document, such as AWS-RunShellScript and AWS-RunPowerShellScript.
+func getCommandInput() (*internal.CommandInput, string, error) {
+	exec := strings.TrimSpace(viper.GetString("cmd-exec"))
+	script := strings.TrimSpace(viper.GetString("cmd-script"))
+	document := strings.TrimSpace(viper.GetString("cmd-document"))
+	if exec != "" && script != "" {
+		return nil, "", fmt.Errorf("[err] exec and script can't be used together")
+	}
+
+	params, err := internal.ParseCommandParams(viper.GetStringSlice("cmd-param"))
+	if err != nil {
+		return nil, "", err
+	}
+	targets, err := internal.ParseCommandTargets(viper.GetStringSlice("cmd-targets"))
+	if err != nil {
+		return nil, "", err
+	}
+	input := &internal.CommandInput{
+		DocumentName:     document,
+		Parameters:       params,
+		WorkingDirectory: strings.TrimSpace(viper.GetString("cmd-working-dir")),
+		Timeout:          viper.GetDuration("cmd-timeout"),
+		Targets:          targets,
+		MaxConcurrency:   strings.TrimSpace(viper.GetString("cmd-max-concurrency")),
+		MaxErrors:        strings.TrimSpace(viper.GetString("cmd-max-errors")),
+	}
+	if input.DocumentName == "" {
+		input.DocumentName = internal.DefaultCommandDocument
+	}
+
+	label := input.DocumentName
+	switch {
+	case exec != "":
+		input.Commands, label = []string{exec}, exec
+	case script != "":
+		path, err := homedir.Expand(script)
+		if err != nil {
+			return nil, "", internal.WrapError(err)
+		}
+		if input.Commands, err = internal.ReadCommandScript(path); err != nil {
+			return nil, "", err
+		}
+		label = filepath.Base(path)
+	// the default document requires commands, other documents are validated with their parameters.
+	case document == "" && len(params) == 0:
+		return nil, "", fmt.Errorf("[err] not found exec command, script or document")
+	}
+	return input, label, nil
+}
 
 func init() {
-	cmdCommand.Flags().StringP("exec", "e", "", "[required] execute command")
+	cmdCommand.Flags().StringP("exec", "e", "", "[optional] execute command, exec, script or document is required")
 	cmdCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId.")
+	cmdCommand.Flags().String("script", "", "[optional] script file which is uploaded inline as commands, ex) ./deploy.sh")
+	cmdCommand.Flags().String("document", "", "[optional] Command document to send (default AWS-RunShellScript), ex) AWS-RunPowerShellScript")
+	cmdCommand.Flags().StringArray("param", nil, "[optional] parameter of document, it is repeatable, ex) --param key=value")
+	cmdCommand.Flags().Duration("timeout", 0, "[optional] execution timeout of command (default of document), ex) 10m")
+	cmdCommand.Flags().String("working-dir", "", "[optional] working directory of command, ex) /opt/app")
+	cmdCommand.Flags().StringArray("targets", nil, "[optional] send to targets of SSM instead of selecting instances, it is repeatable, ex) tag:Role=web")
+	cmdCommand.Flags().String("max-concurrency", "", "[optional] the number or percentage of instances which run command at the same time, ex) 10 or 10%")
+	cmdCommand.Flags().String("max-errors", "", "[optional] the number or percentage of failed instances which stops sending command, ex) 1 or 5%")
+	cmdCommand.Flags().Int("batch-size", 0, "[optional] the number of selected instances which run command at the same time, next instance is sent as soon as one is over")
 
 	viper.BindPFlag("cmd-exec", cmdCommand.Flags().Lookup("exec"))
 	viper.BindPFlag("cmd-target", cmdCommand.Flags().Lookup("target"))
+	viper.BindPFlag("cmd-script", cmdCommand.Flags().Lookup("script"))
+	viper.BindPFlag("cmd-document", cmdCommand.Flags().Lookup("document"))
+	viper.BindPFlag("cmd-param", cmdCommand.Flags().Lookup("param"))
+	viper.BindPFlag("cmd-timeout", cmdCommand.Flags().Lookup("timeout"))
+	viper.BindPFlag("cmd-working-dir", cmdCommand.Flags().Lookup("working-dir"))
+	viper.BindPFlag("cmd-targets", cmdCommand.Flags().Lookup("targets"))
+	viper.BindPFlag("cmd-max-concurrency", cmdCommand.Flags().Lookup("max-concurrency"))
+	viper.BindPFlag("cmd-max-errors", cmdCommand.Flags().Lookup("max-errors"))
+	viper.BindPFlag("cmd-batch-size", cmdCommand.Flags().Lookup("batch-size"))
+	addTargetFilterFlags(cmdCommand, "cmd")
+	addTargetQueryFlag(cmdCommand, "cmd")
 
 	rootCmd.AddCommand(cmdCommand)
 }
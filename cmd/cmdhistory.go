@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// cmdHistoryCommand lists recent commands which were sent by ListCommands.
+	cmdHistoryCommand = &cobra.Command{
+		Use:   "history",
+		Short: "List recent commands of `run command` with status and targets",
+		Long:  "List recent commands of `run command` with status and targets, SSM keeps commands for 30 days.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			histories, err := internal.ListCommandHistoryInScopes(ctx, getTargetScopes(ctx), viper.GetInt("cmd-history-limit"))
+			if err != nil {
+				panicRed(err)
+			}
+			// scope is printed only if commands are found in multiple scopes.
+			if len(getTargetScopes(ctx).Items) == 1 {
+				for _, h := range histories {
+					h.Scope = ""
+				}
+			}
+			if histories == nil {
+				histories = []*internal.CommandHistory{}
+			}
+			if err := _output.Write(histories, func(w io.Writer) {
+				if len(histories) == 0 {
+					color.Yellow("[history] not found commands")
+					return
+				}
+				internal.PrintCommandHistory(w, histories)
+			}); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+
+	// cmdShowCommand fetches result of a command per instance again.
+	cmdShowCommand = &cobra.Command{
+		Use:   "show [command-id]",
+		Short: "Show result of a command per instance again",
+		Long:  "Show result of a command per instance again, and wait for instances where command is running.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			scope, history := findCommandScope(ctx, strings.TrimSpace(args[0]))
+			if !_output.Structured() {
+				internal.PrintCommandHistory(_output.Writer(), []*internal.CommandHistory{history})
+				fmt.Fprintln(_output.Writer())
+			}
+
+			var mu sync.Mutex
+			results, err := internal.FetchCommandResults(ctx, scope.Config, history.CommandId, func(r *internal.CommandResult) {
+				if _output.Structured() {
+					return
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				internal.PrintCommandResult(_output.Writer(), r)
+			})
+			if err != nil {
+				panicRed(err)
+			}
+			if err := _output.Write(&commandDetail{Command: history, Results: results}, func(w io.Writer) {
+				fmt.Fprintln(w)
+				internal.PrintCommandSummary(w, results)
+			}); err != nil {
+				panicRed(err)
+			}
+
+			// exit status is non-zero if command failed on any of instances, the same as cmd.
+			for _, r := range results {
+				if !r.Succeeded() {
+					os.Exit(1)
+				}
+			}
+		},
+	}
+
+	// cmdCancelCommand cancels a command on instances where it isn't over.
+	cmdCancelCommand = &cobra.Command{
+		Use:   "cancel [command-id]",
+		Short: "Cancel a command on instances where it is running",
+		Long:  "Cancel a command on instances where it is pending or running.",
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			scope, history := findCommandScope(ctx, strings.TrimSpace(args[0]))
+			if internal.IsCommandOver(history.Status) {
+				panicRed(fmt.Errorf("[err] command %s is over already, status is %s", history.CommandId, history.Status))
+			}
+			if err := internal.CancelCommand(ctx, scope.Config, history.CommandId); err != nil {
+				panicRed(err)
+			}
+			color.Green("[cancel] command %s is cancelling in %s", history.CommandId, scope)
+		},
+	}
+)
+
+// commandDetail is a command and its result per instance, which is written by show.
+type commandDetail struct {
+	Command *internal.CommandHistory  `json:"command"`
+	Results []*internal.CommandResult `json:"results"`
+}
+
+// findCommandScope returns a command and scope where it was sent, commands are found in every scope concurrently.
+func findCommandScope(ctx context.Context, commandId string) (*internal.Scope, *internal.CommandHistory) {
+	scopes := getTargetScopes(ctx)
+
+	var (
+		wg        sync.WaitGroup
+		histories = make([]*internal.CommandHistory, len(scopes.Items))
+		errs      = make([]error, len(scopes.Items))
+	)
+	for i, scope := range scopes.Items {
+		wg.Add(1)
+		go func(i int, scope *internal.Scope) {
+			defer wg.Done()
+			histories[i], errs[i] = internal.FindCommand(ctx, scope.Config, commandId)
+		}(i, scope)
+	}
+	wg.Wait()
+
+	for i, h := range histories {
+		if h != nil {
+			return scopes.Items[i], h
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			panicRed(err)
+		}
+	}
+	panicRed(fmt.Errorf("[err] not found command %s", commandId))
+	return nil, nil
+}
+
+func init() {
+	cmdHistoryCommand.Flags().IntP("limit", "n", internal.DefaultCommandHistoryLimit, "[optional] the number of recent commands")
+	viper.BindPFlag("cmd-history-limit", cmdHistoryCommand.Flags().Lookup("limit"))
+
+	cmdCommand.AddCommand(cmdHistoryCommand)
+	cmdCommand.AddCommand(cmdShowCommand)
+	cmdCommand.AddCommand(cmdCancelCommand)
+}
@@ -0,0 +1,158 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/viper"
+)
+
+const (
+	_configFile        = "config.yaml"
+	_projectConfigFile = ".gossm.yaml"
+)
+
+// alias is a named command in config file, which is invoked by `gossm run <alias>`.
+type alias struct {
+	// Cmd is a command of gossm, such as start, ssh, fwd, fwdrem and cmd, and other fields are its flags.
+	Cmd string `mapstructure:"cmd"`
+	// Target is instance id, ecs:<cluster>_<task>_<runtime-id> or filter of targets, ex) tag:Name=bastion
+	Target  string   `mapstructure:"target"`
+	Filters []string `mapstructure:"filters"`
+	Query   string   `mapstructure:"query"`
+	Host    string   `mapstructure:"host"`
+	Remote  string   `mapstructure:"remote"`
+	Local   string   `mapstructure:"local"`
+	Exec    string   `mapstructure:"exec"`
+	User    string   `mapstructure:"user"`
+	// Args are appended to command line as they are.
+	Args    []string `mapstructure:"args"`
+	Profile string   `mapstructure:"profile"`
+	Region  string   `mapstructure:"region"`
+}
+
+// readConfigFiles reads ~/.gossm/config.yaml and then merges .gossm.yaml of current project into it.
+// Keys of config files are the same as keys of flags, such as profile, region, ssh-user, so flags take precedence over them.
+func readConfigFiles(gossmHomePath string) error {
+	viper.SetConfigType("yaml")
+
+	files := []string{filepath.Join(gossmHomePath, _configFile)}
+	if project := findProjectConfigFile(); project != "" {
+		files = append(files, project)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err != nil {
+			continue
+		}
+		f, err := os.Open(file)
+		if err != nil {
+			return internal.WrapError(err)
+		}
+		err = viper.MergeConfig(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("[err] invalid config file %s, %s", file, err.Error())
+		}
+	}
+	return nil
+}
+
+// findProjectConfigFile returns .gossm.yaml in current directory or its parents, it returns empty string if not found.
+func findProjectConfigFile() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for {
+		file := filepath.Join(dir, _projectConfigFile)
+		if _, err := os.Stat(file); err == nil {
+			return file
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// getAliases returns aliases in config files.
+func getAliases() (map[string]*alias, error) {
+	aliases := map[string]*alias{}
+	if err := viper.UnmarshalKey("aliases", &aliases); err != nil {
+		return nil, fmt.Errorf("[err] invalid aliases in config file, %s", err.Error())
+	}
+	return aliases, nil
+}
+
+// aliasNames returns sorted names of aliases.
+func aliasNames(aliases map[string]*alias) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// commandLine returns arguments of gossm which alias invokes.
+func (a *alias) commandLine() ([]string, error) {
+	if a.Cmd == "" {
+		return nil, fmt.Errorf("[err] not found cmd of alias, ex) fwdrem")
+	}
+
+	cmd, _, err := rootCmd.Find([]string{a.Cmd})
+	if err != nil || cmd == rootCmd {
+		return nil, fmt.Errorf("[err] unknown cmd %s of alias", a.Cmd)
+	}
+
+	// flags which cmd doesn't have are rejected before it is invoked, such as target of scp.
+	args := []string{a.Cmd}
+	var unsupported []string
+	add := func(flag, value string) {
+		if value == "" {
+			return
+		}
+		name := strings.TrimPrefix(flag, "--")
+		if cmd.Flags().Lookup(name) == nil && cmd.InheritedFlags().Lookup(name) == nil {
+			unsupported = append(unsupported, flag)
+			return
+		}
+		args = append(args, flag, value)
+	}
+	add("--profile", a.Profile)
+	add("--region", a.Region)
+	// target which isn't id of instance or container is a filter of targets.
+	if isTargetId(a.Target) {
+		add("--target", a.Target)
+	} else {
+		add("--filter", a.Target)
+	}
+	for _, f := range a.Filters {
+		add("--filter", f)
+	}
+	add("--query", a.Query)
+	add("--host", a.Host)
+	add("--remote", a.Remote)
+	add("--local", a.Local)
+	add("--exec", a.Exec)
+	add("--user", a.User)
+	if len(unsupported) != 0 {
+		return nil, fmt.Errorf("[err] %s of alias isn't supported by cmd %s", strings.Join(unsupported, ", "), a.Cmd)
+	}
+	return append(args, a.Args...), nil
+}
+
+// isTargetId returns whether target is id of instance, managed instance or container of ECS task.
+func isTargetId(target string) bool {
+	for _, prefix := range []string{"i-", "mi-", "ecs:"} {
+		if strings.HasPrefix(target, prefix) {
+			return true
+		}
+	}
+	return false
+}
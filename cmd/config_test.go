@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadConfigFiles(t *testing.T) {
+	assert := assert.New(t)
+	defer viper.Reset()
+
+	home, err := ioutil.TempDir("", "gossm-home")
+	assert.NoError(err)
+	defer os.RemoveAll(home)
+	project, err := ioutil.TempDir("", "gossm-project")
+	assert.NoError(err)
+	defer os.RemoveAll(project)
+
+	assert.NoError(ioutil.WriteFile(filepath.Join(home, _configFile), []byte(`
+profile: dev
+region: us-east-1
+ssh-user: ec2-user
+aliases:
+  db-tunnel: {target: tag:Name=bastion, cmd: fwdrem, host: db.internal, remote: 5432, local: 15432}
+`), 0600))
+	assert.NoError(ioutil.WriteFile(filepath.Join(project, _projectConfigFile), []byte(`
+profile: prod
+aliases:
+  api: {target: i-1, cmd: start}
+`), 0600))
+
+	wd, err := os.Getwd()
+	assert.NoError(err)
+	defer os.Chdir(wd)
+	sub := filepath.Join(project, "sub")
+	assert.NoError(os.Mkdir(sub, 0700))
+	assert.NoError(os.Chdir(sub))
+
+	assert.NoError(readConfigFiles(home))
+	assert.Equal("prod", viper.GetString("profile"))
+	assert.Equal("us-east-1", viper.GetString("region"))
+	assert.Equal("ec2-user", viper.GetString("ssh-user"))
+
+	aliases, err := getAliases()
+	assert.NoError(err)
+	assert.Equal([]string{"api", "db-tunnel"}, aliasNames(aliases))
+
+	line, err := aliases["db-tunnel"].commandLine()
+	assert.NoError(err)
+	assert.Equal([]string{"fwdrem", "--filter", "tag:Name=bastion", "--host", "db.internal", "--remote", "5432", "--local", "15432"}, line)
+
+	line, err = aliases["api"].commandLine()
+	assert.NoError(err)
+	assert.Equal([]string{"start", "--target", "i-1"}, line)
+
+	_, err = (&alias{Target: "i-1"}).commandLine()
+	assert.Error(err)
+}
+
+func TestAlias_CommandLine(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		alias *alias
+		line  []string
+		isErr bool
+	}{
+		"ssh": {
+			alias: &alias{Cmd: "ssh", Target: "i-1", User: "ec2-user", Profile: "prod"},
+			line:  []string{"ssh", "--profile", "prod", "--target", "i-1", "--user", "ec2-user"},
+		},
+		"ssh filter": {
+			alias: &alias{Cmd: "ssh", Target: "tag:Name=bastion", Query: "api"},
+			line:  []string{"ssh", "--filter", "tag:Name=bastion", "--query", "api"},
+		},
+		"scp": {
+			alias: &alias{Cmd: "scp", Exec: "ex.txt ubuntu@server:/home/ex.txt", Args: []string{"-r", "ap-northeast-2"}},
+			line:  []string{"scp", "--exec", "ex.txt ubuntu@server:/home/ex.txt", "-r", "ap-northeast-2"},
+		},
+		"scp target":  {alias: &alias{Cmd: "scp", Target: "i-1", Exec: "ex.txt ubuntu@server:/home/ex.txt"}, isErr: true},
+		"ssh host":    {alias: &alias{Cmd: "ssh", Target: "i-1", Host: "db.internal"}, isErr: true},
+		"unknown cmd": {alias: &alias{Cmd: "telnet", Target: "i-1"}, isErr: true},
+	}
+
+	for name, t := range tests {
+		line, err := t.alias.commandLine()
+		assert.Equal(t.isErr, err != nil, name)
+		assert.Equal(t.line, line, name)
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	ecsCommand = &cobra.Command{
+		Use:   "ecs",
+		Short: "Exec `execute-command` into a container of ECS task with interactive CLI",
+		Long:  "Exec `execute-command` into a container of ECS task with interactive CLI, the task must enable ECS Exec.",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			command := strings.TrimSpace(viper.GetString("ecs-exec"))
+			if command == "" {
+				command = "/bin/sh"
+			}
+
+			container, err := internal.AskECSContainer(ctx, *_credential.awsConfig,
+				strings.TrimSpace(viper.GetString("ecs-cluster")),
+				strings.TrimSpace(viper.GetString("ecs-service")),
+				strings.TrimSpace(viper.GetString("ecs-task")),
+				strings.TrimSpace(viper.GetString("ecs-container")))
+			if err != nil {
+				panicRed(err)
+			}
+			internal.PrintReady("ecs-execute-command", _credential.awsConfig.Region, container.SessionTarget())
+
+			session, err := internal.ExecuteECSCommand(ctx, *_credential.awsConfig, container, command)
+			if err != nil {
+				panicRed(err)
+			}
+
+			if err := internal.StartShellSession(ctx, session); err != nil {
+				color.Red("%v", err)
+			}
+
+			if err := internal.DeleteStartSession(ctx, *_credential.awsConfig, &ssm.TerminateSessionInput{
+				SessionId: session.SessionId,
+			}); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+)
+
+func init() {
+	ecsCommand.Flags().StringP("cluster", "", "", "[optional] name of ECS cluster")
+	ecsCommand.Flags().StringP("service", "", "", "[optional] name of ECS service")
+	ecsCommand.Flags().StringP("task", "", "", "[optional] id of ECS task")
+	ecsCommand.Flags().StringP("container", "", "", "[optional] name of container in ECS task")
+	ecsCommand.Flags().StringP("exec", "e", "/bin/sh", "[optional] command to execute in container")
+
+	viper.BindPFlag("ecs-cluster", ecsCommand.Flags().Lookup("cluster"))
+	viper.BindPFlag("ecs-service", ecsCommand.Flags().Lookup("service"))
+	viper.BindPFlag("ecs-task", ecsCommand.Flags().Lookup("task"))
+	viper.BindPFlag("ecs-container", ecsCommand.Flags().Lookup("container"))
+	viper.BindPFlag("ecs-exec", ecsCommand.Flags().Lookup("exec"))
+
+	rootCmd.AddCommand(ecsCommand)
+}
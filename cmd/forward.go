@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addForwardFlags adds flags which have multiple forwards like -L of ssh and keep-alive, and maps them to viper with prefix.
+func addForwardFlags(cmd *cobra.Command, prefix string) {
+	cmd.Flags().StringArrayP("forward", "L", nil, "[optional] forward local port to host and port through target, it is repeatable, ex) 15432:db.internal:5432, 8080:80")
+	cmd.Flags().Bool("keep-alive", false, "[optional] start a lost session again with exponential backoff, local port is kept")
+	viper.BindPFlag(prefix+"-forward", cmd.Flags().Lookup("forward"))
+	viper.BindPFlag(prefix+"-keep-alive", cmd.Flags().Lookup("keep-alive"))
+}
+
+// getForwards returns forwards from flag which is mapped with prefix, host of forward is defaultHost if it is omitted.
+func getForwards(prefix, defaultHost string) []*internal.Forward {
+	var forwards []*internal.Forward
+	for _, spec := range viper.GetStringSlice(prefix + "-forward") {
+		f, err := internal.ParseForward(spec)
+		if err != nil {
+			panicRed(err)
+		}
+		if f.Host == "" {
+			f.Host = defaultHost
+		}
+		forwards = append(forwards, f)
+	}
+	return forwards
+}
+
+// runForwards opens forwards through target until ctrl+c, and then deletes their sessions.
+// keep-alive is read from flag which is mapped with prefix.
+func runForwards(ctx context.Context, prefix string, target *internal.Target, forwards []*internal.Forward) {
+	for _, f := range forwards {
+		internal.PrintReady("start-port-forwarding "+f.String(), _credential.awsConfig.Region, target.Name)
+	}
+
+	// stop port forwarding with ctrl+c.
+	sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	err := internal.StartForwards(sigCtx, *_credential.awsConfig, target.Name, forwards, viper.GetBool(prefix+"-keep-alive"), _output, color.Output)
+	stop()
+	if err != nil {
+		panicRed(err)
+	}
+}
@@ -2,13 +2,9 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
-	"github.com/fatih/color"
 	"github.com/gjbae1212/gossm/internal"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -21,106 +17,51 @@ var (
 		Long:  "Exec `fwdrem` under AWS SSM with interactive CLI",
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := context.Background()
-			var (
-				target     *internal.Target
-				remotePort string
-				localPort  string
-				host       string
-				err        error
-			)
-
 			// get target
-			argTarget := strings.TrimSpace(viper.GetString("fwd-target"))
-			if argTarget != "" {
-				table, err := internal.FindInstances(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
-				for _, t := range table {
-					if t.Name == argTarget {
-						target = t
-						break
-					}
-				}
-			}
-			if target == nil {
-				target, err = internal.AskTarget(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
-			}
+			argTarget := strings.TrimSpace(viper.GetString("fwdrem-target"))
+			target := findTarget(ctx, "fwdrem", argTarget)
 
-			// get port
-			argRemotePort := strings.TrimSpace(viper.GetString("fwd-remote-port"))
-			argLocalPort := strings.TrimSpace(viper.GetString("fwd-local-port"))
-			if argRemotePort == "" {
-				askPort, err := internal.AskPorts()
-				if err != nil {
-					panicRed(err)
-				}
-				remotePort = askPort.Remote
-				localPort = askPort.Local
-			} else {
-				remotePort = argRemotePort
-				localPort = argLocalPort
-				if localPort == "" {
-					localPort = remotePort
-				}
-			}
+			argHost := strings.TrimSpace(viper.GetString("fwdrem-host"))
 
-			argHost := strings.TrimSpace(viper.GetString("fwd-host"))
-			if argHost == "" {
-				askHost, err := internal.AskHost()
-				if err != nil {
-					panicRed(err)
+			// forwards of -L don't ask ports, and -a is host of forwards which omit host.
+			forwards := getForwards("fwdrem", argHost)
+			for _, f := range forwards {
+				if f.Host == "" {
+					panicRed(fmt.Errorf("[err] not found host of forward %s, ex) -L 15432:db.internal:5432 or -a db.internal", f))
 				}
-				host = askHost
-			} else {
-				host = argHost
-			}
-
-			internal.PrintReady(fmt.Sprintf("start-port-forwarding %s -> %s", localPort, remotePort), _credential.awsConfig.Region, target.Name)
-
-			docName := "AWS-StartPortForwardingSessionToRemoteHost" // https://us-east-1.console.aws.amazon.com/systems-manager/documents/AWS-StartPortForwardingSession/description?region=us-east-1
-
-			input := &ssm.StartSessionInput{
-				DocumentName: &docName,
-				Parameters: map[string][]string{
-					"portNumber":      []string{remotePort},
-					"localPortNumber": []string{localPort},
-					"host":            []string{host},
-				},
-				Target: aws.String(target.Name),
-			}
-
-			// start session
-			session, err := internal.CreateStartSession(ctx, *_credential.awsConfig, input)
-			if err != nil {
-				panicRed(err)
-			}
-
-			sessJson, err := json.Marshal(session)
-			if err != nil {
-				panicRed(err)
-			}
-
-			paramsJson, err := json.Marshal(input)
-			if err != nil {
-				panicRed(err)
 			}
+			if len(forwards) == 0 {
+				var remotePort, localPort, host string
+				argRemotePort := strings.TrimSpace(viper.GetString("fwdrem-remote-port"))
+				argLocalPort := strings.TrimSpace(viper.GetString("fwdrem-local-port"))
+				if argRemotePort == "" {
+					askPort, err := internal.AskPorts()
+					if err != nil {
+						panicRed(err)
+					}
+					remotePort = askPort.Remote
+					localPort = askPort.Local
+				} else {
+					remotePort = argRemotePort
+					localPort = argLocalPort
+					if localPort == "" {
+						localPort = remotePort
+					}
+				}
 
-			if err := internal.CallProcess(_credential.ssmPluginPath, string(sessJson),
-				_credential.awsConfig.Region, "StartSession",
-				_credential.awsProfile, string(paramsJson)); err != nil {
-				color.Red("[err] %v", err.Error())
+				if argHost == "" {
+					askHost, err := internal.AskHost()
+					if err != nil {
+						panicRed(err)
+					}
+					host = askHost
+				} else {
+					host = argHost
+				}
+				forwards = append(forwards, &internal.Forward{LocalPort: localPort, Host: host, RemotePort: remotePort})
 			}
 
-			// delete session
-			if err := internal.DeleteStartSession(ctx, *_credential.awsConfig, &ssm.TerminateSessionInput{
-				SessionId: session.SessionId,
-			}); err != nil {
-				panicRed(err)
-			}
+			runForwards(ctx, "fwdrem", target, forwards)
 		},
 	}
 )
@@ -133,10 +74,15 @@ func init() {
 	fwdremCommand.Flags().StringP("host", "a", "", "[optional] it is remote host address to proxy to.")
 
 	// mapping viper
-	viper.BindPFlag("fwd-remote-port", fwdremCommand.Flags().Lookup("remote"))
-	viper.BindPFlag("fwd-local-port", fwdremCommand.Flags().Lookup("local"))
-	viper.BindPFlag("fwd-target", fwdremCommand.Flags().Lookup("target"))
-	viper.BindPFlag("fwd-host", fwdremCommand.Flags().Lookup("host"))
+	// keys are separated from fwd, because viper keeps only the last flag mapped to a key.
+	viper.BindPFlag("fwdrem-remote-port", fwdremCommand.Flags().Lookup("remote"))
+	viper.BindPFlag("fwdrem-local-port", fwdremCommand.Flags().Lookup("local"))
+	viper.BindPFlag("fwdrem-target", fwdremCommand.Flags().Lookup("target"))
+	viper.BindPFlag("fwdrem-host", fwdremCommand.Flags().Lookup("host"))
+	addForwardFlags(fwdremCommand, "fwdrem")
+	addTargetFilterFlags(fwdremCommand, "fwdrem")
+	addTargetQueryFlag(fwdremCommand, "fwdrem")
+	addECSTargetFlag(fwdremCommand, "fwdrem")
 
 	rootCmd.AddCommand(fwdremCommand)
 }
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"io"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// listCommand lists targets without interactive CLI, such as inventory for scripts and dashboards.
+	listCommand = &cobra.Command{
+		Use:   "list",
+		Short: "List targets which are connected to AWS SSM without interactive CLI",
+		Long: `List targets which are connected to AWS SSM without interactive CLI, targets are found the same as other commands.
+--output json and yaml have every field of targets, table and csv have columns.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			scopes := getTargetScopes(ctx)
+
+			targets, err := internal.ListTargets(ctx, scopes, getTargetFilter("list"), viper.GetString("list-query"))
+			if err != nil {
+				panicRed(err)
+			}
+			if err := internal.SortTargetsBy(targets, viper.GetStringSlice("list-sort")); err != nil {
+				panicRed(err)
+			}
+
+			list := &internal.TargetList{Targets: targets, Columns: getListColumns(scopes)}
+			if err := _output.Write(list, func(w io.Writer) {
+				if len(targets) == 0 {
+					color.Yellow("[list] not found targets")
+					return
+				}
+				internal.PrintTargets(w, list.Targets, list.Columns)
+			}); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+)
+
+// getListColumns returns columns of list, wide adds columns such as os, launch and tags which aren't in columns.
+func getListColumns(scopes *internal.Scopes) []string {
+	columns := getTargetColumns(scopes, internal.DefaultListColumns)
+	if !viper.GetBool("list-wide") {
+		return columns
+	}
+
+	exists := map[string]bool{}
+	for _, column := range columns {
+		exists[column] = true
+	}
+	for _, column := range internal.WideListColumns {
+		if !exists[column] {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+func init() {
+	listCommand.Flags().StringP("query", "q", "", "[optional] fuzzy search targets by name, id, ip and tags, ex) 'prod api'")
+	listCommand.Flags().StringSlice("sort", nil, "[optional] sort targets by columns in order, descending with - prefix, ex) az,-agent")
+	listCommand.Flags().BoolP("wide", "w", false, "[optional] add columns such as platform, os, resource, launch and tags")
+
+	viper.BindPFlag("list-query", listCommand.Flags().Lookup("query"))
+	viper.BindPFlag("list-sort", listCommand.Flags().Lookup("sort"))
+	viper.BindPFlag("list-wide", listCommand.Flags().Lookup("wide"))
+	addTargetFilterFlags(listCommand, "list")
+
+	rootCmd.AddCommand(listCommand)
+}
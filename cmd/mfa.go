@@ -3,30 +3,36 @@ package cmd
 import (
 	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 const (
-	virtualMFADevice    = "arn:aws:iam::%s:mfa/%s"
 	mfaCredentialFormat = "[%s]\naws_access_key_id = %s\naws_secret_access_key = %s\naws_session_token = %s\n"
 )
 
 var (
 	mfaCommand = &cobra.Command{
 		Use:   "mfa",
-		Short: "It's to authenticate MFA on AWS, and save authenticated mfa token in .aws/credentials_mfa.",
+		Short: "It's to authenticate MFA on AWS, and cache authenticated mfa token of profile in ~/.gossm/credentials.",
 		Long: `
-This command is to authenticate MFA on AWS, and save authenticated mfa token in .aws/credentials_mfa.
-Export to "AWS_SHARED_CREDENTIALS_FILE"" environment variables as "$HOME/.aws/credentials_mfa". 
-So you can conveniently use aws-cli.
+This command is to authenticate MFA on AWS, and cache authenticated mfa token of profile in ~/.gossm/credentials/<profile>.json.
+Other commands of the same profile use it until it expires.
+Other commands also ask mfa code on demand if profile has mfa_serial in .aws/config or cached token expired.
+Cached tokens of every profile are also written to .aws/credentials_mfa, so you can conveniently use aws-cli with
+"AWS_SHARED_CREDENTIALS_FILE" environment variables as "$HOME/.aws/credentials_mfa".
 `,
 		Run: func(cmd *cobra.Command, args []string) {
 			ctx := context.Background()
@@ -41,52 +47,267 @@ So you can conveniently use aws-cli.
 				panicRed(fmt.Errorf("invalid mfa code"))
 			}
 
-			// get client for aws sts.
-			client := sts.NewFromConfig(*_credential.awsConfig)
-
-			deadline := viper.GetInt32("mfa-deadline")
+			cache := getMFACache()
+			deadline := time.Duration(viper.GetInt32("mfa-deadline")) * time.Second
 			device := viper.GetString("mfa-device")
 
-			// if device params is empty, getting virtual mfa device.
+			// if device params is empty, device of cached token or virtual mfa device is used.
 			if device == "" {
-				identity, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+				if cred, err := cache.Get(_credential.awsProfile); err == nil && cred != nil {
+					device = cred.Device
+				}
+			}
+			if device == "" {
+				virtual, err := internal.FindMFADevice(ctx, *_credential.awsConfig)
 				if err != nil {
 					panicRed(err)
 				}
-				username := strings.Split(*identity.Arn, "/")[1]
-				device = fmt.Sprintf(virtualMFADevice, aws.ToString(identity.Account), username)
+				device = virtual
 			}
 
-			output, err := client.GetSessionToken(ctx, &sts.GetSessionTokenInput{
-				DurationSeconds: aws.Int32(deadline),
-				SerialNumber:    aws.String(device),
-				TokenCode:       aws.String(code),
-			})
+			cred, err := internal.GetMFASessionToken(ctx, *_credential.awsConfig, _credential.awsProfile, device, code, deadline)
 			if err != nil {
 				panicRed(err)
 			}
+			if err := cache.Set(cred); err != nil {
+				panicRed(err)
+			}
+			if err := writeMFACredentialsFile(cache); err != nil {
+				panicRed(err)
+			}
+
+			result := newMFAResult(cache, cred)
+			if err := _output.Write(result, func(w io.Writer) {
+				fmt.Fprintln(w, color.GreenString("[SUCCESS] Temporary MFA credential of profile %s creates %s (%s)", result.Profile, result.CredentialFile, result.Expiration))
+				fmt.Fprintf(w, "%s `%s` %s\n",
+					color.YellowString("[INFO] For Use AWS CLI using temporary MFA credential, Set To"),
+					color.CyanString("export AWS_SHARED_CREDENTIALS_FILE=%s", _credentialWithMFA),
+					color.YellowString("in $HOME/.bash_profile, $HOME/.zshrc."),
+				)
+			}); err != nil {
+				panicRed(err)
+			}
+		},
+	}
 
-			newCredential := fmt.Sprintf(mfaCredentialFormat, _defaultProfile, *output.Credentials.AccessKeyId,
-				*output.Credentials.SecretAccessKey, *output.Credentials.SessionToken)
-			if err := ioutil.WriteFile(_credentialWithMFA, []byte(newCredential), 0600); err != nil {
+	// mfaStatusCommand lists cached mfa tokens of every profile with remaining lifetime.
+	mfaStatusCommand = &cobra.Command{
+		Use:   "status",
+		Short: "List cached mfa tokens of profiles with remaining lifetime",
+		Long:  "List cached mfa tokens of profiles in ~/.gossm/credentials with remaining lifetime, secrets aren't printed.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cache := getMFACache()
+			creds, err := cache.List()
+			if err != nil {
 				panicRed(err)
 			}
 
-			color.Green("[SUCCESS] Temporary MFA credential creates %s (%s)", _credentialWithMFA, output.Credentials.Expiration.UTC())
-			fmt.Printf("%s `%s` %s\n",
-				color.YellowString("[INFO] For Use AWS CLI using temporary MFA credential, Set To"),
-				color.CyanString("export AWS_SHARED_CREDENTIALS_FILE=%s", _credentialWithMFA),
-				color.YellowString("in $HOME/.bash_profile, $HOME/.zshrc."),
-			)
+			results := make([]*mfaResult, 0, len(creds))
+			for _, cred := range creds {
+				results = append(results, newMFAResult(cache, cred))
+			}
+			if err := _output.Write(results, func(w io.Writer) {
+				if len(creds) == 0 {
+					color.Yellow("[mfa] not found cached mfa tokens")
+					return
+				}
+				internal.PrintMFACredentials(w, cache, creds)
+			}); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+
+	// mfaClearCommand removes cached mfa tokens of current profile or every profile.
+	mfaClearCommand = &cobra.Command{
+		Use:   "clear",
+		Short: "Clear cached mfa token of current profile",
+		Long:  "Clear cached mfa token of current profile, or all of profiles with --all.",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			profile := _credential.awsProfile
+			if viper.GetBool("mfa-clear-all") {
+				profile = ""
+			}
+
+			cache := getMFACache()
+			if err := cache.Clear(profile); err != nil {
+				panicRed(err)
+			}
+			if err := writeMFACredentialsFile(cache); err != nil {
+				panicRed(err)
+			}
+			if profile == "" {
+				color.Green("[mfa] cleared all of cached mfa tokens")
+			} else {
+				color.Green("[mfa] cleared cached mfa token of profile %s", profile)
+			}
 		},
 	}
 )
 
+// mfaResult is temporary credential which is created by mfa, it is written as output without secrets.
+type mfaResult struct {
+	Profile        string    `json:"profile"`
+	Device         string    `json:"device"`
+	CredentialFile string    `json:"credentialFile"`
+	Expiration     time.Time `json:"expiration"`
+	// Remaining is remaining seconds of lifetime, it is 0 if token is expired.
+	Remaining int64 `json:"remaining"`
+}
+
+func newMFAResult(cache *internal.MFACache, cred *internal.MFACredential) *mfaResult {
+	return &mfaResult{
+		Profile:        cred.Profile,
+		Device:         cred.Device,
+		CredentialFile: cache.Path(cred.Profile),
+		Expiration:     cred.Expiration.UTC(),
+		Remaining:      int64(cache.Remaining(cred).Seconds()),
+	}
+}
+
+// getMFACache returns cache of mfa tokens in gossm home.
+func getMFACache() *internal.MFACache {
+	return internal.NewMFACache(filepath.Join(_credential.gossmHomePath, "credentials"))
+}
+
+// getMFAConfig returns config of profile which uses cached mfa token, it returns false if token isn't cached or expired.
+// If profile needs mfa, such as mfa_serial in shared config or expired token, mfa code is asked to issue a token on demand.
+// Expired token and token which expires soon are warned.
+func getMFAConfig(ctx context.Context, profile string) (aws.Config, bool) {
+	cache := getMFACache()
+	cred, err := cache.Get(profile)
+	if err != nil {
+		color.Yellow("[Warning] cached mfa token of profile %s isn't used, such as %s", profile, err.Error())
+		cred = nil
+	}
+
+	var device string
+	if cred != nil {
+		switch remaining := cache.Remaining(cred); {
+		case remaining == 0:
+			color.Yellow("[Expire] mfa token of profile %s expired at %s", profile, cred.Expiration.Local())
+			device = cred.Device
+			cred = nil
+		case remaining < internal.MFAExpiryWarning:
+			color.Yellow("[Warning] mfa token of profile %s expires in %s, authenticate again with gossm mfa", profile, remaining)
+		}
+	}
+
+	if cred == nil {
+		if cred, err = askMFACredential(ctx, cache, profile, device); err != nil {
+			color.Yellow("[Warning] mfa token of profile %s isn't issued, such as %s", profile, err.Error())
+			return aws.Config{}, false
+		}
+		if cred == nil {
+			return aws.Config{}, false
+		}
+	}
+
+	// region and other settings of profile are read from shared config files, and credentials are replaced with token.
+	// role of profile isn't assumed again, so token provider is never called.
+	cfg, err := internal.NewMFASharedConfig(ctx, profile,
+		[]string{config.DefaultSharedConfigFilename()}, []string{config.DefaultSharedCredentialsFilename()},
+		func() (string, error) { return "", fmt.Errorf("[err] mfa code isn't needed") })
+	if err != nil {
+		if cfg, err = internal.NewConfig(ctx, cred.AccessKeyId, cred.SecretAccessKey, cred.SessionToken, "", nil); err != nil {
+			color.Yellow("[Warning] cached mfa token of profile %s isn't used, such as %s", profile, err.Error())
+			return aws.Config{}, false
+		}
+	}
+	cfg.Credentials = cred
+	return cfg, true
+}
+
+// askMFACredential asks mfa code and issues a token of profile which needs mfa, and caches it.
+// Profile needs mfa if it has mfa_serial in shared config, or device of its expired token is passed.
+// It returns nil if profile doesn't need mfa.
+func askMFACredential(ctx context.Context, cache *internal.MFACache, profile, device string) (*internal.MFACredential, error) {
+	configFiles := []string{config.DefaultSharedConfigFilename()}
+	credFiles := []string{config.DefaultSharedCredentialsFilename()}
+
+	mfaProfile, err := internal.FindMFAProfile(ctx, profile, configFiles, credFiles)
+	if err != nil {
+		return nil, err
+	}
+	if mfaProfile.Device == "" {
+		mfaProfile.Device = device
+	}
+	if mfaProfile.Device == "" {
+		return nil, nil
+	}
+	// mfa code can't be asked in scripts, such as stdin is a pipe.
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil, fmt.Errorf("[err] profile %s needs mfa, authenticate with gossm mfa <code>", profile)
+	}
+
+	tokenProvider := func() (string, error) { return internal.AskMFACode(profile, mfaProfile.Device) }
+	cfg, err := internal.NewMFASharedConfig(ctx, profile, configFiles, credFiles, tokenProvider)
+	if err != nil {
+		return nil, err
+	}
+
+	var cred *internal.MFACredential
+	if mfaProfile.RoleArn != "" {
+		cred, err = internal.AssumeMFARole(ctx, cfg, profile, mfaProfile.Device)
+	} else {
+		code, askErr := tokenProvider()
+		if askErr != nil {
+			return nil, askErr
+		}
+		deadline := time.Duration(viper.GetInt32("mfa-deadline")) * time.Second
+		cred, err = internal.GetMFASessionToken(ctx, cfg, profile, mfaProfile.Device, code, deadline)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := cache.Set(cred); err != nil {
+		return nil, err
+	}
+	if err := writeMFACredentialsFile(cache); err != nil {
+		return nil, err
+	}
+	color.Green("[mfa] mfa token of profile %s is cached until %s", profile, cred.Expiration.Local())
+	return cred, nil
+}
+
+// writeMFACredentialsFile writes cached mfa tokens which aren't expired to .aws/credentials_mfa, a section per profile for aws-cli.
+func writeMFACredentialsFile(cache *internal.MFACache) error {
+	creds, err := cache.List()
+	if err != nil {
+		return err
+	}
+
+	var sections []string
+	for _, cred := range creds {
+		if cache.Remaining(cred) > 0 {
+			sections = append(sections, fmt.Sprintf(mfaCredentialFormat, cred.Profile, cred.AccessKeyId, cred.SecretAccessKey, cred.SessionToken))
+		}
+	}
+	if len(sections) == 0 {
+		if err := os.Remove(_credentialWithMFA); err != nil && !os.IsNotExist(err) {
+			return internal.WrapError(err)
+		}
+		return nil
+	}
+	if err := ioutil.WriteFile(_credentialWithMFA, []byte(strings.Join(sections, "\n")), 0600); err != nil {
+		return internal.WrapError(err)
+	}
+	return nil
+}
+
 func init() {
-	mfaCommand.Flags().Int32P("deadline", "", 21600, "[optional] deadline seconds for issued credentials. (default is 6 hours)")
-	mfaCommand.Flags().StringP("device", "", "", "[optional] mfa device. (default is your virtual mfa device)")
+	mfaCommand.Flags().Int32P("deadline", "", int32(internal.DefaultMFADuration.Seconds()), "[optional] deadline seconds for issued credentials. (default is 6 hours)")
+	mfaCommand.Flags().StringP("device", "", "", "[optional] mfa device. (default is device of cached token or your virtual mfa device)")
+	mfaClearCommand.Flags().Bool("all", false, "[optional] clear cached mfa tokens of all of profiles")
 
 	viper.BindPFlag("mfa-deadline", mfaCommand.Flags().Lookup("deadline"))
 	viper.BindPFlag("mfa-device", mfaCommand.Flags().Lookup("device"))
+	viper.BindPFlag("mfa-clear-all", mfaClearCommand.Flags().Lookup("all"))
+
+	mfaCommand.AddCommand(mfaStatusCommand)
+	mfaCommand.AddCommand(mfaClearCommand)
 	rootCmd.AddCommand(mfaCommand)
 }
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// multiCommand opens shell sessions to multiple targets in process, and broadcasts each typed line to them.
+	multiCommand = &cobra.Command{
+		Use:   "multi",
+		Short: "Exec `multi` under AWS SSM, which broadcasts typed lines to shells of multiple targets",
+		Long: `Exec multi under AWS SSM, which opens shells of selected targets and broadcasts each typed line to them like pssh.
+Output of each target is prefixed with its name, and lines which start with : control targets,
+  :hosts                   list targets and whether they are enabled
+  :toggle <host|index>...  enable or disable targets
+  :only <host|index>...    enable only these targets
+  :all                     enable every target
+  :quit                    close every session (or ctrl+d)
+ctrl+c is broadcast to enabled targets.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// get targets
+			argTarget := strings.TrimSpace(viper.GetString("multi-target"))
+			targets := findTargets(ctx, "multi", argTarget)
+
+			// sessions are opened concurrently, and targets which fail are skipped.
+			names := multiHostNames(targets)
+			streams := make([]io.ReadWriteCloser, len(targets))
+			wg := new(sync.WaitGroup)
+			for i, t := range targets {
+				internal.PrintReady("multi", targetConfig(t).Region, t.Name)
+				wg.Add(1)
+				go func(i int, t *internal.Target) {
+					defer wg.Done()
+					stream, err := internal.OpenSessionStream(ctx, targetConfig(t), &ssm.StartSessionInput{Target: aws.String(t.Name)})
+					if err != nil {
+						fmt.Fprintln(color.Output, color.RedString("[err] %s %s", names[i], err.Error()))
+						return
+					}
+					streams[i] = stream
+				}(i, t)
+			}
+			wg.Wait()
+
+			session := internal.NewMultiSession(os.Stdout)
+			for i, stream := range streams {
+				if stream != nil {
+					session.Add(names[i], stream)
+				}
+			}
+			if len(session.Hosts()) == 0 {
+				panicRed(fmt.Errorf("[err] failed to open sessions of every target"))
+			}
+
+			// echo of terminal is disabled, so a broadcast line isn't printed again by every target.
+			session.Broadcast("stty -echo\n")
+			fmt.Fprintln(color.Output, color.YellowString("Type a line to broadcast, :hosts, :toggle <host|index>, :only <host|index>, :all and :quit"))
+
+			// ctrl+c interrupts commands of targets instead of gossm.
+			sigs := make(chan os.Signal, 1)
+			signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+			defer signal.Stop(sigs)
+			go func() {
+				for sig := range sigs {
+					if sig != os.Interrupt {
+						cancel()
+						return
+					}
+					session.Interrupt()
+				}
+			}()
+
+			if err := session.Run(ctx, os.Stdin); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+)
+
+// multiHostNames returns names of targets which prefix their output, instance id is used if Name tag is empty or duplicated.
+func multiHostNames(targets []*internal.Target) []string {
+	counts := map[string]int{}
+	for _, t := range targets {
+		counts[t.InstanceName]++
+	}
+
+	names := make([]string, 0, len(targets))
+	for _, t := range targets {
+		switch {
+		case t.InstanceName == "":
+			names = append(names, t.Name)
+		case counts[t.InstanceName] > 1:
+			names = append(names, t.InstanceName+"/"+t.Name)
+		default:
+			names = append(names, t.InstanceName)
+		}
+	}
+	return names
+}
+
+func init() {
+	multiCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId.")
+	viper.BindPFlag("multi-target", multiCommand.Flags().Lookup("target"))
+	addTargetFilterFlags(multiCommand, "multi")
+	addTargetQueryFlag(multiCommand, "multi")
+
+	rootCmd.AddCommand(multiCommand)
+}
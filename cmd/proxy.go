@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	// proxyCommand relays stdin and stdout with AWS-StartSSHSession, so it can be used as ProxyCommand of ssh.
+	proxyCommand = &cobra.Command{
+		Use:   "proxy [host] [port]",
+		Short: "Exec `proxy` under AWS SSM, which can be used as ProxyCommand of ssh",
+		Long: `Exec proxy under AWS SSM, which relays stdin and stdout with AWS-StartSSHSession.
+It can be used as ProxyCommand of ssh, ex) ~/.ssh/config
+  Host i-* mi-*
+    ProxyCommand gossm proxy %h %p
+host is instance id, ip, domain or Name tag of instance.`,
+		Args: cobra.MaximumNArgs(2),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			host := strings.TrimSpace(viper.GetString("proxy-target"))
+			port := strings.TrimSpace(viper.GetString("proxy-port"))
+			if len(args) > 0 {
+				host = strings.TrimSpace(args[0])
+			}
+			if len(args) > 1 {
+				port = strings.TrimSpace(args[1])
+			}
+			if host == "" {
+				panicRed(fmt.Errorf("[err] required target or host argument"))
+			}
+			if port == "" {
+				port = "22"
+			}
+
+			targetName, err := findInstanceIdByHost(ctx, host)
+			if err != nil {
+				panicRed(err)
+			}
+
+			input := &ssm.StartSessionInput{
+				DocumentName: aws.String("AWS-StartSSHSession"),
+				Parameters:   map[string][]string{"portNumber": []string{port}},
+				Target:       aws.String(targetName),
+			}
+
+			session, err := internal.CreateStartSession(ctx, *_credential.awsConfig, input)
+			if err != nil {
+				panicRed(err)
+			}
+
+			streamErr := internal.StartStreamSession(ctx, session, os.Stdin, os.Stdout)
+
+			if err := internal.DeleteStartSession(ctx, *_credential.awsConfig, &ssm.TerminateSessionInput{
+				SessionId: session.SessionId,
+			}); err != nil {
+				panicRed(err)
+			}
+			if streamErr != nil {
+				panicRed(streamErr)
+			}
+		},
+	}
+)
+
+// findInstanceIdByHost returns instance id by instance id, ip, domain or Name tag.
+func findInstanceIdByHost(ctx context.Context, host string) (string, error) {
+	if strings.HasPrefix(host, "i-") || strings.HasPrefix(host, "mi-") {
+		return host, nil
+	}
+
+	if ips, err := net.LookupIP(host); err == nil && len(ips) > 0 {
+		instId, err := internal.FindInstanceIdByIp(ctx, *_credential.awsConfig, ips[0].String())
+		if err != nil {
+			return "", err
+		}
+		if instId != "" {
+			return instId, nil
+		}
+	}
+
+	instId, err := internal.FindInstanceIdByName(ctx, *_credential.awsConfig, host)
+	if err != nil {
+		return "", err
+	}
+	if instId == "" {
+		return "", fmt.Errorf("[err] not found matched server %s", host)
+	}
+	return instId, nil
+}
+
+// proxyCommandLine returns a command line which uses proxy command of gossm for a target.
+// Proxy command uses credentials of credentialsFile if it isn't empty, such as roles which are assumed by gossm.
+func proxyCommandLine(targetName, credentialsFile string) (string, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	line := fmt.Sprintf("%s proxy --target %s --port %%p --profile %s --region %s",
+		shellQuote(executable), targetName, shellQuote(_credential.awsProfile), _credential.awsConfig.Region)
+	if credentialsFile != "" {
+		line += " --credentials-file " + shellQuote(credentialsFile)
+	}
+	return line, nil
+}
+
+// writeRoleCredentials writes credentials of assumed roles to a temporary file in gossm home, and returns it.
+// gossm of proxy command is invoked by ssh which has stdin, so it uses these credentials instead of assuming roles again with mfa code.
+// It returns an empty path if roles aren't assumed.
+func writeRoleCredentials(ctx context.Context) (string, error) {
+	if getRoleOptions() == nil {
+		return "", nil
+	}
+	cred, err := _credential.awsConfig.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", internal.WrapError(err)
+	}
+
+	// temporary file is created with 0600.
+	f, err := ioutil.TempFile(_credential.gossmHomePath, "credentials_role_*")
+	if err != nil {
+		return "", internal.WrapError(err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, mfaCredentialFormat, _credential.awsProfile, cred.AccessKeyID, cred.SecretAccessKey, cred.SessionToken); err != nil {
+		os.Remove(f.Name())
+		return "", internal.WrapError(err)
+	}
+	return f.Name(), nil
+}
+
+// shellQuote quotes s as a word of shell, which runs ProxyCommand of ssh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func init() {
+	proxyCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId, ip, domain or Name tag. (or first argument)")
+	proxyCommand.Flags().StringP("port", "", "", "[optional] remote port to connect. (or second argument, default is 22)")
+	// credentials of roles are handed by gossm which invokes ssh, so it isn't shown in help.
+	proxyCommand.Flags().String("credentials-file", "", "[optional] shared credentials file which has credentials of profile")
+	proxyCommand.Flags().MarkHidden("credentials-file")
+
+	viper.BindPFlag("proxy-target", proxyCommand.Flags().Lookup("target"))
+	viper.BindPFlag("proxy-port", proxyCommand.Flags().Lookup("port"))
+	viper.BindPFlag("proxy-credentials-file", proxyCommand.Flags().Lookup("credentials-file"))
+
+	rootCmd.AddCommand(proxyCommand)
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShellQuote(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, s := range []string{"default", "it's", "/tmp/a b/gossm", `'$HOME' "x"`} {
+		output, err := exec.Command("sh", "-c", "printf %s "+shellQuote(s)).Output()
+		assert.NoError(err)
+		assert.Equal(s, string(output))
+	}
+}
+
+func TestWriteRoleCredentials(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(c *Credential) { _credential = c }(_credential)
+	defer viper.Set("role-arn", nil)
+	_credential = &Credential{
+		awsProfile:    "dev",
+		awsConfig:     &aws.Config{Region: "us-east-1", Credentials: credentials.NewStaticCredentialsProvider("key", "secret", "token")},
+		gossmHomePath: t.TempDir(),
+	}
+
+	// credentials aren't written without roles.
+	file, err := writeRoleCredentials(context.Background())
+	assert.NoError(err)
+	assert.Empty(file)
+
+	// credentials of roles are handed to proxy command, instead of flags of roles.
+	viper.Set("role-arn", []string{"arn:aws:iam::123456789012:role/hub"})
+	file, err = writeRoleCredentials(context.Background())
+	assert.NoError(err)
+	defer os.Remove(file)
+	info, err := os.Stat(file)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	cfg, err := internal.NewSharedConfig(context.Background(), "dev", []string{}, []string{file})
+	assert.NoError(err)
+	cred, err := cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(err)
+	assert.Equal("key", cred.AccessKeyID)
+	assert.Equal("token", cred.SessionToken)
+
+	line, err := proxyCommandLine("i-1", file)
+	assert.NoError(err)
+	assert.Contains(line, "--credentials-file "+shellQuote(file))
+	assert.NotContains(line, "--role-arn")
+}
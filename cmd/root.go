@@ -6,14 +6,17 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/fatih/color"
 	"github.com/gjbae1212/gossm/internal"
+	"github.com/mattn/go-colorable"
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"golang.org/x/term"
 )
 
 const (
@@ -30,6 +33,8 @@ var (
 
 	_version                 string
 	_credential              *Credential
+	_scopes                  *internal.Scopes
+	_output                  *internal.Output
 	_credentialWithMFA       = fmt.Sprintf("%s_mfa", config.DefaultSharedCredentialsFilename())
 	_credentialWithTemporary = fmt.Sprintf("%s_temporary", config.DefaultSharedCredentialsFilename())
 )
@@ -38,7 +43,6 @@ type Credential struct {
 	awsProfile    string
 	awsConfig     *aws.Config
 	gossmHomePath string
-	ssmPluginPath string
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
@@ -52,28 +56,25 @@ func Execute(version string) {
 
 // panicRed raises error with text.
 func panicRed(err error) {
-	fmt.Println(color.RedString("[err] %s", err.Error()))
+	fmt.Fprintln(color.Output, color.RedString("[err] %s", err.Error()))
 	os.Exit(1)
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	_credential = &Credential{}
-	// 1. get aws profile
-	awsProfile := viper.GetString("profile")
-	if awsProfile == "" {
-		if os.Getenv("AWS_PROFILE") != "" {
-			awsProfile = os.Getenv("AWS_PROFILE")
-		} else {
-			awsProfile = _defaultProfile
-		}
+	// check subcommands
+	args := os.Args[1:]
+	subcmd, _, err := rootCmd.Find(args)
+	if err != nil {
+		panicRed(internal.WrapError(err))
 	}
-	_credential.awsProfile = awsProfile
 
-	// 2. get region
-	awsRegion := viper.GetString("region")
+	// messages are written to stderr, so stdout has only output such as results of commands.
+	// proxy command also uses stdout as a stream of ssh.
+	color.Output = colorable.NewColorableStderr()
 
-	// 3. update or create aws ssm plugin.
+	_credential = &Credential{}
+	// 1. create gossm home.
 	home, err := homedir.Dir()
 	if err != nil {
 		panicRed(internal.WrapError(err))
@@ -86,38 +87,73 @@ func initConfig() {
 		}
 	}
 
-	plugin, err := internal.GetSsmPlugin()
+	// 2. read config files, flags take precedence over them.
+	if err := readConfigFiles(_credential.gossmHomePath); err != nil {
+		panicRed(err)
+	}
+	_output, err = internal.NewOutput(viper.GetString("output"), os.Stdout)
 	if err != nil {
-		panicRed(internal.WrapError(err))
+		panicRed(err)
+	}
+	if err := checkOutputFormat(subcmd, _output.Format); err != nil {
+		panicRed(err)
+	}
+
+	// 3. get aws profile, flag > AWS_PROFILE environment variable > config files > default.
+	awsProfile := viper.GetString("profile")
+	if !rootCmd.PersistentFlags().Lookup("profile").Changed && os.Getenv("AWS_PROFILE") != "" {
+		awsProfile = os.Getenv("AWS_PROFILE")
+	}
+	if awsProfile == "" {
+		awsProfile = _defaultProfile
+	}
+	_credential.awsProfile = awsProfile
+
+	// 4. get region
+	awsRegion := viper.GetString("region")
+
+	// cache command and sub-commands of mfa only handle files in gossm home, and run command invokes gossm again,
+	// so they don't need credentials.
+	if subcmd.HasParent() && (subcmd.Parent().Name() == cacheCommand.Name() || subcmd.Parent().Name() == mfaCommand.Name()) ||
+		subcmd.Name() == runCommand.Name() {
+		return
+	}
+
+	// roles are assumed after credentials of current profile, so other profiles can't be chained with them.
+	if subcmd.Name() != mfaCommand.Name() {
+		if err := checkRoleProfiles(getRoleOptions(), _credential.awsProfile, viper.GetStringSlice("profiles")); err != nil {
+			panicRed(err)
+		}
 	}
 
-	_credential.ssmPluginPath = filepath.Join(_credential.gossmHomePath, internal.GetSsmPluginName())
-	if info, err := os.Stat(_credential.ssmPluginPath); os.IsNotExist(err) {
-		color.Green("[create] aws ssm plugin")
-		if err := ioutil.WriteFile(_credential.ssmPluginPath, plugin, 0755); err != nil {
+	// sessions are handled by gossm itself, so aws ssm plugin created by old versions isn't needed.
+	for _, name := range []string{"session-manager-plugin", "session-manager-plugin.exe"} {
+		if _, err := os.Stat(filepath.Join(_credential.gossmHomePath, name)); err == nil {
+			color.Green("[delete] aws ssm plugin")
+			os.Remove(filepath.Join(_credential.gossmHomePath, name))
+		}
+	}
+
+	// credentials of roles which are assumed by gossm invoking ssh are handed to proxy command,
+	// because mfa code can't be asked with stdin of ssh.
+	if f := viper.GetString("proxy-credentials-file"); subcmd.Name() == proxyCommand.Name() && f != "" {
+		awsConfig, err := internal.NewSharedConfig(context.Background(), _credential.awsProfile, []string{}, []string{f})
+		if err != nil {
 			panicRed(internal.WrapError(err))
 		}
-	} else if err != nil {
-		panicRed(internal.WrapError(err))
-	} else {
-		if int(info.Size()) != len(plugin) {
-			color.Green("[update] aws ssm plugin")
-			if err := ioutil.WriteFile(_credential.ssmPluginPath, plugin, 0755); err != nil {
-				panicRed(internal.WrapError(err))
-			}
+		_credential.awsConfig = &awsConfig
+	}
+
+	// 5. use cached mfa token of profile, mfa command issues it with credentials which aren't temporary.
+	if subcmd.Name() != mfaCommand.Name() && _credential.awsConfig == nil {
+		if cfg, ok := getMFAConfig(context.Background(), _credential.awsProfile); ok {
+			_credential.awsConfig = &cfg
 		}
 	}
 
-	// 4. set shared credential.
+	// 6. set shared credential.
 	sharedCredFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
-	if sharedCredFile == "" {
-		// if gossm mfa credential is existed?
-		if _, err := os.Stat(_credentialWithMFA); !os.IsNotExist(err) {
-			color.Yellow("[Use] gossm default mfa credential file %s", _credentialWithMFA)
-			os.Setenv("AWS_SHARED_CREDENTIALS_FILE", _credentialWithMFA)
-			sharedCredFile = _credentialWithMFA
-		}
-	} else {
+	if sharedCredFile != "" && _credential.awsConfig == nil {
 		sharedCredFile, err = filepath.Abs(sharedCredFile)
 		if err != nil {
 			color.Yellow("[Warning] invalid AWS_SHARED_CREDENTIALS_FILE environments path, such as %w", err)
@@ -133,7 +169,7 @@ func initConfig() {
 	}
 
 	// if shared cred file is exist.
-	if sharedCredFile != "" {
+	if sharedCredFile != "" && _credential.awsConfig == nil {
 		awsConfig, err := internal.NewSharedConfig(context.Background(),
 			_credential.awsProfile,
 			[]string{config.DefaultSharedConfigFilename()},
@@ -146,20 +182,13 @@ func initConfig() {
 		cred, err := awsConfig.Credentials.Retrieve(context.Background())
 		// delete invalid shared credential.
 		if err != nil || cred.Expired() || cred.AccessKeyID == "" || cred.SecretAccessKey == "" {
-			color.Yellow("[Expire] gossm default mfa credential file %s", sharedCredFile)
+			color.Yellow("[Expire] credentials of profile %s in shared credential file %s", _credential.awsProfile, sharedCredFile)
 			os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
 		} else {
 			_credential.awsConfig = &awsConfig
 		}
 	}
 
-	// check subcommands
-	args := os.Args[1:]
-	subcmd, _, err := rootCmd.Find(args)
-	if err != nil {
-		panicRed(internal.WrapError(err))
-	}
-
 	switch subcmd.Use {
 	case "mfa": // mfa command doesn't use session token.
 		if _credential.awsConfig != nil {
@@ -180,9 +209,13 @@ func initConfig() {
 		var temporaryConfig aws.Config
 
 		if os.Getenv("AWS_ACCESS_KEY_ID") != "" && os.Getenv("AWS_SECRET_ACCESS_KEY") != "" { // use global environments.
+			var envRoles *internal.RoleOptions
+			if roleArn := os.Getenv("AWS_ROLE_ARN"); roleArn != "" {
+				envRoles = &internal.RoleOptions{RoleArns: []string{roleArn}}
+			}
 			temporaryConfig, err = internal.NewConfig(context.Background(),
 				os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"),
-				os.Getenv("AWS_SESSION_TOKEN"), awsRegion, os.Getenv("AWS_ROLE_ARN"))
+				os.Getenv("AWS_SESSION_TOKEN"), awsRegion, envRoles)
 			if err != nil {
 				panicRed(internal.WrapError(err))
 			}
@@ -258,6 +291,68 @@ func initConfig() {
 		_credential.awsConfig.Region = askRegion.Name
 	}
 	color.Green("region (%s)", _credential.awsConfig.Region)
+
+	// assume roles of --role-arn in order, such as hub account to workload account.
+	// mfa command authenticates credentials of profile, so roles aren't assumed.
+	if roles := getRoleOptions(); roles != nil && subcmd.Name() != mfaCommand.Name() {
+		awsConfig := internal.AssumeRoles(*_credential.awsConfig, roles)
+		if _, err := awsConfig.Credentials.Retrieve(context.Background()); err != nil {
+			panicRed(internal.WrapError(err))
+		}
+		_credential.awsConfig = &awsConfig
+		color.Green("role (%s)", roles.RoleArns[len(roles.RoleArns)-1])
+	}
+}
+
+// checkOutputFormat returns an error if results of cmd can't be written in format.
+// It is checked before cmd runs, so commands such as cmd aren't sent to targets when results can't be written.
+func checkOutputFormat(cmd *cobra.Command, format string) error {
+	// csv needs results which have rows, only list has them.
+	if format == internal.OutputCSV && cmd != listCommand {
+		return fmt.Errorf("[err] csv output is only supported by list, use table, json or yaml")
+	}
+	return nil
+}
+
+// getRoleOptions returns options of roles which are assumed after credentials of profile, it returns nil if --role-arn isn't passed.
+// If --mfa-serial is passed, the first role is assumed with mfa code which is asked.
+func getRoleOptions() *internal.RoleOptions {
+	roleArns := viper.GetStringSlice("role-arn")
+	if len(roleArns) == 0 {
+		return nil
+	}
+
+	roles := &internal.RoleOptions{
+		RoleArns:        roleArns,
+		ExternalId:      viper.GetString("external-id"),
+		RoleSessionName: viper.GetString("role-session-name"),
+		Duration:        viper.GetDuration("duration"),
+		SerialNumber:    viper.GetString("mfa-serial"),
+	}
+	if roles.SerialNumber != "" {
+		roles.TokenProvider = func() (string, error) {
+			// mfa code can't be asked in scripts, such as proxy command of ssh.
+			if !term.IsTerminal(int(os.Stdin.Fd())) {
+				return "", fmt.Errorf("[err] mfa code of %s can't be asked, stdin isn't a terminal", roles.SerialNumber)
+			}
+			return internal.AskMFACode(_credential.awsProfile, roles.SerialNumber)
+		}
+	}
+	return roles
+}
+
+// checkRoleProfiles returns an error if roles are used with profiles other than current profile,
+// they would be found with their own credentials rather than roles, which are in other accounts.
+func checkRoleProfiles(roles *internal.RoleOptions, profile string, profiles []string) error {
+	if roles == nil {
+		return nil
+	}
+	for _, p := range profiles {
+		if p != profile {
+			return fmt.Errorf("[err] --role-arn can't be used with other profiles of --profiles, such as %s", p)
+		}
+	}
+	return nil
 }
 
 func init() {
@@ -266,8 +361,21 @@ func init() {
 	// Here you will define your flags and configuration settings.
 	// Cobra supports persistent flags, which, if defined here,
 	// will be global for your application.
-	rootCmd.PersistentFlags().StringP("profile", "p", "", `[optional] if you are having multiple aws profiles, it is one of profiles (default is AWS_PROFILE environment variable or default)`)
+	rootCmd.PersistentFlags().StringP("profile", "p", "", `[optional] if you are having multiple aws profiles, it is one of profiles (default is AWS_PROFILE environment variable, profile of config files or default)`)
 	rootCmd.PersistentFlags().StringP("region", "r", "", `[optional] it is region in AWS that would like to do something`)
+	rootCmd.PersistentFlags().Bool("refresh", false, `[optional] find instances again instead of cached instances`)
+	rootCmd.PersistentFlags().Duration("cache-ttl", 10*time.Minute, `[optional] cached instances are refreshed in background after ttl, 0 disables cache`)
+	rootCmd.PersistentFlags().StringSlice("columns", nil, `[optional] columns of targets in interactive CLI, (name, id, private-ip, public-ip, type, az, platform, os, ping, agent, resource, profile, account, region, launch, tags, tag:<key>) (default name,id,private-ip,type,az,ping)`)
+	rootCmd.PersistentFlags().StringSlice("regions", nil, `[optional] find targets in multiple regions, ex) us-east-1,ap-northeast-2`)
+	rootCmd.PersistentFlags().Bool("all-regions", false, `[optional] find targets in all of regions which are enabled in account`)
+	rootCmd.PersistentFlags().StringSlice("profiles", nil, `[optional] find targets in multiple profiles of shared config, ex) dev,prod`)
+	rootCmd.PersistentFlags().Int("parallelism", internal.DefaultScopeParallelism, `[optional] the number of profiles and regions which are found concurrently`)
+	rootCmd.PersistentFlags().String("output", internal.OutputTable, `[optional] format of results which are written to stdout, such as table, json, yaml and csv(list), messages are written to stderr`)
+	rootCmd.PersistentFlags().StringSlice("role-arn", nil, `[optional] roles which are assumed in order after credentials of profile, repeat it to chain roles, ex) --role-arn <hub role> --role-arn <workload role>`)
+	rootCmd.PersistentFlags().String("external-id", "", `[optional] external id to assume roles`)
+	rootCmd.PersistentFlags().String("role-session-name", "", `[optional] session name of assumed roles (default is generated by AWS SDK)`)
+	rootCmd.PersistentFlags().Duration("duration", 0, `[optional] lifetime of assumed roles, ex) 1h (default is 15m)`)
+	rootCmd.PersistentFlags().String("mfa-serial", "", `[optional] mfa device to assume the first role, mfa code is asked`)
 
 	// set version flag
 	rootCmd.InitDefaultVersionFlag()
@@ -275,4 +383,17 @@ func init() {
 	// mapping viper
 	viper.BindPFlag("profile", rootCmd.PersistentFlags().Lookup("profile"))
 	viper.BindPFlag("region", rootCmd.PersistentFlags().Lookup("region"))
+	viper.BindPFlag("columns", rootCmd.PersistentFlags().Lookup("columns"))
+	viper.BindPFlag("refresh", rootCmd.PersistentFlags().Lookup("refresh"))
+	viper.BindPFlag("cache-ttl", rootCmd.PersistentFlags().Lookup("cache-ttl"))
+	viper.BindPFlag("regions", rootCmd.PersistentFlags().Lookup("regions"))
+	viper.BindPFlag("all-regions", rootCmd.PersistentFlags().Lookup("all-regions"))
+	viper.BindPFlag("profiles", rootCmd.PersistentFlags().Lookup("profiles"))
+	viper.BindPFlag("parallelism", rootCmd.PersistentFlags().Lookup("parallelism"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("role-arn", rootCmd.PersistentFlags().Lookup("role-arn"))
+	viper.BindPFlag("external-id", rootCmd.PersistentFlags().Lookup("external-id"))
+	viper.BindPFlag("role-session-name", rootCmd.PersistentFlags().Lookup("role-session-name"))
+	viper.BindPFlag("duration", rootCmd.PersistentFlags().Lookup("duration"))
+	viper.BindPFlag("mfa-serial", rootCmd.PersistentFlags().Lookup("mfa-serial"))
 }
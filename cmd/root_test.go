@@ -1 +1,30 @@
 package cmd
+
+import (
+	"testing"
+
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOutputFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(checkOutputFormat(listCommand, internal.OutputCSV))
+	assert.NoError(checkOutputFormat(cmdCommand, internal.OutputJSON))
+	assert.NoError(checkOutputFormat(cmdCommand, internal.OutputTable))
+	for _, cmd := range []*cobra.Command{cmdCommand, cmdHistoryCommand, cmdShowCommand, mfaCommand, mfaStatusCommand, fwdCommand, socksCommand} {
+		assert.Error(checkOutputFormat(cmd, internal.OutputCSV), cmd.Name())
+	}
+}
+
+func TestCheckRoleProfiles(t *testing.T) {
+	assert := assert.New(t)
+
+	roles := &internal.RoleOptions{RoleArns: []string{"arn:aws:iam::123456789012:role/hub"}}
+	assert.NoError(checkRoleProfiles(nil, "dev", []string{"dev", "prod"}))
+	assert.NoError(checkRoleProfiles(roles, "dev", nil))
+	assert.NoError(checkRoleProfiles(roles, "dev", []string{"dev"}))
+	assert.Error(checkRoleProfiles(roles, "dev", []string{"dev", "prod"}))
+}
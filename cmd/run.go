@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+)
+
+var (
+	// runCommand invokes an alias in config files.
+	runCommand = &cobra.Command{
+		Use:   "run [alias] [args...]",
+		Short: "Exec an alias of ~/.gossm/config.yaml or .gossm.yaml",
+		Long: `Exec an alias of ~/.gossm/config.yaml or .gossm.yaml, args after alias are passed to its command.
+ex) aliases:
+      db-tunnel: {target: tag:Name=bastion, cmd: fwdrem, host: db.internal, remote: 5432, local: 15432}`,
+		// flags are passed to command of alias as they are.
+		DisableFlagParsing: true,
+		Run: func(cmd *cobra.Command, args []string) {
+			if len(args) > 0 && (args[0] == "-h" || args[0] == "--help") {
+				cmd.Help()
+				return
+			}
+
+			aliases, err := getAliases()
+			if err != nil {
+				panicRed(err)
+			}
+			if len(aliases) == 0 {
+				panicRed(fmt.Errorf("[err] not found aliases in %s or %s", _configFile, _projectConfigFile))
+			}
+
+			var name string
+			if len(args) > 0 {
+				name, args = strings.ToLower(args[0]), args[1:]
+			} else {
+				names := aliasNames(aliases)
+				items := make([]internal.PickerItem, 0, len(names))
+				for _, n := range names {
+					line, _ := aliases[n].commandLine()
+					items = append(items, internal.PickerItem{Label: fmt.Sprintf("%s  (%s)", n, strings.Join(line, " ")), Id: n})
+				}
+				picker := internal.NewPicker("Choose an alias:", items, false)
+				indexes, err := picker.Run("")
+				if err != nil {
+					panicRed(err)
+				}
+				name = picker.Items[indexes[0]].Id
+			}
+
+			a, ok := aliases[name]
+			if !ok {
+				panicRed(fmt.Errorf("[err] not found alias %s, such as %s", name, strings.Join(aliasNames(aliases), ", ")))
+			}
+			line, err := a.commandLine()
+			if err != nil {
+				panicRed(err)
+			}
+			line = append(line, args...)
+
+			executable, err := os.Executable()
+			if err != nil {
+				panicRed(internal.WrapError(err))
+			}
+			color.Cyan("gossm " + strings.Join(line, " "))
+			// command of alias prints its own error, so only exit code is passed.
+			if err := internal.CallProcess(executable, line...); err != nil {
+				os.Exit(1)
+			}
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(runCommand)
+}
@@ -2,13 +2,11 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/fatih/color"
 	"github.com/gjbae1212/gossm/internal"
 	"github.com/spf13/cobra"
@@ -67,35 +65,24 @@ var (
 			internal.PrintReady("scp", _credential.awsConfig.Region, targetName)
 			color.Cyan("scp " + scpCommand)
 
-			// start session
-			docName := "AWS-StartSSHSession"
-			port := "22"
-			input := &ssm.StartSessionInput{
-				DocumentName: aws.String(docName),
-				Parameters:   map[string][]string{"portNumber": []string{port}},
-				Target:       aws.String(targetName),
-			}
-
-			session, err := internal.CreateStartSession(ctx, *_credential.awsConfig, input)
+			// call scp through proxy command of gossm.
+			// credentials of roles are only valid for this connection, so they are removed after scp is over or before panicRed.
+			credentialsFile, err := writeRoleCredentials(ctx)
 			if err != nil {
 				panicRed(err)
 			}
-
-			sessJson, err := json.Marshal(session)
-			if err != nil {
-				panicRed(err)
+			cleanup := func() {
+				if credentialsFile != "" {
+					os.Remove(credentialsFile)
+				}
 			}
-
-			paramsJson, err := json.Marshal(input)
+			defer cleanup()
+			proxy, err := proxyCommandLine(targetName, credentialsFile)
 			if err != nil {
+				cleanup()
 				panicRed(err)
 			}
-
-			// call scp
-			proxy := fmt.Sprintf("ProxyCommand=%s '%s' %s %s %s '%s'",
-				_credential.ssmPluginPath, string(sessJson), _credential.awsConfig.Region,
-				"StartSession", _credential.awsProfile, string(paramsJson))
-			sshArgs := []string{"-o", proxy}
+			sshArgs := []string{"-o", "ProxyCommand=" + proxy}
 			for _, sep := range strings.Split(scpCommand, " ") {
 				if sep != "" {
 					sshArgs = append(sshArgs, sep)
@@ -105,12 +92,6 @@ var (
 			if err := internal.CallProcess("scp", sshArgs...); err != nil {
 				color.Red("%v", err)
 			}
-
-			if err := internal.DeleteStartSession(ctx, *_credential.awsConfig, &ssm.TerminateSessionInput{
-				SessionId: session.SessionId,
-			}); err != nil {
-				panicRed(err)
-			}
 		},
 	}
 )
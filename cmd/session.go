@@ -2,7 +2,6 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"strings"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -27,24 +26,7 @@ var (
 
 			// get target
 			argTarget := strings.TrimSpace(viper.GetString("start-session-target"))
-			if argTarget != "" {
-				table, err := internal.FindInstances(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
-				for _, t := range table {
-					if t.Name == argTarget {
-						target = t
-						break
-					}
-				}
-			}
-			if target == nil {
-				target, err = internal.AskTarget(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
-			}
+			target = findTarget(ctx, "start-session", argTarget)
 			internal.PrintReady("start-session", _credential.awsConfig.Region, target.Name)
 
 			input := &ssm.StartSessionInput{Target: aws.String(target.Name)}
@@ -53,19 +35,7 @@ var (
 				panicRed(err)
 			}
 
-			sessJson, err := json.Marshal(session)
-			if err != nil {
-				panicRed(err)
-			}
-
-			paramsJson, err := json.Marshal(input)
-			if err != nil {
-				panicRed(err)
-			}
-
-			if err := internal.CallProcess(_credential.ssmPluginPath, string(sessJson),
-				_credential.awsConfig.Region, "StartSession",
-				_credential.awsProfile, string(paramsJson)); err != nil {
+			if err := internal.StartShellSession(ctx, session); err != nil {
 				color.Red("%v", err)
 			}
 
@@ -81,6 +51,8 @@ var (
 func init() {
 	startSessionCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId.")
 	viper.BindPFlag("start-session-target", startSessionCommand.Flags().Lookup("target"))
+	addTargetFilterFlags(startSessionCommand, "start-session")
+	addTargetQueryFlag(startSessionCommand, "start-session")
 
 	// add sub command
 	rootCmd.AddCommand(startSessionCommand)
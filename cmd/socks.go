@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultSocksPort = "1080"
+)
+
+var (
+	// socksCommand runs a local SOCKS5 server, which opens a session through target per connection.
+	socksCommand = &cobra.Command{
+		Use:   "socks",
+		Short: "Exec `socks` under AWS SSM, which runs a local SOCKS5 server through target",
+		Long: `Exec socks under AWS SSM, which runs a local SOCKS5 server through target like ssh -D.
+Each connection opens AWS-StartPortForwardingSessionToRemoteHost to its host and port, ex) curl --socks5-hostname localhost:1080 http://app.internal`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+			// get target
+			argTarget := strings.TrimSpace(viper.GetString("socks-target"))
+			target := findTarget(ctx, "socks", argTarget)
+
+			localPort := strings.TrimSpace(viper.GetString("socks-local-port"))
+			if localPort == "" {
+				localPort = defaultSocksPort
+			}
+			listener, err := net.Listen("tcp", net.JoinHostPort("localhost", localPort))
+			if err != nil {
+				panicRed(internal.WrapError(err))
+			}
+
+			internal.PrintReady("start-socks localhost:"+localPort, _credential.awsConfig.Region, target.Name)
+			session := &internal.SocksSession{Listen: listener.Addr().String(), Target: target.Name, Region: _credential.awsConfig.Region}
+			if err := _output.Write(session, nil); err != nil {
+				panicRed(err)
+			}
+
+			// stop socks server with ctrl+c.
+			sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			server := internal.NewSSMSocksServer(*_credential.awsConfig, target.Name)
+			server.Log = color.Output
+			if err := server.Serve(sigCtx, listener); err != nil {
+				panicRed(err)
+			}
+		},
+	}
+)
+
+func init() {
+	// add sub command
+	socksCommand.Flags().StringP("local", "l", defaultSocksPort, "[optional] local port of SOCKS5 server, ex) 1080")
+	socksCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId or container of ECS task, ex) ecs:<cluster>_<task>_<runtime-id>")
+
+	// mapping viper
+	viper.BindPFlag("socks-local-port", socksCommand.Flags().Lookup("local"))
+	viper.BindPFlag("socks-target", socksCommand.Flags().Lookup("target"))
+	addTargetFilterFlags(socksCommand, "socks")
+	addTargetQueryFlag(socksCommand, "socks")
+	addECSTargetFlag(socksCommand, "socks")
+
+	rootCmd.AddCommand(socksCommand)
+}
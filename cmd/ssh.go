@@ -2,15 +2,14 @@ package cmd
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"net"
+	"os"
 	"strings"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/ssm"
 	"github.com/fatih/color"
 	"github.com/gjbae1212/gossm/internal"
+	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -24,25 +23,61 @@ var (
 			ctx := context.Background()
 			exec := strings.TrimSpace(viper.GetString("ssh-exec"))
 			identity := strings.TrimSpace(viper.GetString("ssh-identity"))
+			instanceConnect := viper.GetBool("ssh-instance-connect")
+			// identity of config files is a default, so it gives way to exec and instance-connect.
+			if !cmd.Flags().Changed("identity") && (exec != "" || instanceConnect) {
+				identity = ""
+			}
+			if identity != "" {
+				var err error
+				if identity, err = homedir.Expand(identity); err != nil {
+					panicRed(err)
+				}
+			}
 
 			if exec != "" && identity != "" {
 				panicRed(fmt.Errorf("[err] don't use both exec and identity.(must use only one)"))
 			}
+			if instanceConnect && identity != "" {
+				panicRed(fmt.Errorf("[err] don't use both identity and instance-connect.(must use only one)"))
+			}
+			argTarget := strings.TrimSpace(viper.GetString("ssh-target"))
+			if exec != "" && argTarget != "" {
+				panicRed(fmt.Errorf("[err] don't use both exec and target.(must use only one)"))
+			}
 
 			var sshCommand string
 			var targetName string
+			var keyPath string
 			if exec == "" {
-				target, err := internal.AskTarget(ctx, *_credential.awsConfig)
-				if err != nil {
-					panicRed(err)
-				}
+				target := findTarget(ctx, "ssh", argTarget)
 				targetName = target.Name
+				if instanceConnect && target.ResourceType == "ManagedInstance" {
+					panicRed(fmt.Errorf("[err] instance-connect isn't supported for managed instances"))
+				}
 
-				sshUser, err := internal.AskUser()
-				if err != nil {
-					panicRed(err)
+				sshUser := &internal.User{Name: strings.TrimSpace(viper.GetString("ssh-user"))}
+				if sshUser.Name == "" {
+					var err error
+					if sshUser, err = internal.AskUser(); err != nil {
+						panicRed(err)
+					}
+				}
+
+				if instanceConnect {
+					var err error
+					keyPath, err = pushInstanceConnectKey(ctx, targetName, sshUser.Name)
+					if err != nil {
+						panicRed(err)
+					}
+					identity = keyPath
+				}
+				// managed instances and private instances don't have public domain, ssh is connected through proxy anyway.
+				domain := target.PublicDomain
+				if domain == "" {
+					domain = target.Name
 				}
-				sshCommand = internal.GenerateSSHExecCommand("", identity, sshUser.Name, target.PublicDomain)
+				sshCommand = internal.GenerateSSHExecCommand("", identity, sshUser.Name, domain)
 			} else {
 				seps := strings.Split(exec, " ")
 				lastArg := seps[len(seps)-1]
@@ -62,41 +97,50 @@ var (
 					panicRed(fmt.Errorf("[err] not found matched server"))
 				}
 				targetName = instId
-				sshCommand = internal.GenerateSSHExecCommand(exec, "", "", "")
+
+				if instanceConnect {
+					if len(lastArgSeps) != 2 {
+						panicRed(fmt.Errorf("[err] instance-connect requires user in exec command, ex) \"ubuntu@server\""))
+					}
+					keyPath, err = pushInstanceConnectKey(ctx, targetName, lastArgSeps[0])
+					if err != nil {
+						panicRed(err)
+					}
+				}
+				sshCommand = internal.GenerateSSHExecCommand(exec, keyPath, "", "")
+			}
+
+			// ephemeral key and credentials of roles are only valid for this connection, so they are removed after ssh is over.
+			// panicRed exits without deferred calls, so they are also removed before panicRed.
+			var credentialsFile string
+			cleanup := func() {
+				if keyPath != "" {
+					os.Remove(keyPath)
+				}
+				if credentialsFile != "" {
+					os.Remove(credentialsFile)
+				}
 			}
+			defer cleanup()
 
 			internal.PrintReady("ssh", _credential.awsConfig.Region, targetName)
 			color.Cyan("ssh " + sshCommand)
 
-			// start session
-			docName := "AWS-StartSSHSession"
-			port := "22"
-			input := &ssm.StartSessionInput{
-				DocumentName: aws.String(docName),
-				Parameters:   map[string][]string{"portNumber": []string{port}},
-				Target:       aws.String(targetName),
-			}
-
-			session, err := internal.CreateStartSession(ctx, *_credential.awsConfig, input)
-			if err != nil {
+			// call ssh through proxy command of gossm.
+			var err error
+			if credentialsFile, err = writeRoleCredentials(ctx); err != nil {
+				cleanup()
 				panicRed(err)
 			}
-
-			sessJson, err := json.Marshal(session)
+			proxy, err := proxyCommandLine(targetName, credentialsFile)
 			if err != nil {
+				cleanup()
 				panicRed(err)
 			}
-
-			paramsJson, err := json.Marshal(input)
-			if err != nil {
-				panicRed(err)
+			sshArgs := []string{"-o", "ProxyCommand=" + proxy}
+			if keyPath != "" {
+				sshArgs = append(sshArgs, "-o", "IdentitiesOnly=yes")
 			}
-
-			// call ssh
-			proxy := fmt.Sprintf("ProxyCommand=%s '%s' %s %s %s '%s'",
-				_credential.ssmPluginPath, string(sessJson), _credential.awsConfig.Region,
-				"StartSession", _credential.awsProfile, string(paramsJson))
-			sshArgs := []string{"-o", proxy}
 			for _, sep := range strings.Split(sshCommand, " ") {
 				if sep != "" {
 					sshArgs = append(sshArgs, sep)
@@ -106,24 +150,41 @@ var (
 			if err := internal.CallProcess("ssh", sshArgs...); err != nil {
 				color.Red("%v", err)
 			}
-
-			if err := internal.DeleteStartSession(ctx, *_credential.awsConfig, &ssm.TerminateSessionInput{
-				SessionId: session.SessionId,
-			}); err != nil {
-				panicRed(err)
-			}
 		},
 	}
 )
 
+// pushInstanceConnectKey pushes an ephemeral key to instance using EC2 Instance Connect, and returns its private key file.
+func pushInstanceConnectKey(ctx context.Context, instanceId, user string) (string, error) {
+	key, err := internal.GenerateSSHKey()
+	if err != nil {
+		return "", err
+	}
+
+	if err := internal.SendSSHPublicKey(ctx, *_credential.awsConfig, instanceId, user, key.PublicKey); err != nil {
+		return "", err
+	}
+	color.Green("[instance-connect] pushed ephemeral ssh key for %s", user)
+
+	return key.WriteTemporaryKey()
+}
+
 func init() {
 	// add sub command
 	sshCommand.Flags().StringP("exec", "e", "", "[optional] ssh $exec, ex) \"-i ex.pem ubuntu@server\"")
+	sshCommand.Flags().StringP("target", "t", "", "[optional] it is ec2 instanceId.")
 	sshCommand.Flags().StringP("identity", "i", "", "[optional] identity file path, ex) $HOME/.ssh/id_rsa")
+	sshCommand.Flags().StringP("user", "u", "", "[optional] ssh user, it is asked if empty, ex) ec2-user")
+	sshCommand.Flags().BoolP("instance-connect", "", false, "[optional] push an ephemeral key using EC2 Instance Connect instead of pre-provisioned keys")
 
 	// mapping viper
 	viper.BindPFlag("ssh-exec", sshCommand.Flags().Lookup("exec"))
+	viper.BindPFlag("ssh-target", sshCommand.Flags().Lookup("target"))
 	viper.BindPFlag("ssh-identity", sshCommand.Flags().Lookup("identity"))
+	viper.BindPFlag("ssh-user", sshCommand.Flags().Lookup("user"))
+	viper.BindPFlag("ssh-instance-connect", sshCommand.Flags().Lookup("instance-connect"))
+	addTargetFilterFlags(sshCommand, "ssh")
+	addTargetQueryFlag(sshCommand, "ssh")
 
 	rootCmd.AddCommand(sshCommand)
 }
@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	_defaultSSHConfigFile = "~/.ssh/gossm_config"
+)
+
+var (
+	sshConfigCommand = &cobra.Command{
+		Use:   "ssh-config",
+		Short: "Manage ssh config include file for instances under AWS SSM",
+		Long:  "Manage ssh config include file for instances under AWS SSM",
+	}
+
+	sshConfigGenerateCommand = &cobra.Command{
+		Use:   "generate",
+		Short: "Generate ssh config include file which has a Host per instance connected to AWS SSM",
+		Long: `Generate ssh config include file which has a Host per instance connected to AWS SSM.
+Every Host uses gossm proxy as ProxyCommand, and lines outside managed block are preserved when it is regenerated.
+Add "Include ~/.ssh/gossm_config" at the top of ~/.ssh/config.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := context.Background()
+
+			file, err := homedir.Expand(strings.TrimSpace(viper.GetString("ssh-config-file")))
+			if err != nil {
+				panicRed(err)
+			}
+
+			// proxy command of ssh config is invoked later, so it can't use credentials of roles which are assumed now.
+			if getRoleOptions() != nil {
+				panicRed(fmt.Errorf("[err] --role-arn isn't supported by ssh-config, use a profile which has role_arn and source_profile instead"))
+			}
+			proxy, err := proxyCommandLine("%h", "")
+			if err != nil {
+				panicRed(err)
+			}
+
+			// default ssh user and identity of ssh command are used if they are empty.
+			user := strings.TrimSpace(viper.GetString("ssh-config-user"))
+			if user == "" {
+				user = strings.TrimSpace(viper.GetString("ssh-user"))
+			}
+			identity := strings.TrimSpace(viper.GetString("ssh-config-identity"))
+			if identity == "" {
+				identity = strings.TrimSpace(viper.GetString("ssh-identity"))
+			}
+
+			opt := &internal.SSHConfigOption{
+				Profile:      _credential.awsProfile,
+				Region:       _credential.awsConfig.Region,
+				User:         user,
+				IdentityFile: identity,
+				ProxyCommand: proxy,
+			}
+
+			filter := getTargetFilter("ssh-config")
+			generate := func() {
+				table, err := internal.FindInstances(ctx, *_credential.awsConfig, filter)
+				if err != nil {
+					color.Red("[err] %v", err)
+					return
+				}
+
+				changed, err := internal.WriteSSHConfig(file, table, opt)
+				if err != nil {
+					color.Red("[err] %v", err)
+					return
+				}
+				if changed {
+					color.Green("[update] %s (%d hosts)", file, len(table))
+				}
+			}
+
+			generate()
+			warnSSHConfigInclude(file)
+
+			if !viper.GetBool("ssh-config-watch") {
+				return
+			}
+
+			interval := viper.GetDuration("ssh-config-interval")
+			if interval <= 0 {
+				panicRed(internal.WrapError(internal.ErrInvalidParams))
+			}
+			color.Cyan("[watch] regenerate every %s, stop with ctrl+c", interval)
+
+			sigCtx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-sigCtx.Done():
+					return
+				case <-ticker.C:
+					generate()
+				}
+			}
+		},
+	}
+)
+
+// warnSSHConfigInclude shows a guide when ~/.ssh/config doesn't include generated file.
+func warnSSHConfigInclude(file string) {
+	home, err := homedir.Dir()
+	if err != nil {
+		return
+	}
+
+	sshConfig := filepath.Join(home, ".ssh", "config")
+	content, _ := ioutil.ReadFile(sshConfig)
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Include") {
+			continue
+		}
+		for _, include := range fields[1:] {
+			if expanded, err := homedir.Expand(include); err == nil && (expanded == file || filepath.Join(home, ".ssh", include) == file) {
+				return
+			}
+		}
+	}
+	color.Yellow("[info] add `Include %s` at the top of %s", file, sshConfig)
+}
+
+func init() {
+	sshConfigGenerateCommand.Flags().StringP("file", "f", _defaultSSHConfigFile, "[optional] ssh config include file to write")
+	sshConfigGenerateCommand.Flags().StringP("user", "u", "", "[optional] default ssh user of hosts, ex) ec2-user")
+	sshConfigGenerateCommand.Flags().StringP("identity", "i", "", "[optional] identity file path of hosts, ex) $HOME/.ssh/id_rsa")
+	sshConfigGenerateCommand.Flags().BoolP("watch", "w", false, "[optional] keep regenerating file periodically")
+	sshConfigGenerateCommand.Flags().DurationP("interval", "", 5*time.Minute, "[optional] interval to regenerate file with watch")
+
+	viper.BindPFlag("ssh-config-file", sshConfigGenerateCommand.Flags().Lookup("file"))
+	viper.BindPFlag("ssh-config-user", sshConfigGenerateCommand.Flags().Lookup("user"))
+	viper.BindPFlag("ssh-config-identity", sshConfigGenerateCommand.Flags().Lookup("identity"))
+	viper.BindPFlag("ssh-config-watch", sshConfigGenerateCommand.Flags().Lookup("watch"))
+	viper.BindPFlag("ssh-config-interval", sshConfigGenerateCommand.Flags().Lookup("interval"))
+
+	addTargetFilterFlags(sshConfigGenerateCommand, "ssh-config")
+
+	sshConfigCommand.AddCommand(sshConfigGenerateCommand)
+	rootCmd.AddCommand(sshConfigCommand)
+}
@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// addTargetFilterFlags adds flags which narrow targets, and maps them to viper with prefix.
+func addTargetFilterFlags(cmd *cobra.Command, prefix string) {
+	cmd.Flags().StringArray("filter", nil,
+		"[optional] filter targets, ex) tag:Env=prod, name=api-*, platform=linux, vpc=vpc-1, subnet=subnet-1, az=us-east-1a, instance-type=t3.micro, agent-version=3.1.0.0, ping-status=Online, resource-type=ManagedInstance")
+	cmd.Flags().StringArray("tag-key", nil, "[optional] filter targets which have a tag key, ex) Env")
+
+	viper.BindPFlag(prefix+"-filter", cmd.Flags().Lookup("filter"))
+	viper.BindPFlag(prefix+"-tag-key", cmd.Flags().Lookup("tag-key"))
+}
+
+// addTargetQueryFlag adds a flag which searches targets in interactive CLI, and maps it to viper with prefix.
+func addTargetQueryFlag(cmd *cobra.Command, prefix string) {
+	cmd.Flags().StringP("query", "q", "", "[optional] fuzzy search targets by name, id, ip and tags, it is selected without asking if only one is matched, ex) 'prod api 10.0.3'")
+	viper.BindPFlag(prefix+"-query", cmd.Flags().Lookup("query"))
+}
+
+// addECSTargetFlag adds a flag which selects a container of ECS task instead of instances, and maps it to viper with prefix.
+func addECSTargetFlag(cmd *cobra.Command, prefix string) {
+	cmd.Flags().Bool("ecs", false, "[optional] select a container of ECS task which enables ECS Exec, instead of instances")
+	viper.BindPFlag(prefix+"-ecs", cmd.Flags().Lookup("ecs"))
+}
+
+// getTargetFilter returns filter of targets from flags which are mapped with prefix.
+func getTargetFilter(prefix string) *internal.TargetFilter {
+	filter, err := internal.ParseTargetFilter(viper.GetStringSlice(prefix+"-filter"), viper.GetStringSlice(prefix+"-tag-key"))
+	if err != nil {
+		panicRed(err)
+	}
+	return filter
+}
+
+// getTargetColumns returns columns of targets which are shown in interactive CLI, defaults are used if columns flag is empty.
+// Account and region are prepended to default columns when targets are found in multiple profiles or regions.
+func getTargetColumns(scopes *internal.Scopes, defaults []string) []string {
+	if len(viper.GetStringSlice("columns")) > 0 {
+		columns, err := internal.ParseTargetColumns(viper.GetStringSlice("columns"))
+		if err != nil {
+			panicRed(err)
+		}
+		return columns
+	}
+
+	var scopeColumns []string
+	profiles, regions := scopes.Count()
+	if profiles > 1 {
+		scopeColumns = append(scopeColumns, "account")
+	}
+	if regions > 1 {
+		scopeColumns = append(scopeColumns, "region")
+	}
+	return append(scopeColumns, defaults...)
+}
+
+// getTargetScopes returns scopes where instances are found, which are profiles and regions from flags.
+// It returns the current profile and region if flags are empty.
+func getTargetScopes(ctx context.Context) *internal.Scopes {
+	if _scopes != nil {
+		return _scopes
+	}
+
+	profiles := viper.GetStringSlice("profiles")
+	if len(profiles) == 0 {
+		profiles = []string{_credential.awsProfile}
+	}
+	regions := viper.GetStringSlice("regions")
+	allRegions := viper.GetBool("all-regions")
+	ttl := viper.GetDuration("cache-ttl")
+
+	scopes := internal.NewScopes()
+	if p := viper.GetInt("parallelism"); p > 0 {
+		scopes.Parallelism = p
+	}
+	for _, profile := range profiles {
+		cfg, err := getProfileConfig(ctx, profile)
+		if err != nil {
+			color.Yellow("[Warning] profile %s is skipped, such as %s", profile, err.Error())
+			continue
+		}
+
+		profileRegions := regions
+		if allRegions {
+			if profileRegions, err = internal.FindRegions(ctx, cfg); err != nil {
+				color.Yellow("[Warning] profile %s is skipped, such as %s", profile, err.Error())
+				continue
+			}
+		}
+		if len(profileRegions) == 0 {
+			profileRegions = []string{cfg.Region}
+		}
+
+		account, err := internal.FindAccountId(ctx, cfg)
+		if err != nil {
+			color.Yellow("[Warning] cache of instances isn't used in profile %s, such as %s", profile, err.Error())
+		}
+		for _, region := range profileRegions {
+			scope := &internal.Scope{Profile: profile, Account: account, Config: cfg.Copy()}
+			scope.Config.Region = region
+			if ttl > 0 && account != "" {
+				scope.Cache = internal.NewTargetCache(filepath.Join(_credential.gossmHomePath, "cache"),
+					profile, account, region, ttl, viper.GetBool("refresh"))
+			}
+			scopes.Items = append(scopes.Items, scope)
+		}
+	}
+	if len(scopes.Items) == 0 {
+		panicRed(fmt.Errorf("[err] not found profiles and regions to find instances"))
+	}
+
+	_scopes = scopes
+	return scopes
+}
+
+// getProfileConfig returns config of profile, the current profile uses config which is made by initConfig.
+// Other profiles use their cached mfa token, or shared config and credentials files.
+// Region of current profile is used if they don't have region.
+func getProfileConfig(ctx context.Context, profile string) (aws.Config, error) {
+	if profile == _credential.awsProfile {
+		return *_credential.awsConfig, nil
+	}
+
+	cfg, ok := getMFAConfig(ctx, profile)
+	if !ok {
+		credFiles := []string{config.DefaultSharedCredentialsFilename()}
+		// mfa credential file has temporary credentials of profiles, so it overrides default credentials file.
+		if f := os.Getenv("AWS_SHARED_CREDENTIALS_FILE"); f != "" && f != _credentialWithTemporary {
+			credFiles = append(credFiles, f)
+		}
+		var err error
+		if cfg, err = internal.NewSharedConfig(ctx, profile, []string{config.DefaultSharedConfigFilename()}, credFiles); err != nil {
+			return aws.Config{}, err
+		}
+	}
+	if cfg.Region == "" {
+		cfg.Region = _credential.awsConfig.Region
+	}
+	return cfg, nil
+}
+
+// useTargetScope makes sessions of target use config of scope where target is found.
+func useTargetScope(target *internal.Target) {
+	if _scopes == nil {
+		return
+	}
+	if scope := _scopes.Find(target); scope != nil {
+		cfg := scope.Config.Copy()
+		_credential.awsConfig = &cfg
+		_credential.awsProfile = scope.Profile
+	}
+}
+
+// targetConfig returns config of scope where target is found.
+func targetConfig(target *internal.Target) aws.Config {
+	if _scopes != nil {
+		if scope := _scopes.Find(target); scope != nil {
+			return scope.Config
+		}
+	}
+	return *_credential.awsConfig
+}
+
+// lookupTarget returns a target matched with instance id, it finds instances again if cached instances don't have it.
+func lookupTarget(ctx context.Context, argTarget string, filter *internal.TargetFilter, scopes *internal.Scopes) (*internal.Target, bool) {
+	table, _, err := internal.FindInstancesInScopes(ctx, scopes, filter, false)
+	if err != nil {
+		panicRed(err)
+	}
+	if t, ok := table[argTarget]; ok {
+		return t, ok
+	}
+
+	cached := false
+	for _, scope := range scopes.Items {
+		cached = cached || scope.Cache != nil
+	}
+	if !cached {
+		return nil, false
+	}
+
+	table, _, err = internal.FindInstancesInScopes(ctx, scopes, filter, true)
+	if err != nil {
+		panicRed(err)
+	}
+	t, ok := table[argTarget]
+	return t, ok
+}
+
+// findTarget returns a target matched with instance id, otherwise asks you which selects a target.
+// filter and query are read from flags which are mapped with prefix, and sessions use config of scope where target is found.
+func findTarget(ctx context.Context, prefix, argTarget string) *internal.Target {
+	// containers of ECS task are targets, such as ecs:<cluster>_<task>_<runtime-id>.
+	if strings.HasPrefix(argTarget, "ecs:") {
+		return &internal.Target{Name: argTarget, ResourceType: internal.ECSResourceType}
+	}
+	if viper.GetBool(prefix + "-ecs") {
+		container, err := internal.AskECSContainer(ctx, *_credential.awsConfig, "", "", "", "")
+		if err != nil {
+			panicRed(err)
+		}
+		return container.Target()
+	}
+
+	filter := getTargetFilter(prefix)
+	scopes := getTargetScopes(ctx)
+	if argTarget != "" {
+		if t, ok := lookupTarget(ctx, argTarget, filter, scopes); ok {
+			useTargetScope(t)
+			return t
+		}
+	}
+
+	target, err := internal.AskTarget(ctx, scopes, filter, getTargetColumns(scopes, internal.DefaultTargetColumns), viper.GetString(prefix+"-query"))
+	if err != nil {
+		panicRed(err)
+	}
+	useTargetScope(target)
+	return target
+}
+
+// findTargets returns targets matched with instance id, otherwise asks you which selects targets.
+// Targets can be found in multiple scopes, so targetConfig returns config of each target.
+// filter and query are read from flags which are mapped with prefix.
+func findTargets(ctx context.Context, prefix, argTarget string) []*internal.Target {
+	filter := getTargetFilter(prefix)
+	scopes := getTargetScopes(ctx)
+	if argTarget != "" {
+		if t, ok := lookupTarget(ctx, argTarget, filter, scopes); ok {
+			return []*internal.Target{t}
+		}
+	}
+
+	targets, err := internal.AskMultiTarget(ctx, scopes, filter, getTargetColumns(scopes, internal.DefaultTargetColumns), viper.GetString(prefix+"-query"))
+	if err != nil {
+		panicRed(err)
+	}
+	if len(targets) == 0 {
+		panicRed(fmt.Errorf("[err] not found selected targets"))
+	}
+	return targets
+}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -10,8 +11,21 @@ import (
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 )
 
-// NewConfig creates a config for accessing AWS with passing credential parameters.
-func NewConfig(ctx context.Context, key, secret, session, region, roleArn string) (aws.Config, error) {
+// RoleOptions are options to assume roles, such as a chain from hub account to workload account.
+type RoleOptions struct {
+	// RoleArns are assumed in order, each role is assumed with credentials of the previous one.
+	RoleArns        []string
+	ExternalId      string
+	RoleSessionName string
+	// Duration is lifetime of assumed roles, default is 15 minutes of AWS SDK.
+	Duration time.Duration
+	// SerialNumber is a mfa device, the first role is assumed with a code of TokenProvider if it is set.
+	SerialNumber  string
+	TokenProvider func() (string, error)
+}
+
+// NewConfig creates a config for accessing AWS with passing credential parameters, and assumes roles of roles if it isn't nil.
+func NewConfig(ctx context.Context, key, secret, session, region string, roles *RoleOptions) (aws.Config, error) {
 	var (
 		opts []func(*config.LoadOptions) error
 		cfg  aws.Config
@@ -37,13 +51,47 @@ func NewConfig(ctx context.Context, key, secret, session, region, roleArn string
 		return aws.Config{}, WrapError(err)
 	}
 
-	// https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/credentials/stscreds
-	if roleArn != "" {
-		sts := sts.NewFromConfig(cfg)
-		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(sts, roleArn))
+	return AssumeRoles(cfg, roles), nil
+}
+
+// AssumeRoles returns a copy of cfg whose credentials are of the last role in roles, cfg is returned as it is if roles is nil.
+// https://pkg.go.dev/github.com/aws/aws-sdk-go-v2/credentials/stscreds
+func AssumeRoles(cfg aws.Config, roles *RoleOptions) aws.Config {
+	if roles == nil {
+		return cfg
 	}
 
-	return cfg, nil
+	cfg = cfg.Copy()
+	for i, roleArn := range roles.RoleArns {
+		// sts client has credentials of the previous role, so roles are chained.
+		client := sts.NewFromConfig(cfg)
+		first := i == 0
+		cfg.Credentials = aws.NewCredentialsCache(stscreds.NewAssumeRoleProvider(client, roleArn, func(o *stscreds.AssumeRoleOptions) {
+			if roles.ExternalId != "" {
+				o.ExternalID = aws.String(roles.ExternalId)
+			}
+			if roles.RoleSessionName != "" {
+				o.RoleSessionName = roles.RoleSessionName
+			}
+			if roles.Duration > 0 {
+				o.Duration = roles.Duration
+			}
+			if first && roles.SerialNumber != "" {
+				o.SerialNumber = aws.String(roles.SerialNumber)
+				o.TokenProvider = roles.TokenProvider
+			}
+		}))
+	}
+	return cfg
+}
+
+// FindAccountId returns id of AWS account which credentials of cfg belong to.
+func FindAccountId(ctx context.Context, cfg aws.Config) (string, error) {
+	output, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", WrapError(err)
+	}
+	return aws.ToString(output.Account), nil
 }
 
 // NewSharedConfig creates a config for accessing AWS that is based on shared files, such as credentials file.
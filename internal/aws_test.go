@@ -2,7 +2,14 @@ package internal
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/stretchr/testify/assert"
@@ -12,20 +19,20 @@ func TestNewConfig(t *testing.T) {
 	assert := assert.New(t)
 
 	tests := map[string]struct {
-		ctx     context.Context
-		key     string
-		secret  string
-		token   string
-		region  string
-		roleArn string
-		isErr   bool
+		ctx    context.Context
+		key    string
+		secret string
+		token  string
+		region string
+		roles  *RoleOptions
+		isErr  bool
 	}{
 		"fail":    {isErr: true},
 		"success": {ctx: context.Background(), key: mockAwsKey, secret: mockAwsSecret, region: mockRegion, isErr: false},
 	}
 
 	for _, t := range tests {
-		_, err := NewConfig(t.ctx, t.key, t.secret, t.token, t.region, t.roleArn)
+		_, err := NewConfig(t.ctx, t.key, t.secret, t.token, t.region, t.roles)
 		assert.Equal(t.isErr, err != nil)
 	}
 }
@@ -54,3 +61,87 @@ func TestNewSharedConfig(t *testing.T) {
 		assert.Equal(t.isErr, err != nil)
 	}
 }
+
+func TestAssumeRoles(t *testing.T) {
+	assert := assert.New(t)
+
+	var roles []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal("AssumeRole", r.Form.Get("Action"))
+		assert.Equal("ext", r.Form.Get("ExternalId"))
+		assert.Equal("gossm", r.Form.Get("RoleSessionName"))
+		assert.Equal("3600", r.Form.Get("DurationSeconds"))
+
+		// the first role is assumed with mfa and credentials of config, the next role with credentials of the first role.
+		if len(roles) == 0 {
+			assert.Equal("arn:aws:iam::123456789012:mfa/gossm", r.Form.Get("SerialNumber"))
+			assert.Equal("123456", r.Form.Get("TokenCode"))
+			assert.Contains(r.Header.Get("Authorization"), "Credential=key/")
+		} else {
+			assert.Empty(r.Form.Get("SerialNumber"))
+			assert.Contains(r.Header.Get("Authorization"), "Credential=key0/")
+		}
+		roles = append(roles, r.Form.Get("RoleArn"))
+
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprintf(w, `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>
+			<AccessKeyId>key%d</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+			<Expiration>2030-01-02T03:04:05Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`, len(roles)-1)
+	}))
+	defer server.Close()
+
+	cfg := newMockConfig(server.URL)
+	assert.Equal(cfg.Credentials, AssumeRoles(cfg, nil).Credentials)
+
+	chained := AssumeRoles(cfg, &RoleOptions{
+		RoleArns:        []string{"arn:aws:iam::123456789012:role/hub", "arn:aws:iam::210987654321:role/workload"},
+		ExternalId:      "ext",
+		RoleSessionName: "gossm",
+		Duration:        time.Hour,
+		SerialNumber:    "arn:aws:iam::123456789012:mfa/gossm",
+		TokenProvider:   func() (string, error) { return "123456", nil },
+	})
+	cred, err := chained.Credentials.Retrieve(context.Background())
+	assert.NoError(err)
+	assert.Equal("key1", cred.AccessKeyID)
+	assert.Equal("arn:aws:iam::123456789012:role/hub,arn:aws:iam::210987654321:role/workload", strings.Join(roles, ","))
+}
+
+func TestNewConfig_CredentialProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	// credential_process of shared config is resolved by AWS SDK, so NewConfig and NewSharedConfig support it without changes.
+	dir := t.TempDir()
+	process := filepath.Join(dir, "credential-process")
+	assert.NoError(os.WriteFile(process, []byte(`#!/bin/sh
+echo '{"Version":1,"AccessKeyId":"process-key","SecretAccessKey":"secret","SessionToken":"token","Expiration":"2030-01-02T03:04:05Z"}'
+`), 0700))
+	configFile := filepath.Join(dir, "config")
+	assert.NoError(os.WriteFile(configFile, []byte("[profile process]\nregion = us-east-1\ncredential_process = "+process+"\n"), 0600))
+
+	cfg, err := NewSharedConfig(context.Background(), "process", []string{configFile}, []string{filepath.Join(dir, "credentials")})
+	assert.NoError(err)
+	cred, err := cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(err)
+	assert.Equal("process-key", cred.AccessKeyID)
+	assert.True(cred.CanExpire)
+
+	// NewConfig without keys reads profile of environment variables.
+	for key, value := range map[string]string{
+		"AWS_CONFIG_FILE":             configFile,
+		"AWS_SHARED_CREDENTIALS_FILE": filepath.Join(dir, "credentials"),
+		"AWS_PROFILE":                 "process",
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+		"AWS_SESSION_TOKEN":           "",
+	} {
+		t.Setenv(key, value)
+	}
+	cfg, err = NewConfig(context.Background(), "", "", "", "", nil)
+	assert.NoError(err)
+	assert.Equal("us-east-1", cfg.Region)
+	cred, err = cfg.Credentials.Retrieve(context.Background())
+	assert.NoError(err)
+	assert.Equal("process-key", cred.AccessKeyID)
+}
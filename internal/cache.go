@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fatih/color"
+)
+
+type (
+	// TargetCache caches instances-map per profile and region in a file, such as ~/.gossm/cache/<profile>/<region>.json.
+	// Cached instances are stale after ttl, and they are ignored if file is written by other account.
+	TargetCache struct {
+		path    string
+		account string
+		region  string
+		ttl     time.Duration
+		refresh bool
+		now     func() time.Time
+		mu      sync.Mutex
+	}
+
+	targetCacheFile struct {
+		Account string `json:"account"`
+		Region  string `json:"region"`
+		// Entries are keyed by filter, because instances are narrowed by filter in AWS API.
+		Entries map[string]*targetCacheEntry `json:"entries"`
+	}
+
+	targetCacheEntry struct {
+		UpdatedAt time.Time          `json:"updated_at"`
+		Targets   map[string]*Target `json:"targets"`
+	}
+)
+
+// NewTargetCache returns a cache of instances in account and region, it ignores cached instances if refresh is true.
+func NewTargetCache(dir, profile, account, region string, ttl time.Duration, refresh bool) *TargetCache {
+	return &TargetCache{
+		path:    filepath.Join(dir, profile, region+".json"),
+		account: account,
+		region:  region,
+		ttl:     ttl,
+		refresh: refresh,
+		now:     time.Now,
+	}
+}
+
+// Get returns cached instances narrowed by filter, and whether they are stale.
+func (c *TargetCache) Get(filter *TargetFilter) (table map[string]*Target, stale bool, ok bool) {
+	if c == nil || c.refresh {
+		return nil, false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file := c.read()
+	entry, ok := file.Entries[filter.cacheKey()]
+	if !ok || entry.Targets == nil {
+		return nil, false, false
+	}
+	return entry.Targets, c.now().Sub(entry.UpdatedAt) > c.ttl, true
+}
+
+// Set caches instances narrowed by filter.
+func (c *TargetCache) Set(filter *TargetFilter, table map[string]*Target) error {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	file := c.read()
+	file.Entries[filter.cacheKey()] = &targetCacheEntry{UpdatedAt: c.now(), Targets: table}
+
+	buf, err := json.Marshal(file)
+	if err != nil {
+		return WrapError(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0700); err != nil {
+		return WrapError(err)
+	}
+
+	// write a temporary file and then rename it, so other processes don't read a partial file.
+	// temporary file is unique per process, because other gossm can refresh the same cache at the same time.
+	tmp, err := ioutil.TempFile(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return WrapError(err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return WrapError(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return WrapError(err)
+	}
+	if err := os.Rename(tmp.Name(), c.path); err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// read returns cache file, it returns an empty one if file doesn't exist or belongs to other account.
+func (c *TargetCache) read() *targetCacheFile {
+	empty := &targetCacheFile{Account: c.account, Region: c.region, Entries: map[string]*targetCacheEntry{}}
+
+	buf, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		return empty
+	}
+	file := &targetCacheFile{}
+	if err := json.Unmarshal(buf, file); err != nil || file.Account != c.account || file.Region != c.region || file.Entries == nil {
+		return empty
+	}
+	return file
+}
+
+// FindInstancesWithCache returns instances from cache if they are cached, and whether they are stale.
+// Otherwise, it finds instances and caches them.
+func FindInstancesWithCache(ctx context.Context, cfg aws.Config, filter *TargetFilter, cache *TargetCache) (map[string]*Target, bool, error) {
+	if table, stale, ok := cache.Get(filter); ok {
+		return table, stale, nil
+	}
+
+	table, err := RefreshInstances(ctx, cfg, filter, cache)
+	return table, false, err
+}
+
+// RefreshInstances finds instances and caches them.
+// Cache is only an optimization, so instances are returned with a warning if they can't be cached.
+func RefreshInstances(ctx context.Context, cfg aws.Config, filter *TargetFilter, cache *TargetCache) (map[string]*Target, error) {
+	table, err := FindInstances(ctx, cfg, filter)
+	if err != nil {
+		return nil, err
+	}
+	if err := cache.Set(filter, table); err != nil {
+		fmt.Fprintln(color.Output, color.YellowString("[Warning] failed to cache instances, such as %s", err.Error()))
+	}
+	return table, nil
+}
+
+// ClearTargetCache removes cached instances of profile, it removes all of cached instances if profile is empty.
+func ClearTargetCache(dir, profile string) error {
+	if profile != "" {
+		dir = filepath.Join(dir, profile)
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTargetCache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	now := time.Now()
+	newCache := func(account string, refresh bool) *TargetCache {
+		return &TargetCache{path: filepath.Join(dir, "default", "us-east-1.json"), account: account, region: "us-east-1",
+			ttl: time.Minute, refresh: refresh, now: func() time.Time { return now }}
+	}
+	filter, err := ParseTargetFilter([]string{"tag:Env=prod"}, nil)
+	assert.NoError(err)
+
+	cache := newCache("1", false)
+	_, _, ok := cache.Get(nil)
+	assert.False(ok)
+
+	table := map[string]*Target{"i-1": {Name: "i-1", InstanceName: "api", Tags: map[string]string{"Env": "prod"}}}
+	assert.NoError(cache.Set(nil, table))
+	assert.NoError(cache.Set(filter, map[string]*Target{}))
+
+	cached, stale, ok := cache.Get(nil)
+	assert.True(ok)
+	assert.False(stale)
+	assert.Equal(table, cached)
+
+	cached, _, ok = cache.Get(filter)
+	assert.True(ok)
+	assert.Empty(cached)
+
+	// stale after ttl
+	now = now.Add(2 * time.Minute)
+	_, stale, ok = cache.Get(nil)
+	assert.True(ok)
+	assert.True(stale)
+
+	// other account and refresh ignore cached instances.
+	_, _, ok = newCache("2", false).Get(nil)
+	assert.False(ok)
+	_, _, ok = newCache("1", true).Get(nil)
+	assert.False(ok)
+
+	// nil cache doesn't cache anything.
+	var nilCache *TargetCache
+	assert.NoError(nilCache.Set(nil, table))
+	_, _, ok = nilCache.Get(nil)
+	assert.False(ok)
+
+	assert.Equal(filepath.Join(dir, "default", "us-east-1.json"), NewTargetCache(dir, "default", "1", "us-east-1", time.Minute, false).path)
+
+	assert.NoError(ClearTargetCache(dir, "default"))
+	_, err = os.Stat(filepath.Join(dir, "default"))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestFindAccountId(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult>
+			<Arn>arn:aws:iam::123456789012:user/gossm</Arn><UserId>USER</UserId><Account>123456789012</Account>
+			</GetCallerIdentityResult></GetCallerIdentityResponse>`))
+	}))
+	defer server.Close()
+
+	account, err := FindAccountId(context.Background(), newMockConfig(server.URL))
+	assert.NoError(err)
+	assert.Equal("123456789012", account)
+}
+
+func TestRefreshInstances_CacheFailed(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.DescribeInstanceInformation" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.Write([]byte(`{"InstanceInformationList":[{"InstanceId":"i-1","ResourceType":"EC2Instance","PingStatus":"Online"}]}`))
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet><item><instancesSet>
+			<item><instanceId>i-1</instanceId></item></instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+	}))
+	defer server.Close()
+
+	// directory of cache is a file, so instances can't be cached.
+	dir := t.TempDir()
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "default"), nil, 0600))
+	cache := NewTargetCache(dir, "default", "1", "us-east-1", time.Minute, false)
+
+	table, err := RefreshInstances(context.Background(), newMockConfig(server.URL), nil, cache)
+	assert.NoError(err)
+	assert.Contains(table, "i-1")
+
+	// temporary files don't remain next to cache file.
+	cache = NewTargetCache(t.TempDir(), "default", "1", "us-east-1", time.Minute, false)
+	assert.NoError(cache.Set(nil, table))
+	files, err := ioutil.ReadDir(filepath.Dir(cache.path))
+	assert.NoError(err)
+	assert.Len(files, 1)
+}
@@ -0,0 +1,359 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+const (
+	// DefaultCommandDocument is a document which runs shell script on linux, AWS-RunPowerShellScript runs on windows.
+	DefaultCommandDocument = "AWS-RunShellScript"
+
+	// SSM truncates output of GetCommandInvocation to 24000 characters, full output is in CloudWatch logs.
+	commandOutputLimit = 24000
+	// commandLogGroupPrefix is prefix of default log group, which is followed by document name.
+	commandLogGroupPrefix = "/aws/ssm/"
+	// commandErrorStatus is status of invocation which couldn't be fetched.
+	commandErrorStatus = "Error"
+	// commandDeliveryTimeout is how long SSM waits for instances to receive command, minimum of SSM is 30 seconds.
+	commandDeliveryTimeout    = 60 * time.Second
+	commandMinDeliveryTimeout = 30 * time.Second
+
+	// parameters of documents such as AWS-RunShellScript and AWS-RunPowerShellScript.
+	commandsParam         = "commands"
+	workingDirectoryParam = "workingDirectory"
+	executionTimeoutParam = "executionTimeout"
+)
+
+var (
+	// commandPollInterval is interval of polling command invocation.
+	commandPollInterval = time.Second
+)
+
+// CommandInput is a document and its parameters which are sent to targets.
+type CommandInput struct {
+	DocumentName string
+	Parameters   map[string][]string
+	// Commands and WorkingDirectory are parameters of documents which run scripts, they are ignored if empty.
+	Commands         []string
+	WorkingDirectory string
+	// Timeout is execution timeout of command, default of document is used if it is 0.
+	Timeout time.Duration
+	// Targets are sent instead of instances such as tags, and SSM controls rate with MaxConcurrency and MaxErrors, such as 10 or 10%.
+	Targets        []ssm_types.Target
+	MaxConcurrency string
+	MaxErrors      string
+}
+
+// ParseCommandParams parses parameters of document such as key=value, values of the same key are appended to a list.
+func ParseCommandParams(params []string) (map[string][]string, error) {
+	parsed := map[string][]string{}
+	for _, param := range params {
+		key, value, ok := strings.Cut(param, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("[err] invalid param %s, ex) key=value", param)
+		}
+		parsed[key] = append(parsed[key], value)
+	}
+	return parsed, nil
+}
+
+// ReadCommandScript returns lines of script file, which are uploaded inline as commands of document.
+func ReadCommandScript(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	script := strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if script == "" {
+		return nil, fmt.Errorf("[err] empty script %s", path)
+	}
+	return strings.Split(script, "\n"), nil
+}
+
+// parameters returns parameters of document which include commands, working directory and timeout.
+func (c *CommandInput) parameters() map[string][]string {
+	params := map[string][]string{}
+	for k, v := range c.Parameters {
+		params[k] = v
+	}
+	if len(c.Commands) > 0 {
+		params[commandsParam] = c.Commands
+	}
+	if c.WorkingDirectory != "" {
+		params[workingDirectoryParam] = []string{c.WorkingDirectory}
+	}
+	if c.Timeout > 0 {
+		params[executionTimeoutParam] = []string{strconv.Itoa(int(c.Timeout.Seconds()))}
+	}
+	return params
+}
+
+// deliveryTimeoutSeconds returns how long SSM waits for instances to receive command, it isn't shorter than execution timeout.
+func (c *CommandInput) deliveryTimeoutSeconds() int32 {
+	timeout := commandDeliveryTimeout
+	if c.Timeout > 0 {
+		timeout = c.Timeout
+	}
+	if timeout < commandMinDeliveryTimeout {
+		timeout = commandMinDeliveryTimeout
+	}
+	return int32(timeout.Seconds())
+}
+
+// ValidateCommand checks that document is a Command document and parameters of input are declared in it with DescribeDocument.
+// Parameters without default value are required.
+func ValidateCommand(ctx context.Context, cfg aws.Config, input *CommandInput) error {
+	output, err := ssm.NewFromConfig(cfg).DescribeDocument(ctx, &ssm.DescribeDocumentInput{Name: aws.String(input.DocumentName)})
+	if err != nil {
+		return fmt.Errorf("[err] not found document %s, %s", input.DocumentName, err.Error())
+	}
+	doc := output.Document
+	if doc.DocumentType != ssm_types.DocumentTypeCommand {
+		return fmt.Errorf("[err] %s is %s document, only Command document can be sent", input.DocumentName, doc.DocumentType)
+	}
+
+	declared := map[string]bool{}
+	var names []string
+	for _, p := range doc.Parameters {
+		declared[aws.ToString(p.Name)] = true
+		names = append(names, aws.ToString(p.Name))
+	}
+
+	params := input.parameters()
+	for key := range params {
+		if !declared[key] {
+			return fmt.Errorf("[err] %s doesn't have parameter %s, such as %s", input.DocumentName, key, strings.Join(names, ", "))
+		}
+	}
+	for _, p := range doc.Parameters {
+		if _, ok := params[aws.ToString(p.Name)]; !ok && p.DefaultValue == nil {
+			return fmt.Errorf("[err] required parameter %s of %s, %s", aws.ToString(p.Name), input.DocumentName, aws.ToString(p.Description))
+		}
+	}
+	return nil
+}
+
+// CommandResult is a result of command on an instance.
+type CommandResult struct {
+	CommandId  string `json:"commandId"`
+	InstanceId string `json:"instanceId"`
+	// InstanceName is Name tag of instance, it is empty if unknown.
+	InstanceName string `json:"instanceName"`
+	// Status is status of invocation, such as Success, Failed, TimedOut and Cancelled.
+	Status string `json:"status"`
+	// ExitCode is -1 if command wasn't executed, such as undeliverable.
+	ExitCode int32  `json:"exitCode"`
+	Stdout   string `json:"stdout"`
+	Stderr   string `json:"stderr"`
+	// Truncated is true if output exceeds limit of SSM and full output isn't found in CloudWatch logs.
+	Truncated bool `json:"truncated"`
+}
+
+// Succeeded returns whether command succeeded on instance.
+func (r *CommandResult) Succeeded() bool {
+	return r.Status == string(ssm_types.CommandInvocationStatusSuccess)
+}
+
+// WaitCommandInvocations polls invocations of command on targets until every invocation is over.
+// onResult is called per target as soon as its invocation is over, and results are returned in the order of targets.
+func WaitCommandInvocations(ctx context.Context, cfg aws.Config, commandId string, targets []*Target, onResult func(*CommandResult)) []*CommandResult {
+	client := ssm.NewFromConfig(cfg)
+
+	results := make([]*CommandResult, len(targets))
+	wg := new(sync.WaitGroup)
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t *Target) {
+			defer wg.Done()
+			r := waitCommandInvocation(ctx, cfg, client, commandId, t.Name)
+			r.InstanceName = t.InstanceName
+			results[i] = r
+			if onResult != nil {
+				onResult(r)
+			}
+		}(i, t)
+	}
+	wg.Wait()
+	return results
+}
+
+// waitCommandInvocation polls invocation of command on instance until it is over.
+func waitCommandInvocation(ctx context.Context, cfg aws.Config, client *ssm.Client, commandId, instanceId string) *CommandResult {
+	result := &CommandResult{CommandId: commandId, InstanceId: instanceId, ExitCode: -1}
+	input := &ssm.GetCommandInvocationInput{CommandId: aws.String(commandId), InstanceId: aws.String(instanceId)}
+	for {
+		output, err := client.GetCommandInvocation(ctx, input)
+		var notExist *ssm_types.InvocationDoesNotExist
+		switch {
+		// invocation isn't found for a while after command is sent.
+		case errors.As(err, &notExist):
+		case err != nil:
+			result.Status, result.Stderr = commandErrorStatus, err.Error()
+			return result
+		default:
+			if isInvocationOver(output.Status) {
+				result.Status = string(output.Status)
+				result.ExitCode = output.ResponseCode
+				result.Stdout = aws.ToString(output.StandardOutputContent)
+				result.Stderr = aws.ToString(output.StandardErrorContent)
+				if len(result.Stdout) >= commandOutputLimit || len(result.Stderr) >= commandOutputLimit {
+					result.fetchFullOutput(ctx, cfg, commandLogGroup(output))
+				}
+				return result
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			result.Status, result.Stderr = commandErrorStatus, ctx.Err().Error()
+			return result
+		case <-time.After(commandPollInterval):
+		}
+	}
+}
+
+// commandLogGroup returns CloudWatch log group where output of invocation is written.
+func commandLogGroup(output *ssm.GetCommandInvocationOutput) string {
+	if output.CloudWatchOutputConfig != nil && aws.ToString(output.CloudWatchOutputConfig.CloudWatchLogGroupName) != "" {
+		return aws.ToString(output.CloudWatchOutputConfig.CloudWatchLogGroupName)
+	}
+	return commandLogGroupPrefix + aws.ToString(output.DocumentName)
+}
+
+// fetchFullOutput replaces truncated output with log streams of invocation, such as <command-id>/<instance-id>/<plugin>/stdout.
+// Output is kept as it is with Truncated if log streams aren't found, such as CloudWatch logs aren't permitted.
+func (r *CommandResult) fetchFullOutput(ctx context.Context, cfg aws.Config, group string) {
+	client := cloudwatchlogs.NewFromConfig(cfg)
+
+	var stdout, stderr strings.Builder
+	prefix := r.CommandId + "/" + r.InstanceId + "/"
+	paginator := cloudwatchlogs.NewDescribeLogStreamsPaginator(client, &cloudwatchlogs.DescribeLogStreamsInput{
+		LogGroupName:        aws.String(group),
+		LogStreamNamePrefix: aws.String(prefix),
+	})
+	found := false
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			r.Truncated = true
+			return
+		}
+		for _, stream := range page.LogStreams {
+			name := aws.ToString(stream.LogStreamName)
+			var sb *strings.Builder
+			switch {
+			case strings.HasSuffix(name, "/stdout"):
+				sb = &stdout
+			case strings.HasSuffix(name, "/stderr"):
+				sb = &stderr
+			default:
+				continue
+			}
+			if err := readLogStream(ctx, client, group, name, sb); err != nil {
+				r.Truncated = true
+				return
+			}
+			found = true
+		}
+	}
+
+	if !found {
+		r.Truncated = true
+		return
+	}
+	r.Stdout, r.Stderr = stdout.String(), stderr.String()
+}
+
+// readLogStream writes every event of log stream to sb from the head.
+func readLogStream(ctx context.Context, client *cloudwatchlogs.Client, group, stream string, sb *strings.Builder) error {
+	input := &cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(group),
+		LogStreamName: aws.String(stream),
+		StartFromHead: aws.Bool(true),
+	}
+	for {
+		output, err := client.GetLogEvents(ctx, input)
+		if err != nil {
+			return err
+		}
+		for _, event := range output.Events {
+			sb.WriteString(aws.ToString(event.Message))
+			if !strings.HasSuffix(aws.ToString(event.Message), "\n") {
+				sb.WriteString("\n")
+			}
+		}
+		// the last page returns the same token as the given one.
+		if output.NextForwardToken == nil || aws.ToString(output.NextForwardToken) == aws.ToString(input.NextToken) {
+			return nil
+		}
+		input.NextToken = output.NextForwardToken
+	}
+}
+
+// PrintCommandResult prints status, exit code, stdout and stderr of command on an instance.
+func PrintCommandResult(w io.Writer, r *CommandResult) {
+	colorf := color.GreenString
+	if !r.Succeeded() {
+		colorf = color.RedString
+	}
+	instance := r.InstanceId
+	if r.InstanceName != "" {
+		instance += " (" + r.InstanceName + ")"
+	}
+	fmt.Fprintf(w, "[%s][%s] exit code %d\n", colorf(strings.ToLower(r.Status)), color.YellowString(instance), r.ExitCode)
+	if out := strings.TrimRight(r.Stdout, "\n"); out != "" {
+		fmt.Fprintln(w, out)
+	}
+	if out := strings.TrimRight(r.Stderr, "\n"); out != "" {
+		fmt.Fprintln(w, color.RedString(out))
+	}
+	if r.Truncated {
+		fmt.Fprintln(w, color.YellowString("[Warning] output is truncated to %d characters, because it isn't found in CloudWatch logs", commandOutputLimit))
+	}
+}
+
+// PrintCommandSummary prints a table of status and exit code per instance, and the number of instances per status.
+func PrintCommandSummary(w io.Writer, results []*CommandResult) {
+	rows := [][]string{{"INSTANCE", "NAME", "STATUS", "EXIT CODE"}}
+	counts := map[string]int{}
+	var statuses []string
+	for _, r := range results {
+		rows = append(rows, []string{r.InstanceId, r.InstanceName, r.Status, strconv.Itoa(int(r.ExitCode))})
+		if counts[r.Status] == 0 {
+			statuses = append(statuses, r.Status)
+		}
+		counts[r.Status]++
+	}
+
+	for i, line := range alignRows(rows) {
+		switch {
+		case i == 0:
+			fmt.Fprintln(w, color.New(color.Bold).Sprint(line))
+		case results[i-1].Succeeded():
+			fmt.Fprintln(w, color.GreenString(line))
+		default:
+			fmt.Fprintln(w, color.RedString(line))
+		}
+	}
+
+	var summary []string
+	for _, status := range statuses {
+		summary = append(summary, fmt.Sprintf("%s %d", strings.ToLower(status), counts[status]))
+	}
+	fmt.Fprintf(w, "total %d, %s\n", len(results), strings.Join(summary, ", "))
+}
@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitCommandInvocations(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(interval time.Duration) { commandPollInterval = interval }(commandPollInterval)
+	commandPollInterval = 10 * time.Millisecond
+
+	var (
+		mu    sync.Mutex
+		polls = map[string]int{}
+	)
+	truncated := strings.Repeat("a", commandOutputLimit)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.GetCommandInvocation":
+			id := input["InstanceId"].(string)
+			mu.Lock()
+			polls[id]++
+			n := polls[id]
+			mu.Unlock()
+
+			switch {
+			// invocation isn't found right after command is sent.
+			case n == 1:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvocationDoesNotExist"}`))
+			case n == 2:
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"InProgress","ResponseCode":-1}`, id)
+			case id == "i-1":
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Success","ResponseCode":0,"StandardOutputContent":"ok\n"}`, id)
+			case id == "i-2":
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Failed","ResponseCode":2,"DocumentName":"AWS-RunShellScript","StandardOutputContent":%q,"StandardErrorContent":"boom"}`, id, truncated)
+			default:
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"TimedOut","ResponseCode":-1}`, id)
+			}
+		case "Logs_20140328.DescribeLogStreams":
+			assert.Equal("/aws/ssm/AWS-RunShellScript", input["logGroupName"])
+			assert.Equal("c-1/i-2/", input["logStreamNamePrefix"])
+			w.Write([]byte(`{"logStreams":[{"logStreamName":"c-1/i-2/aws-runShellScript/stdout"},{"logStreamName":"c-1/i-2/aws-runShellScript/stderr"}]}`))
+		case "Logs_20140328.GetLogEvents":
+			stream := input["logStreamName"].(string)
+			if input["nextToken"] != nil {
+				fmt.Fprintf(w, `{"events":[],"nextForwardToken":%q}`, input["nextToken"])
+				return
+			}
+			fmt.Fprintf(w, `{"events":[{"message":"full %s"}],"nextForwardToken":"f/1"}`, stream[strings.LastIndex(stream, "/")+1:])
+		}
+	}))
+	defer server.Close()
+
+	var printed []string
+	results := WaitCommandInvocations(context.Background(), newMockConfig(server.URL), "c-1",
+		[]*Target{{Name: "i-1", InstanceName: "web"}, {Name: "i-2"}, {Name: "i-3"}},
+		func(r *CommandResult) {
+			mu.Lock()
+			defer mu.Unlock()
+			printed = append(printed, r.InstanceId)
+		})
+	assert.Len(printed, 3)
+	assert.Len(results, 3)
+
+	assert.Equal(&CommandResult{CommandId: "c-1", InstanceId: "i-1", InstanceName: "web", Status: "Success", ExitCode: 0, Stdout: "ok\n"}, results[0])
+	assert.True(results[0].Succeeded())
+	assert.Equal("Failed", results[1].Status)
+	assert.Equal(int32(2), results[1].ExitCode)
+	assert.Equal("full stdout\n", results[1].Stdout)
+	assert.Equal("full stderr\n", results[1].Stderr)
+	assert.False(results[1].Truncated)
+	assert.Equal("TimedOut", results[2].Status)
+	assert.False(results[2].Succeeded())
+
+	buf := &bytes.Buffer{}
+	PrintCommandResult(buf, results[1])
+	assert.Contains(buf.String(), "exit code 2")
+	assert.Contains(buf.String(), "full stdout")
+	assert.Contains(buf.String(), "full stderr")
+
+	buf.Reset()
+	PrintCommandSummary(buf, results)
+	assert.Contains(buf.String(), "EXIT CODE")
+	assert.Contains(buf.String(), "total 3, success 1, failed 1, timedout 1")
+}
+
+func TestParseCommandParams(t *testing.T) {
+	assert := assert.New(t)
+
+	params, err := ParseCommandParams([]string{"commands=echo 1", "commands=echo 2", "workingDirectory=/tmp", "empty="})
+	assert.NoError(err)
+	assert.Equal(map[string][]string{
+		"commands":         {"echo 1", "echo 2"},
+		"workingDirectory": {"/tmp"},
+		"empty":            {""},
+	}, params)
+
+	_, err = ParseCommandParams([]string{"invalid"})
+	assert.Error(err)
+	_, err = ParseCommandParams([]string{"=value"})
+	assert.Error(err)
+}
+
+func TestReadCommandScript(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "deploy.sh")
+	assert.NoError(os.WriteFile(path, []byte("#!/bin/bash\r\nset -e\r\necho deploy\r\n\r\n"), 0644))
+	commands, err := ReadCommandScript(path)
+	assert.NoError(err)
+	assert.Equal([]string{"#!/bin/bash", "set -e", "echo deploy"}, commands)
+
+	empty := filepath.Join(t.TempDir(), "empty.sh")
+	assert.NoError(os.WriteFile(empty, []byte("\n"), 0644))
+	_, err = ReadCommandScript(empty)
+	assert.Error(err)
+	_, err = ReadCommandScript(filepath.Join(t.TempDir(), "none.sh"))
+	assert.Error(err)
+}
+
+func TestCommandInput_parameters(t *testing.T) {
+	assert := assert.New(t)
+
+	input := &CommandInput{
+		DocumentName:     DefaultCommandDocument,
+		Parameters:       map[string][]string{"extra": {"1"}},
+		Commands:         []string{"uptime"},
+		WorkingDirectory: "/opt/app",
+		Timeout:          10 * time.Minute,
+	}
+	assert.Equal(map[string][]string{
+		"extra":            {"1"},
+		"commands":         {"uptime"},
+		"workingDirectory": {"/opt/app"},
+		"executionTimeout": {"600"},
+	}, input.parameters())
+	assert.Equal(int32(600), input.deliveryTimeoutSeconds())
+
+	input = &CommandInput{DocumentName: DefaultCommandDocument, Timeout: 5 * time.Second}
+	assert.Equal(int32(30), input.deliveryTimeoutSeconds())
+	assert.Equal(int32(60), (&CommandInput{}).deliveryTimeoutSeconds())
+}
+
+func TestValidateCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch input["Name"] {
+		case "AWS-RunShellScript":
+			w.Write([]byte(`{"Document":{"Name":"AWS-RunShellScript","DocumentType":"Command","Parameters":[
+				{"Name":"commands","Type":"StringList","Description":"commands to run"},
+				{"Name":"workingDirectory","Type":"String","DefaultValue":""},
+				{"Name":"executionTimeout","Type":"String","DefaultValue":"3600"}]}}`))
+		case "AWS-StartPortForwardingSession":
+			w.Write([]byte(`{"Document":{"Name":"AWS-StartPortForwardingSession","DocumentType":"Session"}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"InvalidDocument","message":"document does not exist"}`))
+		}
+	}))
+	defer server.Close()
+	cfg := newMockConfig(server.URL)
+
+	tests := map[string]struct {
+		input *CommandInput
+		isErr bool
+	}{
+		"commands":         {input: &CommandInput{DocumentName: "AWS-RunShellScript", Commands: []string{"uptime"}, WorkingDirectory: "/tmp", Timeout: time.Minute}},
+		"param":            {input: &CommandInput{DocumentName: "AWS-RunShellScript", Parameters: map[string][]string{"commands": {"uptime"}}}},
+		"required":         {input: &CommandInput{DocumentName: "AWS-RunShellScript"}, isErr: true},
+		"unknown param":    {input: &CommandInput{DocumentName: "AWS-RunShellScript", Commands: []string{"uptime"}, Parameters: map[string][]string{"foo": {"1"}}}, isErr: true},
+		"session":          {input: &CommandInput{DocumentName: "AWS-StartPortForwardingSession"}, isErr: true},
+		"unknown document": {input: &CommandInput{DocumentName: "none"}, isErr: true},
+	}
+	for name, tt := range tests {
+		err := ValidateCommand(context.Background(), cfg, tt.input)
+		assert.Equal(tt.isErr, err != nil, name)
+	}
+}
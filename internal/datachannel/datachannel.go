@@ -0,0 +1,669 @@
+package datachannel
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// ClientVersion is a version which is reported to the agent by default.
+	// It stays below 1.1.70, so the agent relays a session as one stream, such as shell, ssh and a connection of socks.
+	ClientVersion = "1.0.0.0"
+	// MuxClientVersion is reported by port forwarding which accepts many connections in a session,
+	// agents which support it multiplex connections with smux. (see SupportsMux)
+	MuxClientVersion = "1.2.0.0"
+
+	// agents after muxAgentVersion multiplex connections of port forwarding,
+	// and agents from smuxKeepAliveAgentVersion don't need keep alive of smux.
+	muxAgentVersion           = "3.0.196.0"
+	smuxKeepAliveAgentVersion = "3.1.1511.0"
+
+	// maxPayloadSize is a max size of input payload in one message.
+	maxPayloadSize = 1024
+
+	resendInterval    = 100 * time.Millisecond
+	resendTimeout     = 500 * time.Millisecond
+	maxResendAttempts = 600
+	// DefaultPingInterval is an interval of pings which keep websocket connection alive.
+	DefaultPingInterval = 5 * time.Minute
+	// pongTimeout is added to deadline of read up to ping interval, because pong of the last ping may be delayed.
+	pongTimeout      = 10 * time.Second
+	handshakeTimeout = 10 * time.Second
+)
+
+var (
+	// ErrClosed is an error type to use when data channel is already closed.
+	ErrClosed = errors.New("[err] data channel is closed")
+	// ErrNotAcknowledged is an error type to use when the agent doesn't acknowledge messages.
+	ErrNotAcknowledged = errors.New("[err] message is not acknowledged")
+)
+
+type (
+	// DataChannel speaks session manager message protocol through websocket.
+	// It implements io.ReadWriteCloser, Read returns output of a session and Write sends input to a session.
+	DataChannel struct {
+		streamUrl     string
+		token         string
+		clientVersion string
+		conn          *websocket.Conn
+		stderr        io.Writer
+		// connection is lost if nothing is read for two intervals of ping.
+		pingInterval time.Duration
+
+		writeMu sync.Mutex
+
+		mu           sync.Mutex
+		cond         *sync.Cond
+		seq          int64
+		outgoing     []*pending
+		paused       bool
+		handshaking  bool
+		sessionType  string
+		agentVersion string
+
+		// expected and incoming are only touched by read loop.
+		expected int64
+		incoming map[int64]*Message
+
+		reader *io.PipeReader
+		writer *io.PipeWriter
+
+		ready     chan struct{}
+		readyOnce sync.Once
+		done      chan struct{}
+		closeOnce sync.Once
+		err       error
+	}
+
+	pending struct {
+		seq      int64
+		data     []byte
+		sentAt   time.Time
+		attempts int
+	}
+)
+
+// New creates a data channel with stream url and token of started session.
+func New(streamUrl, token string) *DataChannel {
+	r, w := io.Pipe()
+	d := &DataChannel{
+		streamUrl:     streamUrl,
+		token:         token,
+		clientVersion: ClientVersion,
+		stderr:        os.Stderr,
+		pingInterval:  DefaultPingInterval,
+		incoming:      make(map[int64]*Message),
+		reader:        r,
+		writer:        w,
+		ready:         make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+	d.cond = sync.NewCond(&d.mu)
+	return d
+}
+
+// SetStderr sets writer for standard error stream and messages of the agent. (default is os.Stderr)
+func (d *DataChannel) SetStderr(w io.Writer) {
+	d.stderr = w
+}
+
+// SetPingInterval sets an interval of pings, and connection is lost if nothing is read for two intervals.
+// It must be called before Open. (default is DefaultPingInterval)
+func (d *DataChannel) SetPingInterval(interval time.Duration) {
+	if interval > 0 {
+		d.pingInterval = interval
+	}
+}
+
+// SetClientVersion sets a version which is reported to the agent, such as MuxClientVersion.
+// It must be called before Open. (default is ClientVersion)
+func (d *DataChannel) SetClientVersion(version string) {
+	if version != "" {
+		d.clientVersion = version
+	}
+}
+
+// Open connects to stream url, and then starts to exchange messages.
+func (d *DataChannel) Open(ctx context.Context) error {
+	if ctx == nil {
+		return fmt.Errorf("[err] invalid context")
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, d.streamUrl, nil)
+	if err != nil {
+		return err
+	}
+	d.conn = conn
+	// pong and messages extend deadline of read, so a dead connection is detected by pings.
+	conn.SetReadDeadline(d.readDeadline())
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(d.readDeadline())
+	})
+
+	input, err := json.Marshal(&openDataChannelInput{
+		MessageSchemaVersion: "1.0",
+		RequestId:            uuid.New().String(),
+		TokenValue:           d.token,
+		ClientId:             uuid.New().String(),
+		ClientVersion:        d.clientVersion,
+	})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	d.writeMu.Lock()
+	err = conn.WriteMessage(websocket.TextMessage, input)
+	d.writeMu.Unlock()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	go d.readLoop()
+	go d.resendLoop()
+	go d.pingLoop()
+	go d.waitHandshake()
+	return nil
+}
+
+// Read reads output of a session.
+func (d *DataChannel) Read(p []byte) (int, error) {
+	return d.reader.Read(p)
+}
+
+// Write sends input to a session. It blocks until a session is ready.
+func (d *DataChannel) Write(p []byte) (int, error) {
+	if err := d.waitReady(); err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(p); i += maxPayloadSize {
+		end := i + maxPayloadSize
+		if end > len(p) {
+			end = len(p)
+		}
+		chunk := make([]byte, end-i)
+		copy(chunk, p[i:end])
+		if err := d.send(Output, chunk); err != nil {
+			return i, err
+		}
+	}
+	return len(p), nil
+}
+
+// SetSize notifies size of terminal to a session.
+func (d *DataChannel) SetSize(cols, rows uint32) error {
+	if err := d.waitReady(); err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(&sizeData{Cols: cols, Rows: rows})
+	if err != nil {
+		return err
+	}
+	return d.send(Size, payload)
+}
+
+// SendFlag sends a flag, such as DisconnectToPort, to a session.
+func (d *DataChannel) SendFlag(flag PayloadTypeFlag) error {
+	if err := d.waitReady(); err != nil {
+		return err
+	}
+
+	payload := make([]byte, 4)
+	binary.BigEndian.PutUint32(payload, uint32(flag))
+	return d.send(Flag, payload)
+}
+
+// SessionType returns session type which the agent requested in handshake, such as Standard_Stream and Port.
+func (d *DataChannel) SessionType() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.sessionType
+}
+
+// AgentVersion returns version of the agent which is notified in handshake, it is empty if the agent doesn't request handshake.
+func (d *DataChannel) AgentVersion() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.agentVersion
+}
+
+// Ready returns a channel which is closed when a session is ready to receive input.
+func (d *DataChannel) Ready() <-chan struct{} {
+	return d.ready
+}
+
+// Done returns a channel which is closed when data channel is closed.
+func (d *DataChannel) Done() <-chan struct{} {
+	return d.done
+}
+
+// Err returns a reason that data channel is closed. it returns nil when a session ends normally.
+func (d *DataChannel) Err() error {
+	select {
+	case <-d.done:
+		return d.err
+	default:
+		return nil
+	}
+}
+
+// Close closes data channel.
+func (d *DataChannel) Close() error {
+	d.finish(nil)
+	return nil
+}
+
+// waitReady waits until handshake is completed or data channel is closed.
+func (d *DataChannel) waitReady() error {
+	select {
+	case <-d.ready:
+		return nil
+	case <-d.done:
+		return d.closedErr()
+	}
+}
+
+func (d *DataChannel) closedErr() error {
+	if d.err != nil {
+		return d.err
+	}
+	return ErrClosed
+}
+
+func (d *DataChannel) markReady() {
+	d.readyOnce.Do(func() { close(d.ready) })
+}
+
+// send sends input_stream_data message, and keeps it until it is acknowledged.
+// It waits while publication is paused by the agent.
+func (d *DataChannel) send(payloadType PayloadType, payload []byte) error {
+	return d.sendMessage(payloadType, payload, true)
+}
+
+// sendControl sends a reply to the agent, such as handshake response, regardless of paused publication.
+// Replies are sent by read loop which resumes publication, so waiting for it would never end.
+func (d *DataChannel) sendControl(payloadType PayloadType, payload []byte) error {
+	return d.sendMessage(payloadType, payload, false)
+}
+
+func (d *DataChannel) sendMessage(payloadType PayloadType, payload []byte, pausable bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for pausable && d.paused && !d.isDone() {
+		d.cond.Wait()
+	}
+	if d.isDone() {
+		return d.closedErr()
+	}
+
+	flags := flagData
+	if d.seq == 0 {
+		flags = flagSyn
+	}
+	msg := newMessage(InputStreamMessage, d.seq, flags, payloadType, payload)
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	d.outgoing = append(d.outgoing, &pending{seq: d.seq, data: data, sentAt: time.Now()})
+	d.seq++
+	return d.writeBinary(data)
+}
+
+// acknowledge sends acknowledge message for a received message.
+func (d *DataChannel) acknowledge(msg *Message) error {
+	payload, err := json.Marshal(&acknowledgeContent{
+		MessageType:         msg.MessageType,
+		MessageId:           msg.MessageId.String(),
+		SequenceNumber:      msg.SequenceNumber,
+		IsSequentialMessage: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	data, err := newMessage(AcknowledgeMessage, 0, flagAck, 0, payload).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return d.writeBinary(data)
+}
+
+func (d *DataChannel) writeBinary(data []byte) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	return d.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+func (d *DataChannel) isDone() bool {
+	select {
+	case <-d.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish closes data channel with a reason.
+func (d *DataChannel) finish(err error) {
+	d.closeOnce.Do(func() {
+		d.mu.Lock()
+		d.err = err
+		close(d.done)
+		d.cond.Broadcast()
+		d.mu.Unlock()
+
+		if d.conn != nil {
+			d.writeMu.Lock()
+			d.conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+			d.writeMu.Unlock()
+			d.conn.Close()
+		}
+		if err != nil {
+			d.writer.CloseWithError(err)
+		} else {
+			d.writer.Close()
+		}
+	})
+}
+
+// readLoop reads messages from websocket until it is closed.
+func (d *DataChannel) readLoop() {
+	for {
+		mt, data, err := d.conn.ReadMessage()
+		if err != nil {
+			if d.isDone() || websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				d.finish(nil)
+			} else {
+				d.finish(err)
+			}
+			return
+		}
+		d.conn.SetReadDeadline(d.readDeadline())
+		if mt != websocket.BinaryMessage {
+			continue
+		}
+
+		msg := &Message{}
+		if err := msg.UnmarshalBinary(data); err != nil {
+			d.finish(err)
+			return
+		}
+		if err := d.handle(msg); err != nil {
+			d.finish(err)
+			return
+		}
+	}
+}
+
+// handle handles a message by its type.
+func (d *DataChannel) handle(msg *Message) error {
+	switch msg.MessageType {
+	case AcknowledgeMessage:
+		ack := &acknowledgeContent{}
+		if err := json.Unmarshal(msg.Payload, ack); err != nil {
+			return err
+		}
+		d.mu.Lock()
+		for i, p := range d.outgoing {
+			if p.seq == ack.SequenceNumber {
+				d.outgoing = append(d.outgoing[:i], d.outgoing[i+1:]...)
+				break
+			}
+		}
+		d.mu.Unlock()
+	case OutputStreamMessage:
+		return d.handleOutput(msg)
+	case ChannelClosedMessage:
+		closed := &channelClosed{}
+		if err := json.Unmarshal(msg.Payload, closed); err != nil {
+			return err
+		}
+		if closed.Output != "" {
+			fmt.Fprintf(d.stderr, "\n\nSessionId: %s : %s\n\n", closed.SessionId, closed.Output)
+		}
+		d.finish(nil)
+	case StartPublicationMessage:
+		d.mu.Lock()
+		d.paused = false
+		d.cond.Broadcast()
+		d.mu.Unlock()
+	case PausePublicationMessage:
+		d.mu.Lock()
+		d.paused = true
+		d.mu.Unlock()
+	}
+	return nil
+}
+
+// handleOutput processes output_stream_data messages in sequence order.
+func (d *DataChannel) handleOutput(msg *Message) error {
+	if err := d.acknowledge(msg); err != nil {
+		return err
+	}
+
+	switch {
+	case msg.SequenceNumber < d.expected: // duplicated message which was resent.
+		return nil
+	case msg.SequenceNumber > d.expected: // keep it until missing messages arrive.
+		d.incoming[msg.SequenceNumber] = msg
+		return nil
+	}
+
+	for {
+		if err := d.process(msg); err != nil {
+			return err
+		}
+		d.expected++
+
+		next, ok := d.incoming[d.expected]
+		if !ok {
+			return nil
+		}
+		delete(d.incoming, d.expected)
+		msg = next
+	}
+}
+
+// process processes payload of output_stream_data message.
+func (d *DataChannel) process(msg *Message) error {
+	switch msg.PayloadType {
+	case Output:
+		// old agents don't support handshake, so the first output means a session is ready.
+		d.markReady()
+		if _, err := d.writer.Write(msg.Payload); err != nil {
+			return err
+		}
+	case StdErr:
+		d.stderr.Write(msg.Payload)
+	case HandshakeRequest:
+		return d.handleHandshake(msg.Payload)
+	case HandshakeComplete:
+		complete := &handshakeCompletePayload{}
+		if err := json.Unmarshal(msg.Payload, complete); err != nil {
+			return err
+		}
+		if complete.CustomerMessage != "" {
+			fmt.Fprintln(d.stderr, complete.CustomerMessage)
+		}
+		d.markReady()
+	}
+	return nil
+}
+
+// handleHandshake responds actions which the agent requested.
+func (d *DataChannel) handleHandshake(payload []byte) error {
+	request := &handshakeRequestPayload{}
+	if err := json.Unmarshal(payload, request); err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	d.handshaking = true
+	d.agentVersion = request.AgentVersion
+	d.mu.Unlock()
+
+	response := &handshakeResponsePayload{ClientVersion: d.clientVersion, Errors: []string{}}
+	for _, action := range request.RequestedClientActions {
+		processed := processedClientAction{ActionType: action.ActionType}
+		switch action.ActionType {
+		case actionSessionType:
+			sessionType := &sessionTypeRequest{}
+			if err := json.Unmarshal(action.ActionParameters, sessionType); err != nil {
+				processed.ActionStatus = actionFailed
+				processed.Error = err.Error()
+				break
+			}
+			d.mu.Lock()
+			d.sessionType = sessionType.SessionType
+			d.mu.Unlock()
+			processed.ActionStatus = actionSuccess
+		case actionKMSEncryption:
+			processed.ActionStatus = actionFailed
+			processed.Error = "KMS encryption isn't supported by gossm"
+			response.Errors = append(response.Errors, processed.Error)
+		default:
+			processed.ActionStatus = actionUnsupported
+			processed.Error = fmt.Sprintf("%s isn't supported by gossm", action.ActionType)
+			response.Errors = append(response.Errors, processed.Error)
+		}
+		response.ProcessedClientActions = append(response.ProcessedClientActions, processed)
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	return d.sendControl(HandshakeResponse, data)
+}
+
+// waitHandshake regards a session as ready when the agent doesn't request handshake in time.
+func (d *DataChannel) waitHandshake() {
+	select {
+	case <-d.ready:
+	case <-d.done:
+	case <-time.After(handshakeTimeout):
+		d.mu.Lock()
+		handshaking := d.handshaking
+		d.mu.Unlock()
+		if !handshaking {
+			d.markReady()
+		}
+	}
+}
+
+// resendLoop resends messages which aren't acknowledged in time.
+func (d *DataChannel) resendLoop() {
+	ticker := time.NewTicker(resendInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.mu.Lock()
+			var err error
+			for _, p := range d.outgoing {
+				if time.Since(p.sentAt) < resendTimeout {
+					continue
+				}
+				p.attempts++
+				if p.attempts > maxResendAttempts {
+					err = fmt.Errorf("%w: sequence number %d", ErrNotAcknowledged, p.seq)
+					break
+				}
+				p.sentAt = time.Now()
+				if err = d.writeBinary(p.data); err != nil {
+					break
+				}
+			}
+			d.mu.Unlock()
+
+			if err != nil {
+				d.finish(err)
+				return
+			}
+		}
+	}
+}
+
+// readDeadline returns deadline of next read.
+func (d *DataChannel) readDeadline() time.Time {
+	timeout := pongTimeout
+	if d.pingInterval < timeout {
+		timeout = d.pingInterval
+	}
+	return time.Now().Add(2*d.pingInterval + timeout)
+}
+
+// pingLoop keeps websocket connection alive.
+func (d *DataChannel) pingLoop() {
+	ticker := time.NewTicker(d.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.done:
+			return
+		case <-ticker.C:
+			d.writeMu.Lock()
+			err := d.conn.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(10*time.Second))
+			d.writeMu.Unlock()
+			if err != nil {
+				d.finish(err)
+				return
+			}
+		}
+	}
+}
+
+// SupportsMux returns true if the agent of version multiplexes connections of port forwarding with smux,
+// when client reports MuxClientVersion.
+func SupportsMux(agentVersion string) bool {
+	return compareVersion(agentVersion, muxAgentVersion) > 0
+}
+
+// SupportsSmuxKeepAlive returns true if the agent of version needs keep alive of smux.
+func SupportsSmuxKeepAlive(agentVersion string) bool {
+	return compareVersion(agentVersion, smuxKeepAliveAgentVersion) < 0
+}
+
+// compareVersion compares dotted versions by numbers, invalid or missing numbers are regarded as 0.
+func compareVersion(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+	}
+	return 0
+}
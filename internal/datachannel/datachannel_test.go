@@ -0,0 +1,387 @@
+package datachannel
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockAgent is a websocket stand-in for session manager and the agent.
+type mockAgent struct {
+	t    *testing.T
+	conn *websocket.Conn
+	seq  int64
+}
+
+func newMockServer(t *testing.T, token string, script func(agent *mockAgent)) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		input := &openDataChannelInput{}
+		if err := json.Unmarshal(data, input); err != nil || input.TokenValue != token {
+			conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "invalid token"))
+			return
+		}
+		script(&mockAgent{t: t, conn: conn})
+	}))
+}
+
+func wsUrl(server *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+// send sends output_stream_data with next sequence number.
+func (a *mockAgent) send(payloadType PayloadType, payload []byte) {
+	a.sendWithSeq(a.seq, payloadType, payload)
+	a.seq++
+}
+
+func (a *mockAgent) sendWithSeq(seq int64, payloadType PayloadType, payload []byte) {
+	data, _ := newMessage(OutputStreamMessage, seq, flagData, payloadType, payload).MarshalBinary()
+	a.conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// read reads next input_stream_data, and acknowledges it when ack is true.
+func (a *mockAgent) read(ack bool) *Message {
+	for {
+		_, data, err := a.conn.ReadMessage()
+		if err != nil {
+			return nil
+		}
+		msg := &Message{}
+		if err := msg.UnmarshalBinary(data); err != nil {
+			a.t.Error(err)
+			return nil
+		}
+		if msg.MessageType != InputStreamMessage {
+			continue
+		}
+		if ack {
+			payload, _ := json.Marshal(&acknowledgeContent{
+				MessageType:    msg.MessageType,
+				MessageId:      msg.MessageId.String(),
+				SequenceNumber: msg.SequenceNumber,
+			})
+			data, _ := newMessage(AcknowledgeMessage, 0, flagAck, 0, payload).MarshalBinary()
+			a.conn.WriteMessage(websocket.BinaryMessage, data)
+		}
+		return msg
+	}
+}
+
+// handshake requests actions, and then completes handshake.
+func (a *mockAgent) handshake(actions ...requestedClientAction) *handshakeResponsePayload {
+	if len(actions) == 0 {
+		actions = []requestedClientAction{{
+			ActionType:       actionSessionType,
+			ActionParameters: json.RawMessage(`{"SessionType":"Standard_Stream","Properties":null}`),
+		}}
+	}
+	request, _ := json.Marshal(&handshakeRequestPayload{AgentVersion: "3.1.0.0", RequestedClientActions: actions})
+	a.send(HandshakeRequest, request)
+
+	msg := a.read(true)
+	if msg == nil || msg.PayloadType != HandshakeResponse {
+		a.t.Error("not found handshake response")
+		return nil
+	}
+	response := &handshakeResponsePayload{}
+	if err := json.Unmarshal(msg.Payload, response); err != nil {
+		a.t.Error(err)
+		return nil
+	}
+
+	complete, _ := json.Marshal(&handshakeCompletePayload{})
+	a.send(HandshakeComplete, complete)
+	return response
+}
+
+func TestDataChannel_Echo(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		agent.handshake()
+		for {
+			msg := agent.read(true)
+			if msg == nil {
+				return
+			}
+			if msg.PayloadType == Output {
+				agent.send(Output, msg.Payload)
+			}
+		}
+	})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	_, err := dc.Write([]byte("ping"))
+	assert.NoError(err)
+
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(dc, buf)
+	assert.NoError(err)
+	assert.Equal("ping", string(buf))
+	assert.Equal("Standard_Stream", dc.SessionType())
+}
+
+func TestDataChannel_Resend(t *testing.T) {
+	assert := assert.New(t)
+
+	received := make(chan int64, 10)
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		agent.handshake()
+
+		// doesn't acknowledge at first, so that client resends it.
+		first := agent.read(false)
+		received <- first.SequenceNumber
+		second := agent.read(true)
+		received <- second.SequenceNumber
+		agent.send(Output, second.Payload)
+		agent.read(true)
+	})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	_, err := dc.Write([]byte("retry"))
+	assert.NoError(err)
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(dc, buf)
+	assert.NoError(err)
+	assert.Equal("retry", string(buf))
+	assert.Equal(<-received, <-received)
+}
+
+func TestDataChannel_OutOfOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		agent.handshake()
+
+		// sends messages in reverse order, and then sends a duplicated message.
+		agent.sendWithSeq(agent.seq+2, Output, []byte("c"))
+		agent.sendWithSeq(agent.seq+1, Output, []byte("b"))
+		agent.sendWithSeq(agent.seq, Output, []byte("a"))
+		agent.sendWithSeq(agent.seq, Output, []byte("a"))
+		agent.seq += 3
+
+		closed, _ := json.Marshal(&channelClosed{SessionId: "session", MessageType: ChannelClosedMessage})
+		data, _ := newMessage(ChannelClosedMessage, agent.seq, flagFin, 0, closed).MarshalBinary()
+		agent.conn.WriteMessage(websocket.BinaryMessage, data)
+		agent.read(false)
+	})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	assert.NoError(dc.Open(context.Background()))
+
+	output, err := ioutil.ReadAll(dc)
+	assert.NoError(err)
+	assert.Equal("abc", string(output))
+
+	<-dc.Done()
+	assert.NoError(dc.Err())
+}
+
+func TestDataChannel_Handshake(t *testing.T) {
+	assert := assert.New(t)
+
+	responses := make(chan *handshakeResponsePayload, 1)
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		responses <- agent.handshake(
+			requestedClientAction{
+				ActionType:       actionSessionType,
+				ActionParameters: json.RawMessage(`{"SessionType":"Port","Properties":{"portNumber":"22"}}`),
+			},
+			requestedClientAction{
+				ActionType:       actionKMSEncryption,
+				ActionParameters: json.RawMessage(`{"KMSKeyId":"key"}`),
+			},
+		)
+		agent.read(true)
+	})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	response := <-responses
+	assert.Equal(ClientVersion, response.ClientVersion)
+	assert.Len(response.ProcessedClientActions, 2)
+	assert.Equal(actionSuccess, response.ProcessedClientActions[0].ActionStatus)
+	assert.Equal(actionFailed, response.ProcessedClientActions[1].ActionStatus)
+
+	select {
+	case <-dc.Ready():
+	case <-time.After(5 * time.Second):
+		assert.Fail("handshake isn't completed")
+	}
+	assert.Equal("Port", dc.SessionType())
+	assert.Equal("3.1.0.0", dc.AgentVersion())
+	assert.NoError(dc.SendFlag(DisconnectToPort))
+}
+
+func TestDataChannel_ClientVersion(t *testing.T) {
+	assert := assert.New(t)
+
+	versions := make(chan string, 2)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		agent := &mockAgent{t: t, conn: conn}
+
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		input := &openDataChannelInput{}
+		json.Unmarshal(data, input)
+		versions <- input.ClientVersion
+		versions <- agent.handshake().ClientVersion
+		agent.read(true)
+	}))
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	dc.SetClientVersion(MuxClientVersion)
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	assert.Equal(MuxClientVersion, <-versions)
+	assert.Equal(MuxClientVersion, <-versions)
+}
+
+func TestSupportsMux(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		mux       bool
+		keepAlive bool
+	}{
+		"":           {mux: false, keepAlive: true},
+		"2.3.672.0":  {mux: false, keepAlive: true},
+		"3.0.196.0":  {mux: false, keepAlive: true},
+		"3.0.222.0":  {mux: true, keepAlive: true},
+		"3.1.1511.0": {mux: true, keepAlive: false},
+		"3.2.582.0":  {mux: true, keepAlive: false},
+	}
+
+	for version, t := range tests {
+		assert.Equal(t.mux, SupportsMux(version), version)
+		assert.Equal(t.keepAlive, SupportsSmuxKeepAlive(version), version)
+	}
+}
+
+func TestDataChannel_PausedHandshake(t *testing.T) {
+	assert := assert.New(t)
+
+	// agent pauses publication before handshake, and resumes it after handshake response.
+	responses := make(chan *handshakeResponsePayload, 1)
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		pause, _ := newMessage(PausePublicationMessage, 0, flagData, 0, nil).MarshalBinary()
+		agent.conn.WriteMessage(websocket.BinaryMessage, pause)
+		responses <- agent.handshake()
+
+		start, _ := newMessage(StartPublicationMessage, 0, flagData, 0, nil).MarshalBinary()
+		agent.conn.WriteMessage(websocket.BinaryMessage, start)
+		for {
+			msg := agent.read(true)
+			if msg == nil {
+				return
+			}
+			if msg.PayloadType == Output {
+				agent.send(Output, msg.Payload)
+			}
+		}
+	})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "token")
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	select {
+	case response := <-responses:
+		assert.NotNil(response)
+	case <-time.After(5 * time.Second):
+		assert.Fail("handshake response isn't sent while publication is paused")
+		return
+	}
+
+	_, err := dc.Write([]byte("ping"))
+	assert.NoError(err)
+	buf := make([]byte, 4)
+	_, err = io.ReadFull(dc, buf)
+	assert.NoError(err)
+	assert.Equal("ping", string(buf))
+}
+
+func TestDataChannel_InvalidToken(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockServer(t, "token", func(agent *mockAgent) {})
+	defer server.Close()
+
+	dc := New(wsUrl(server), "invalid")
+	assert.NoError(dc.Open(context.Background()))
+
+	_, err := ioutil.ReadAll(dc)
+	assert.Error(err)
+	_, err = dc.Write([]byte("ping"))
+	assert.Error(err)
+}
+
+func TestDataChannel_PingTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	// agent stops reading after handshake, so pings aren't answered.
+	stop := make(chan struct{})
+	server := newMockServer(t, "token", func(agent *mockAgent) {
+		agent.handshake()
+		<-stop
+	})
+	defer server.Close()
+	defer close(stop)
+
+	dc := New(wsUrl(server), "token")
+	dc.SetPingInterval(50 * time.Millisecond)
+	assert.NoError(dc.Open(context.Background()))
+	defer dc.Close()
+
+	select {
+	case <-dc.Done():
+		assert.Error(dc.Err())
+	case <-time.After(5 * time.Second):
+		assert.Fail("connection isn't lost")
+	}
+}
@@ -0,0 +1,267 @@
+package datachannel
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// message types which are used by session manager.
+const (
+	InputStreamMessage      = "input_stream_data"
+	OutputStreamMessage     = "output_stream_data"
+	AcknowledgeMessage      = "acknowledge"
+	ChannelClosedMessage    = "channel_closed"
+	StartPublicationMessage = "start_publication"
+	PausePublicationMessage = "pause_publication"
+)
+
+// PayloadType is a type of payload in a message.
+type PayloadType uint32
+
+const (
+	Output               PayloadType = 1
+	Error                PayloadType = 2
+	Size                 PayloadType = 3
+	Parameter            PayloadType = 4
+	HandshakeRequest     PayloadType = 5
+	HandshakeResponse    PayloadType = 6
+	HandshakeComplete    PayloadType = 7
+	EncChallengeRequest  PayloadType = 8
+	EncChallengeResponse PayloadType = 9
+	Flag                 PayloadType = 10
+	StdErr               PayloadType = 11
+	ExitCode             PayloadType = 12
+)
+
+// PayloadTypeFlag is a value of Flag payload.
+type PayloadTypeFlag uint32
+
+const (
+	DisconnectToPort   PayloadTypeFlag = 1
+	TerminateSession   PayloadTypeFlag = 2
+	ConnectToPortError PayloadTypeFlag = 3
+)
+
+// flags of a message.
+const (
+	flagData uint64 = 0
+	flagSyn  uint64 = 1
+	flagFin  uint64 = 2
+	flagAck  uint64 = 3
+)
+
+// offsets of fields in a serialized message.
+const (
+	headerLengthOffset   = 0
+	messageTypeOffset    = 4
+	schemaVersionOffset  = 36
+	createdDateOffset    = 40
+	sequenceNumberOffset = 48
+	flagsOffset          = 56
+	messageIdOffset      = 64
+	payloadDigestOffset  = 80
+	payloadTypeOffset    = 112
+	payloadLengthOffset  = 116
+	payloadOffset        = 120
+
+	messageTypeLength   = 32
+	payloadDigestLength = 32
+)
+
+var (
+	// ErrInvalidMessage is an error type to use when a message is malformed.
+	ErrInvalidMessage = errors.New("[err] invalid message")
+)
+
+type (
+	// Message is a binary message which is exchanged with session manager through websocket.
+	Message struct {
+		HeaderLength   uint32
+		MessageType    string
+		SchemaVersion  uint32
+		CreatedDate    uint64
+		SequenceNumber int64
+		Flags          uint64
+		MessageId      uuid.UUID
+		PayloadDigest  []byte
+		PayloadType    PayloadType
+		PayloadLength  uint32
+		Payload        []byte
+	}
+
+	// openDataChannelInput is the first text message to authenticate a stream.
+	openDataChannelInput struct {
+		MessageSchemaVersion string `json:"MessageSchemaVersion"`
+		RequestId            string `json:"RequestId"`
+		TokenValue           string `json:"TokenValue"`
+		ClientId             string `json:"ClientId"`
+		ClientVersion        string `json:"ClientVersion"`
+	}
+
+	// acknowledgeContent is a payload of acknowledge message.
+	acknowledgeContent struct {
+		MessageType         string `json:"AcknowledgedMessageType"`
+		MessageId           string `json:"AcknowledgedMessageId"`
+		SequenceNumber      int64  `json:"AcknowledgedMessageSequenceNumber"`
+		IsSequentialMessage bool   `json:"IsSequentialMessage"`
+	}
+
+	// channelClosed is a payload of channel_closed message.
+	channelClosed struct {
+		MessageId     string `json:"MessageId"`
+		CreatedDate   string `json:"CreatedDate"`
+		DestinationId string `json:"DestinationId"`
+		SessionId     string `json:"SessionId"`
+		MessageType   string `json:"MessageType"`
+		SchemaVersion int    `json:"SchemaVersion"`
+		Output        string `json:"Output"`
+	}
+
+	// sizeData is a payload to notify terminal size.
+	sizeData struct {
+		Cols uint32 `json:"cols"`
+		Rows uint32 `json:"rows"`
+	}
+
+	// handshakeRequestPayload is a payload which the agent requests to client at first.
+	handshakeRequestPayload struct {
+		AgentVersion           string                  `json:"AgentVersion"`
+		RequestedClientActions []requestedClientAction `json:"RequestedClientActions"`
+	}
+
+	requestedClientAction struct {
+		ActionType       string          `json:"ActionType"`
+		ActionParameters json.RawMessage `json:"ActionParameters"`
+	}
+
+	sessionTypeRequest struct {
+		SessionType string          `json:"SessionType"`
+		Properties  json.RawMessage `json:"Properties"`
+	}
+
+	// handshakeResponsePayload is a payload which client responds to handshake request.
+	handshakeResponsePayload struct {
+		ClientVersion          string                  `json:"ClientVersion"`
+		ProcessedClientActions []processedClientAction `json:"ProcessedClientActions"`
+		Errors                 []string                `json:"Errors"`
+	}
+
+	processedClientAction struct {
+		ActionType   string          `json:"ActionType"`
+		ActionStatus int             `json:"ActionStatus"`
+		ActionResult json.RawMessage `json:"ActionResult,omitempty"`
+		Error        string          `json:"Error"`
+	}
+
+	// handshakeCompletePayload is a payload which the agent notifies when handshake is completed.
+	handshakeCompletePayload struct {
+		HandshakeTimeToComplete time.Duration `json:"HandshakeTimeToComplete"`
+		CustomerMessage         string        `json:"CustomerMessage"`
+	}
+)
+
+// action types and statuses in handshake.
+const (
+	actionKMSEncryption = "KMSEncryption"
+	actionSessionType   = "SessionType"
+
+	actionSuccess     = 1
+	actionFailed      = 2
+	actionUnsupported = 3
+)
+
+// newMessage creates a message with payload.
+func newMessage(messageType string, seq int64, flags uint64, payloadType PayloadType, payload []byte) *Message {
+	return &Message{
+		MessageType:    messageType,
+		SchemaVersion:  1,
+		CreatedDate:    uint64(time.Now().UnixNano() / int64(time.Millisecond)),
+		SequenceNumber: seq,
+		Flags:          flags,
+		MessageId:      uuid.New(),
+		PayloadType:    payloadType,
+		Payload:        payload,
+	}
+}
+
+// MarshalBinary serializes message to bytes.
+func (m *Message) MarshalBinary() ([]byte, error) {
+	if len(m.MessageType) > messageTypeLength {
+		return nil, fmt.Errorf("%w: message type %s is too long", ErrInvalidMessage, m.MessageType)
+	}
+
+	digest := sha256.Sum256(m.Payload)
+	buf := make([]byte, payloadOffset+len(m.Payload))
+
+	binary.BigEndian.PutUint32(buf[headerLengthOffset:], payloadLengthOffset)
+	copy(buf[messageTypeOffset:schemaVersionOffset], m.MessageType+strings.Repeat(" ", messageTypeLength-len(m.MessageType)))
+	binary.BigEndian.PutUint32(buf[schemaVersionOffset:], m.SchemaVersion)
+	binary.BigEndian.PutUint64(buf[createdDateOffset:], m.CreatedDate)
+	binary.BigEndian.PutUint64(buf[sequenceNumberOffset:], uint64(m.SequenceNumber))
+	binary.BigEndian.PutUint64(buf[flagsOffset:], m.Flags)
+	putUUID(buf[messageIdOffset:payloadDigestOffset], m.MessageId)
+	copy(buf[payloadDigestOffset:payloadTypeOffset], digest[:])
+	binary.BigEndian.PutUint32(buf[payloadTypeOffset:], uint32(m.PayloadType))
+	binary.BigEndian.PutUint32(buf[payloadLengthOffset:], uint32(len(m.Payload)))
+	copy(buf[payloadOffset:], m.Payload)
+	return buf, nil
+}
+
+// UnmarshalBinary deserializes message from bytes.
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) < payloadOffset {
+		return fmt.Errorf("%w: message is too short (%d bytes)", ErrInvalidMessage, len(data))
+	}
+
+	m.HeaderLength = binary.BigEndian.Uint32(data[headerLengthOffset:])
+	if int(m.HeaderLength)+4 > len(data) || m.HeaderLength < payloadLengthOffset {
+		return fmt.Errorf("%w: header length %d", ErrInvalidMessage, m.HeaderLength)
+	}
+
+	m.MessageType = strings.TrimRight(string(data[messageTypeOffset:schemaVersionOffset]), " \x00")
+	m.SchemaVersion = binary.BigEndian.Uint32(data[schemaVersionOffset:])
+	m.CreatedDate = binary.BigEndian.Uint64(data[createdDateOffset:])
+	m.SequenceNumber = int64(binary.BigEndian.Uint64(data[sequenceNumberOffset:]))
+	m.Flags = binary.BigEndian.Uint64(data[flagsOffset:])
+	m.MessageId = getUUID(data[messageIdOffset:payloadDigestOffset])
+	m.PayloadDigest = append([]byte(nil), data[payloadDigestOffset:payloadTypeOffset]...)
+	m.PayloadType = PayloadType(binary.BigEndian.Uint32(data[payloadTypeOffset:]))
+	m.PayloadLength = binary.BigEndian.Uint32(data[m.HeaderLength:])
+
+	start := int(m.HeaderLength) + 4
+	if start+int(m.PayloadLength) > len(data) {
+		return fmt.Errorf("%w: payload length %d", ErrInvalidMessage, m.PayloadLength)
+	}
+	m.Payload = append([]byte(nil), data[start:start+int(m.PayloadLength)]...)
+
+	// acknowledge and some control messages don't fill a digest.
+	if m.PayloadLength > 0 && !bytes.Equal(m.PayloadDigest, make([]byte, payloadDigestLength)) {
+		digest := sha256.Sum256(m.Payload)
+		if !bytes.Equal(digest[:], m.PayloadDigest) {
+			return fmt.Errorf("%w: payload digest mismatch", ErrInvalidMessage)
+		}
+	}
+	return nil
+}
+
+// putUUID writes uuid as session manager does, least significant bits come first.
+func putUUID(dst []byte, id uuid.UUID) {
+	copy(dst[0:8], id[8:16])
+	copy(dst[8:16], id[0:8])
+}
+
+// getUUID reads uuid which is written by putUUID.
+func getUUID(src []byte) uuid.UUID {
+	var id uuid.UUID
+	copy(id[8:16], src[0:8])
+	copy(id[0:8], src[8:16])
+	return id
+}
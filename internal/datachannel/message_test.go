@@ -0,0 +1,90 @@
+package datachannel
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage_MarshalBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		input *Message
+		isErr bool
+	}{
+		"success": {
+			input: newMessage(InputStreamMessage, 7, flagData, Output, []byte("hello")),
+			isErr: false,
+		},
+		"empty payload": {
+			input: newMessage(AcknowledgeMessage, 0, flagAck, 0, nil),
+			isErr: false,
+		},
+		"fail": {
+			input: newMessage("too_long_message_type_for_session_manager", 0, flagData, Output, nil),
+			isErr: true,
+		},
+	}
+
+	for _, t := range tests {
+		data, err := t.input.MarshalBinary()
+		assert.Equal(t.isErr, err != nil)
+		if err != nil {
+			continue
+		}
+		assert.Equal(payloadOffset+len(t.input.Payload), len(data))
+
+		msg := &Message{}
+		assert.NoError(msg.UnmarshalBinary(data))
+		assert.Equal(uint32(payloadLengthOffset), msg.HeaderLength)
+		assert.Equal(t.input.MessageType, msg.MessageType)
+		assert.Equal(t.input.SequenceNumber, msg.SequenceNumber)
+		assert.Equal(t.input.Flags, msg.Flags)
+		assert.Equal(t.input.MessageId, msg.MessageId)
+		assert.Equal(t.input.PayloadType, msg.PayloadType)
+		assert.Equal(len(t.input.Payload), len(msg.Payload))
+	}
+}
+
+func TestMessage_UnmarshalBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	valid, err := newMessage(OutputStreamMessage, 1, flagData, Output, []byte("world")).MarshalBinary()
+	assert.NoError(err)
+
+	corrupted := append([]byte(nil), valid...)
+	corrupted[len(corrupted)-1] = 'x'
+
+	truncated := valid[:len(valid)-2]
+
+	tests := map[string]struct {
+		input []byte
+		isErr bool
+	}{
+		"success":   {input: valid, isErr: false},
+		"short":     {input: []byte("short"), isErr: true},
+		"digest":    {input: corrupted, isErr: true},
+		"truncated": {input: truncated, isErr: true},
+	}
+
+	for _, t := range tests {
+		err := (&Message{}).UnmarshalBinary(t.input)
+		assert.Equal(t.isErr, err != nil)
+		if err != nil {
+			assert.True(errors.Is(err, ErrInvalidMessage))
+		}
+	}
+}
+
+func TestUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	id := uuid.MustParse("00112233-4455-6677-8899-aabbccddeeff")
+	buf := make([]byte, 16)
+	putUUID(buf, id)
+	assert.Equal([]byte{0x88, 0x99, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77}, buf)
+	assert.Equal(id, getUUID(buf))
+}
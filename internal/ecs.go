@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecs"
+	ecs_types "github.com/aws/aws-sdk-go-v2/service/ecs/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+)
+
+const (
+	// ECSResourceType is resource type of targets which are containers of ECS task.
+	ECSResourceType = "ECSContainer"
+
+	// The maximum number of tasks specified on a single DescribeTasks call is 100.
+	maxDescribeTasks = 100
+)
+
+// ECSContainer is a container of running ECS task, which enables ECS Exec.
+type ECSContainer struct {
+	Cluster    string
+	Service    string
+	TaskId     string
+	Name       string
+	RuntimeId  string
+	LastStatus string
+	// AgentStatus is status of ExecuteCommandAgent in container.
+	AgentStatus string
+}
+
+// SessionTarget returns target of ssm session, such as ecs:<cluster>_<task>_<runtime-id>.
+func (c *ECSContainer) SessionTarget() string {
+	return fmt.Sprintf("ecs:%s_%s_%s", c.Cluster, c.TaskId, c.RuntimeId)
+}
+
+// Target returns container as target, so it can be used for port forwarding.
+func (c *ECSContainer) Target() *Target {
+	return &Target{
+		Name:         c.SessionTarget(),
+		InstanceName: c.Name,
+		ResourceType: ECSResourceType,
+		Tags:         map[string]string{},
+	}
+}
+
+// FindECSClusters returns names of all ECS clusters.
+func FindECSClusters(ctx context.Context, cfg aws.Config) ([]string, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	var (
+		clusters []string
+		token    *string
+	)
+	for {
+		output, err := client.ListClusters(ctx, &ecs.ListClustersInput{NextToken: token})
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range output.ClusterArns {
+			clusters = append(clusters, lastArnSegment(arn))
+		}
+
+		token = output.NextToken
+		if aws.ToString(token) == "" {
+			break
+		}
+	}
+	return clusters, nil
+}
+
+// FindECSServices returns names of services in ECS cluster.
+func FindECSServices(ctx context.Context, cfg aws.Config, cluster string) ([]string, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	var (
+		services []string
+		token    *string
+	)
+	for {
+		output, err := client.ListServices(ctx, &ecs.ListServicesInput{Cluster: aws.String(cluster), NextToken: token})
+		if err != nil {
+			return nil, err
+		}
+		for _, arn := range output.ServiceArns {
+			services = append(services, lastArnSegment(arn))
+		}
+
+		token = output.NextToken
+		if aws.ToString(token) == "" {
+			break
+		}
+	}
+	return services, nil
+}
+
+// FindECSContainers returns containers of running tasks which enable ECS Exec.
+// All of tasks in cluster are returned when service is empty.
+func FindECSContainers(ctx context.Context, cfg aws.Config, cluster, service string) ([]*ECSContainer, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	var (
+		taskArns []string
+		token    *string
+	)
+	for {
+		input := &ecs.ListTasksInput{
+			Cluster:       aws.String(cluster),
+			DesiredStatus: ecs_types.DesiredStatusRunning,
+			NextToken:     token,
+		}
+		if service != "" {
+			input.ServiceName = aws.String(service)
+		}
+		output, err := client.ListTasks(ctx, input)
+		if err != nil {
+			return nil, err
+		}
+		taskArns = append(taskArns, output.TaskArns...)
+
+		token = output.NextToken
+		if aws.ToString(token) == "" {
+			break
+		}
+	}
+
+	var containers []*ECSContainer
+	for len(taskArns) > 0 {
+		max := len(taskArns)
+		if max > maxDescribeTasks {
+			max = maxDescribeTasks
+		}
+		output, err := client.DescribeTasks(ctx, &ecs.DescribeTasksInput{
+			Cluster: aws.String(cluster),
+			Tasks:   taskArns[:max],
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, task := range output.Tasks {
+			if !task.EnableExecuteCommand {
+				continue
+			}
+			for _, c := range task.Containers {
+				if aws.ToString(c.RuntimeId) == "" {
+					continue
+				}
+				container := &ECSContainer{
+					Cluster:    cluster,
+					Service:    strings.TrimPrefix(aws.ToString(task.Group), "service:"),
+					TaskId:     lastArnSegment(aws.ToString(task.TaskArn)),
+					Name:       aws.ToString(c.Name),
+					RuntimeId:  aws.ToString(c.RuntimeId),
+					LastStatus: aws.ToString(c.LastStatus),
+				}
+				for _, agent := range c.ManagedAgents {
+					if agent.Name == ecs_types.ManagedAgentNameExecuteCommandAgent {
+						container.AgentStatus = aws.ToString(agent.LastStatus)
+					}
+				}
+				containers = append(containers, container)
+			}
+		}
+		taskArns = taskArns[max:]
+	}
+	return containers, nil
+}
+
+// AskECSContainer asks you which selects cluster, service, task and container in order.
+// Arguments which aren't empty are used without asking, and a step is skipped when it has only one option.
+func AskECSContainer(ctx context.Context, cfg aws.Config, cluster, service, task, container string) (*ECSContainer, error) {
+	if cluster == "" {
+		clusters, err := FindECSClusters(ctx, cfg)
+		if err != nil {
+			return nil, err
+		}
+		if len(clusters) == 0 {
+			return nil, fmt.Errorf("not found ecs clusters")
+		}
+		i, err := pickOne("Choose a cluster in ECS:", clusters)
+		if err != nil {
+			return nil, err
+		}
+		cluster = clusters[i]
+	}
+
+	if service == "" && task == "" {
+		services, err := FindECSServices(ctx, cfg, cluster)
+		if err != nil {
+			return nil, err
+		}
+		// tasks which don't belong to service, such as scheduled tasks, are listed by all tasks.
+		options := append([]string{"(all tasks)"}, services...)
+		i, err := pickOne("Choose a service in ECS:", options)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			service = services[i-1]
+		}
+	}
+
+	containers, err := FindECSContainers(ctx, cfg, cluster, service)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		tasks     []string
+		taskTable = map[string][]*ECSContainer{}
+	)
+	for _, c := range containers {
+		if (task != "" && c.TaskId != task) || (container != "" && c.Name != container) {
+			continue
+		}
+		if _, ok := taskTable[c.TaskId]; !ok {
+			tasks = append(tasks, c.TaskId)
+		}
+		taskTable[c.TaskId] = append(taskTable[c.TaskId], c)
+	}
+	if len(tasks) == 0 {
+		return nil, fmt.Errorf("not found running tasks which enable execute command")
+	}
+
+	rows := make([][]string, 0, len(tasks))
+	for _, id := range tasks {
+		var names []string
+		for _, c := range taskTable[id] {
+			names = append(names, c.Name)
+		}
+		rows = append(rows, []string{id, taskTable[id][0].Service, strings.Join(names, ",")})
+	}
+	i, err := pickOne("Choose a task in ECS: (task, service, containers)", alignRows(rows))
+	if err != nil {
+		return nil, err
+	}
+	candidates := taskTable[tasks[i]]
+
+	rows = rows[:0]
+	for _, c := range candidates {
+		rows = append(rows, []string{c.Name, c.LastStatus, c.AgentStatus})
+	}
+	i, err = pickOne("Choose a container in ECS: (container, status, exec agent)", alignRows(rows))
+	if err != nil {
+		return nil, err
+	}
+	return candidates[i], nil
+}
+
+// ExecuteECSCommand executes command in container using ECS Exec, and returns its session which is the same as ssm.
+func ExecuteECSCommand(ctx context.Context, cfg aws.Config, container *ECSContainer, command string) (*ssm.StartSessionOutput, error) {
+	client := ecs.NewFromConfig(cfg)
+
+	output, err := client.ExecuteCommand(ctx, &ecs.ExecuteCommandInput{
+		Cluster:     aws.String(container.Cluster),
+		Task:        aws.String(container.TaskId),
+		Container:   aws.String(container.Name),
+		Command:     aws.String(command),
+		Interactive: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if output.Session == nil {
+		return nil, WrapError(fmt.Errorf("[err] not found session of execute command"))
+	}
+
+	return &ssm.StartSessionOutput{
+		SessionId:  output.Session.SessionId,
+		StreamUrl:  output.Session.StreamUrl,
+		TokenValue: output.Session.TokenValue,
+	}, nil
+}
+
+// pickOne asks you which selects an option, and returns its index without asking if there is only one option.
+func pickOne(message string, options []string) (int, error) {
+	if len(options) == 1 {
+		return 0, nil
+	}
+
+	items := make([]PickerItem, 0, len(options))
+	for _, o := range options {
+		items = append(items, PickerItem{Label: o})
+	}
+	indexes, err := NewPicker(message, items, false).Run("")
+	if err != nil {
+		return 0, err
+	}
+	return indexes[0], nil
+}
+
+// lastArnSegment returns name or id at the end of arn, ex) arn:aws:ecs:us-east-1:1:task/cluster/id -> id
+func lastArnSegment(arn string) string {
+	return arn[strings.LastIndex(arn, "/")+1:]
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockECSServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		input := map[string]interface{}{}
+		json.Unmarshal(body, &input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonEC2ContainerServiceV20141113.ListClusters":
+			if input["nextToken"] == nil {
+				w.Write([]byte(`{"clusterArns":["arn:aws:ecs:us-east-1:1:cluster/prod"],"nextToken":"next"}`))
+			} else {
+				w.Write([]byte(`{"clusterArns":["arn:aws:ecs:us-east-1:1:cluster/dev"]}`))
+			}
+		case "AmazonEC2ContainerServiceV20141113.ListTasks":
+			w.Write([]byte(`{"taskArns":["arn:aws:ecs:us-east-1:1:task/prod/t1","arn:aws:ecs:us-east-1:1:task/prod/t2"]}`))
+		case "AmazonEC2ContainerServiceV20141113.DescribeTasks":
+			w.Write([]byte(`{"tasks":[
+				{"taskArn":"arn:aws:ecs:us-east-1:1:task/prod/t1","group":"service:api","enableExecuteCommand":true,
+				 "containers":[{"name":"app","runtimeId":"t1-123","lastStatus":"RUNNING",
+				 "managedAgents":[{"name":"ExecuteCommandAgent","lastStatus":"RUNNING"}]},
+				 {"name":"init","lastStatus":"STOPPED"}]},
+				{"taskArn":"arn:aws:ecs:us-east-1:1:task/prod/t2","group":"service:api","enableExecuteCommand":false,
+				 "containers":[{"name":"app","runtimeId":"t2-123","lastStatus":"RUNNING"}]}]}`))
+		case "AmazonEC2ContainerServiceV20141113.ExecuteCommand":
+			if input["container"] != "app" || input["task"] != "t1" || input["interactive"] != true {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidParameterException","message":"invalid"}`))
+				return
+			}
+			w.Write([]byte(`{"session":{"sessionId":"ecs-execute-command-1","streamUrl":"wss://example","tokenValue":"token"}}`))
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+}
+
+func TestFindECSClusters(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockECSServer()
+	defer server.Close()
+
+	clusters, err := FindECSClusters(context.Background(), newMockConfig(server.URL))
+	assert.NoError(err)
+	assert.Equal([]string{"prod", "dev"}, clusters)
+}
+
+func TestFindECSContainers(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockECSServer()
+	defer server.Close()
+
+	containers, err := FindECSContainers(context.Background(), newMockConfig(server.URL), "prod", "api")
+	assert.NoError(err)
+	assert.Len(containers, 1)
+	assert.Equal(&ECSContainer{Cluster: "prod", Service: "api", TaskId: "t1", Name: "app", RuntimeId: "t1-123",
+		LastStatus: "RUNNING", AgentStatus: "RUNNING"}, containers[0])
+	assert.Equal("ecs:prod_t1_t1-123", containers[0].SessionTarget())
+	assert.Equal(ECSResourceType, containers[0].Target().ResourceType)
+}
+
+func TestExecuteECSCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockECSServer()
+	defer server.Close()
+
+	tests := map[string]struct {
+		container *ECSContainer
+		isErr     bool
+	}{
+		"success": {container: &ECSContainer{Cluster: "prod", TaskId: "t1", Name: "app"}, isErr: false},
+		"fail":    {container: &ECSContainer{Cluster: "prod", TaskId: "t1", Name: "sidecar"}, isErr: true},
+	}
+
+	for _, t := range tests {
+		session, err := ExecuteECSCommand(context.Background(), newMockConfig(server.URL), t.container, "/bin/sh")
+		assert.Equal(t.isErr, err != nil)
+		if err == nil {
+			assert.Equal("ecs-execute-command-1", aws.ToString(session.SessionId))
+			assert.Equal("wss://example", aws.ToString(session.StreamUrl))
+			assert.Equal("token", aws.ToString(session.TokenValue))
+		}
+	}
+}
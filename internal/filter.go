@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+var (
+	// ec2FilterNames maps selector keys to filter names of DescribeInstances.
+	ec2FilterNames = map[string]string{
+		"name":          "tag:Name",
+		"tag-key":       "tag-key",
+		"vpc":           "vpc-id",
+		"subnet":        "subnet-id",
+		"az":            "availability-zone",
+		"instance-type": "instance-type",
+	}
+
+	// ssmFilterKeys maps selector keys to filter keys of DescribeInstanceInformation.
+	ssmFilterKeys = map[string]string{
+		"platform":      "PlatformTypes",
+		"agent-version": "AgentVersion",
+		"ping-status":   "PingStatus",
+		"resource-type": "ResourceType",
+	}
+
+	platformTypes = map[string]string{
+		"linux":   string(ssm_types.PlatformTypeLinux),
+		"windows": string(ssm_types.PlatformTypeWindows),
+		"macos":   string(ssm_types.PlatformTypeMacos),
+	}
+)
+
+// TargetFilter narrows targets, it is pushed down into filters of DescribeInstances and DescribeInstanceInformation.
+type TargetFilter struct {
+	EC2 []ec2_types.Filter
+	SSM []ssm_types.InstanceInformationStringFilter
+}
+
+// ParseTargetFilter parses selectors, such as tag:Env=prod, name=api-*, platform=linux, vpc=vpc-1, subnet=subnet-1,
+// az=us-east-1a, instance-type=t3.micro, agent-version=3.1.0.0, ping-status=Online and resource-type=ManagedInstance.
+// Multiple values are separated by comma, and tagKeys are instances which have those tag keys.
+func ParseTargetFilter(selectors []string, tagKeys []string) (*TargetFilter, error) {
+	filter := &TargetFilter{}
+
+	for _, selector := range selectors {
+		selector = strings.TrimSpace(selector)
+		if selector == "" {
+			continue
+		}
+
+		seps := strings.SplitN(selector, "=", 2)
+		key := strings.TrimSpace(seps[0])
+		if len(seps) != 2 || key == "" || strings.TrimSpace(seps[1]) == "" {
+			return nil, fmt.Errorf("[err] invalid filter %s, ex) tag:Env=prod", selector)
+		}
+
+		var values []string
+		for _, v := range strings.Split(seps[1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(key, "tag:"):
+			filter.EC2 = append(filter.EC2, ec2_types.Filter{Name: aws.String(key), Values: values})
+		case ec2FilterNames[strings.ToLower(key)] != "":
+			filter.EC2 = append(filter.EC2, ec2_types.Filter{Name: aws.String(ec2FilterNames[strings.ToLower(key)]), Values: values})
+		case ssmFilterKeys[strings.ToLower(key)] != "":
+			if strings.ToLower(key) == "platform" {
+				for i, v := range values {
+					platform, ok := platformTypes[strings.ToLower(v)]
+					if !ok {
+						return nil, fmt.Errorf("[err] invalid platform %s, (linux, windows, macos)", v)
+					}
+					values[i] = platform
+				}
+			}
+			filter.SSM = append(filter.SSM, ssm_types.InstanceInformationStringFilter{
+				Key: aws.String(ssmFilterKeys[strings.ToLower(key)]), Values: values})
+		default:
+			return nil, fmt.Errorf("[err] unknown filter key %s", key)
+		}
+	}
+
+	var keys []string
+	for _, k := range tagKeys {
+		if k = strings.TrimSpace(k); k != "" {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) > 0 {
+		filter.EC2 = append(filter.EC2, ec2_types.Filter{Name: aws.String("tag-key"), Values: keys})
+	}
+
+	return filter, nil
+}
+
+// ec2Filters returns filters of DescribeInstances.
+func (f *TargetFilter) ec2Filters() []ec2_types.Filter {
+	if f == nil {
+		return nil
+	}
+	return f.EC2
+}
+
+// cacheKey returns a key of filter for caching instances, which is empty if there are no filters.
+func (f *TargetFilter) cacheKey() string {
+	if f == nil || (len(f.EC2) == 0 && len(f.SSM) == 0) {
+		return ""
+	}
+	buf, _ := json.Marshal(f)
+	return string(buf)
+}
+
+// ssmFilters returns filters of DescribeInstanceInformation.
+func (f *TargetFilter) ssmFilters() []ssm_types.InstanceInformationStringFilter {
+	if f == nil {
+		return nil
+	}
+	return f.SSM
+}
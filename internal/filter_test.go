@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ec2_types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTargetFilter(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		selectors []string
+		tagKeys   []string
+		output    *TargetFilter
+		isErr     bool
+	}{
+		"empty": {output: &TargetFilter{}},
+		"ec2": {
+			selectors: []string{"tag:Env=prod,dev", "name=api-*", "vpc=vpc-1", "az=us-east-1a"},
+			output: &TargetFilter{EC2: []ec2_types.Filter{
+				{Name: aws.String("tag:Env"), Values: []string{"prod", "dev"}},
+				{Name: aws.String("tag:Name"), Values: []string{"api-*"}},
+				{Name: aws.String("vpc-id"), Values: []string{"vpc-1"}},
+				{Name: aws.String("availability-zone"), Values: []string{"us-east-1a"}},
+			}},
+		},
+		"ssm": {
+			selectors: []string{"platform=linux,Windows", "ping-status=Online"},
+			output: &TargetFilter{SSM: []ssm_types.InstanceInformationStringFilter{
+				{Key: aws.String("PlatformTypes"), Values: []string{"Linux", "Windows"}},
+				{Key: aws.String("PingStatus"), Values: []string{"Online"}},
+			}},
+		},
+		"tag-key": {
+			tagKeys: []string{"Env", " ", "Team"},
+			output: &TargetFilter{EC2: []ec2_types.Filter{
+				{Name: aws.String("tag-key"), Values: []string{"Env", "Team"}},
+			}},
+		},
+		"invalid":          {selectors: []string{"tag:Env"}, isErr: true},
+		"empty value":      {selectors: []string{"name="}, isErr: true},
+		"unknown key":      {selectors: []string{"owner=me"}, isErr: true},
+		"unknown platform": {selectors: []string{"platform=bsd"}, isErr: true},
+	}
+
+	for _, t := range tests {
+		filter, err := ParseTargetFilter(t.selectors, t.tagKeys)
+		assert.Equal(t.isErr, err != nil)
+		if err == nil {
+			assert.Equal(t.output, filter)
+		}
+	}
+
+	var nilFilter *TargetFilter
+	assert.Nil(nilFilter.ec2Filters())
+	assert.Nil(nilFilter.ssmFilters())
+}
@@ -0,0 +1,204 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+const (
+	// commandSkippedStatus is status of instance which command isn't sent to, because errors exceed max errors.
+	commandSkippedStatus = "Skipped"
+)
+
+// ParseCommandTargets parses targets of SendCommand, such as tag:Role=web, tag-key=Role and resource-groups:Name=web.
+// Multiple values are separated by comma.
+func ParseCommandTargets(specs []string) ([]ssm_types.Target, error) {
+	var targets []ssm_types.Target
+	for _, spec := range specs {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		seps := strings.SplitN(spec, "=", 2)
+		key := strings.TrimSpace(seps[0])
+		if len(seps) != 2 || key == "" || strings.TrimSpace(seps[1]) == "" {
+			return nil, fmt.Errorf("[err] invalid targets %s, ex) tag:Role=web", spec)
+		}
+		var values []string
+		for _, v := range strings.Split(seps[1], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+		targets = append(targets, ssm_types.Target{Key: aws.String(key), Values: values})
+	}
+	return targets, nil
+}
+
+// ParseCommandLimit parses a number or percentage of total like MaxConcurrency and MaxErrors of SSM, such as 10 or 10%.
+// Percentage is rounded up, and it returns -1 if limit is empty.
+func ParseCommandLimit(limit string, total int) (int, error) {
+	limit = strings.TrimSpace(limit)
+	if limit == "" {
+		return -1, nil
+	}
+
+	percent := strings.HasSuffix(limit, "%")
+	n, err := strconv.Atoi(strings.TrimSuffix(limit, "%"))
+	if err != nil || n < 0 || (percent && n > 100) {
+		return 0, fmt.Errorf("[err] invalid limit %s, ex) 10 or 10%%", limit)
+	}
+	if percent {
+		return int(math.Ceil(float64(total) * float64(n) / 100)), nil
+	}
+	return n, nil
+}
+
+// RunCommandInWindow sends command to each of targets, and keeps at most maxConcurrency instances in flight.
+// Next instance is sent as soon as one is over, and configOf returns config of target because targets can be in different profiles and regions.
+// It stops sending when failed instances exceed maxErrors, and the rest are skipped. maxErrors is unlimited if it is negative.
+// If maxConcurrency isn't positive, every instance is in flight at once, or maxErrors+1 instances so that maxErrors can stop sending.
+// onResult is called per instance as soon as it is over, and results are returned in the order of targets.
+func RunCommandInWindow(ctx context.Context, targets []*Target, configOf func(*Target) aws.Config, input *CommandInput,
+	maxConcurrency, maxErrors int, onResult func(*CommandResult)) []*CommandResult {
+	if maxConcurrency <= 0 {
+		maxConcurrency = len(targets)
+		if maxErrors >= 0 && maxErrors+1 < maxConcurrency {
+			maxConcurrency = maxErrors + 1
+		}
+	}
+
+	var (
+		results  = make([]*CommandResult, len(targets))
+		over     = make(chan int)
+		next     int
+		inflight int
+		errs     int
+	)
+	for {
+		for next < len(targets) && inflight < maxConcurrency && (maxErrors < 0 || errs <= maxErrors) {
+			go func(i int) {
+				results[i] = sendCommandTo(ctx, configOf(targets[i]), targets[i], input)
+				over <- i
+			}(next)
+			next++
+			inflight++
+		}
+		if inflight == 0 {
+			break
+		}
+
+		// failures are counted as soon as each instance is over, so the rest aren't sent after they exceed max errors.
+		r := results[<-over]
+		inflight--
+		if !r.Succeeded() {
+			errs++
+		}
+		if onResult != nil {
+			onResult(r)
+		}
+	}
+
+	for i, t := range targets[next:] {
+		r := &CommandResult{InstanceId: t.Name, InstanceName: t.InstanceName, Status: commandSkippedStatus, ExitCode: -1}
+		results[next+i] = r
+		if onResult != nil {
+			onResult(r)
+		}
+	}
+	return results
+}
+
+// sendCommandTo sends command to an instance, and waits until it is over.
+// Instance which command couldn't be sent to has error status.
+func sendCommandTo(ctx context.Context, cfg aws.Config, target *Target, input *CommandInput) *CommandResult {
+	output, err := SendCommand(ctx, cfg, []*Target{target}, input)
+	if err != nil {
+		return &CommandResult{InstanceId: target.Name, InstanceName: target.InstanceName, Status: commandErrorStatus, ExitCode: -1, Stderr: err.Error()}
+	}
+	r := waitCommandInvocation(ctx, cfg, ssm.NewFromConfig(cfg), aws.ToString(output.Command.CommandId), target.Name)
+	r.InstanceName = target.InstanceName
+	return r
+}
+
+// RunCommandOnTargets sends command to Targets of input such as tags, so SSM controls rate with MaxConcurrency and MaxErrors.
+// Instances are found by SSM, and onResult is called per instance as soon as it is over until command is over.
+func RunCommandOnTargets(ctx context.Context, cfg aws.Config, input *CommandInput, onResult func(*CommandResult)) ([]*CommandResult, error) {
+	output, err := SendCommand(ctx, cfg, nil, input)
+	if err != nil {
+		return nil, err
+	}
+	commandId := aws.ToString(output.Command.CommandId)
+
+	client := ssm.NewFromConfig(cfg)
+	var (
+		results []*CommandResult
+		seen    = map[string]bool{}
+	)
+	for {
+		// status of command is fetched before invocations, so invocations which are over before command are all found.
+		commands, err := client.ListCommands(ctx, &ssm.ListCommandsInput{CommandId: aws.String(commandId)})
+		if err != nil {
+			return results, err
+		}
+		commandOver := len(commands.Commands) > 0 && isCommandOver(commands.Commands[0].Status)
+
+		paginator := ssm.NewListCommandInvocationsPaginator(client, &ssm.ListCommandInvocationsInput{CommandId: aws.String(commandId)})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return results, err
+			}
+			for _, invocation := range page.CommandInvocations {
+				id := aws.ToString(invocation.InstanceId)
+				if seen[id] || !isInvocationOver(invocation.Status) {
+					continue
+				}
+				seen[id] = true
+				r := waitCommandInvocation(ctx, cfg, client, commandId, id)
+				r.InstanceName = aws.ToString(invocation.InstanceName)
+				results = append(results, r)
+				if onResult != nil {
+					onResult(r)
+				}
+			}
+		}
+		if commandOver {
+			return results, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return results, ctx.Err()
+		case <-time.After(commandPollInterval):
+		}
+	}
+}
+
+// isCommandOver returns whether command is over on every instance.
+func isCommandOver(status ssm_types.CommandStatus) bool {
+	switch status {
+	case ssm_types.CommandStatusPending, ssm_types.CommandStatusInProgress, ssm_types.CommandStatusCancelling:
+		return false
+	}
+	return true
+}
+
+// isInvocationOver returns whether command is over on an instance.
+func isInvocationOver(status ssm_types.CommandInvocationStatus) bool {
+	switch status {
+	case ssm_types.CommandInvocationStatusPending, ssm_types.CommandInvocationStatusInProgress,
+		ssm_types.CommandInvocationStatusDelayed, ssm_types.CommandInvocationStatusCancelling:
+		return false
+	}
+	return true
+}
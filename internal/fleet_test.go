@@ -0,0 +1,205 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCommandTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	targets, err := ParseCommandTargets([]string{"tag:Role=web", "tag:Env=prod, stage", " "})
+	assert.NoError(err)
+	assert.Equal([]ssm_types.Target{
+		{Key: aws.String("tag:Role"), Values: []string{"web"}},
+		{Key: aws.String("tag:Env"), Values: []string{"prod", "stage"}},
+	}, targets)
+
+	for _, spec := range []string{"tag:Role", "=web", "tag:Role="} {
+		_, err := ParseCommandTargets([]string{spec})
+		assert.Error(err, spec)
+	}
+}
+
+func TestParseCommandLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		limit string
+		total int
+		n     int
+		isErr bool
+	}{
+		"empty":      {limit: "", total: 10, n: -1},
+		"number":     {limit: "3", total: 10, n: 3},
+		"percentage": {limit: "10%", total: 200, n: 20},
+		"round up":   {limit: "10%", total: 15, n: 2},
+		"zero":       {limit: "0", total: 10, n: 0},
+		"negative":   {limit: "-1", total: 10, isErr: true},
+		"over 100%":  {limit: "101%", total: 10, isErr: true},
+		"invalid":    {limit: "a", total: 10, isErr: true},
+	}
+	for name, tt := range tests {
+		n, err := ParseCommandLimit(tt.limit, tt.total)
+		assert.Equal(tt.isErr, err != nil, name)
+		if !tt.isErr {
+			assert.Equal(tt.n, n, name)
+		}
+	}
+}
+
+func TestRunCommandInWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(interval time.Duration) { commandPollInterval = interval }(commandPollInterval)
+	commandPollInterval = 10 * time.Millisecond
+
+	var (
+		mu          sync.Mutex
+		sent        []interface{}
+		polls       = map[interface{}]int{}
+		inflight    int
+		maxInflight int
+		failed      interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.SendCommand":
+			assert.Nil(input["MaxConcurrency"])
+			ids := input["InstanceIds"].([]interface{})
+			assert.Len(ids, 1)
+			sent = append(sent, ids[0])
+			if inflight++; inflight > maxInflight {
+				maxInflight = inflight
+			}
+			fmt.Fprintf(w, `{"Command":{"CommandId":"c-%d"}}`, len(sent))
+		case "AmazonSSM.GetCommandInvocation":
+			// every instance is in progress at first poll, so instances in flight overlap.
+			id := input["InstanceId"]
+			switch polls[id]++; {
+			case polls[id] == 1:
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"InProgress"}`, id)
+			case id == failed:
+				inflight--
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Failed","ResponseCode":1}`, id)
+			default:
+				inflight--
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Success","ResponseCode":0}`, id)
+			}
+		}
+	}))
+	defer server.Close()
+
+	cfg := newMockConfig(server.URL)
+	configOf := func(*Target) aws.Config { return cfg }
+	targets := []*Target{{Name: "i-1"}, {Name: "i-2"}, {Name: "i-3"}, {Name: "i-4"}, {Name: "i-5"}}
+	input := &CommandInput{DocumentName: DefaultCommandDocument, Commands: []string{"uptime"}}
+	reset := func(id interface{}) {
+		sent, polls, inflight, maxInflight, failed = nil, map[interface{}]int{}, 0, 0, id
+	}
+	statuses := func(results []*CommandResult) []string {
+		var statuses []string
+		for _, r := range results {
+			statuses = append(statuses, r.Status)
+		}
+		return statuses
+	}
+
+	// max errors alone sends to max errors + 1 instances at a time, so the rest are skipped after the second fails.
+	reset("i-2")
+	var over []string
+	results := RunCommandInWindow(context.Background(), targets, configOf, input, 0, 0, func(r *CommandResult) { over = append(over, r.InstanceId) })
+	assert.Equal([]interface{}{"i-1", "i-2"}, sent)
+	assert.Equal([]string{"Success", "Failed", "Skipped", "Skipped", "Skipped"}, statuses(results))
+	assert.Equal([]string{"i-1", "i-2", "i-3", "i-4", "i-5"}, over)
+
+	// next instance is sent as soon as one is over, and at most max concurrency instances are in flight.
+	reset(nil)
+	results = RunCommandInWindow(context.Background(), targets, configOf, input, 2, -1, nil)
+	assert.Len(sent, 5)
+	assert.Equal(2, maxInflight)
+	assert.Equal([]string{"Success", "Success", "Success", "Success", "Success"}, statuses(results))
+
+	// every instance is in flight at once without limits.
+	reset(nil)
+	results = RunCommandInWindow(context.Background(), targets, configOf, input, 0, -1, nil)
+	assert.Len(sent, 5)
+	assert.Equal(5, maxInflight)
+	assert.Len(results, 5)
+}
+
+func TestRunCommandOnTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(interval time.Duration) { commandPollInterval = interval }(commandPollInterval)
+	commandPollInterval = 10 * time.Millisecond
+
+	var (
+		mu    sync.Mutex
+		polls int
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.SendCommand":
+			assert.Nil(input["InstanceIds"])
+			assert.Equal([]interface{}{map[string]interface{}{"Key": "tag:Role", "Values": []interface{}{"web"}}}, input["Targets"])
+			assert.Equal("10%", input["MaxConcurrency"])
+			assert.Equal("1", input["MaxErrors"])
+			w.Write([]byte(`{"Command":{"CommandId":"c-1"}}`))
+		case "AmazonSSM.ListCommands":
+			polls++
+			if polls < 3 {
+				w.Write([]byte(`{"Commands":[{"CommandId":"c-1","Status":"InProgress"}]}`))
+			} else {
+				w.Write([]byte(`{"Commands":[{"CommandId":"c-1","Status":"Success"}]}`))
+			}
+		case "AmazonSSM.ListCommandInvocations":
+			// instances are found by SSM one by one.
+			if polls < 2 {
+				w.Write([]byte(`{"CommandInvocations":[{"InstanceId":"i-1","InstanceName":"web-1","Status":"Success"},{"InstanceId":"i-2","Status":"InProgress"}]}`))
+			} else {
+				w.Write([]byte(`{"CommandInvocations":[{"InstanceId":"i-1","InstanceName":"web-1","Status":"Success"},{"InstanceId":"i-2","InstanceName":"web-2","Status":"Success"}]}`))
+			}
+		case "AmazonSSM.GetCommandInvocation":
+			fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Success","ResponseCode":0,"StandardOutputContent":"ok"}`, input["InstanceId"])
+		}
+	}))
+	defer server.Close()
+
+	targets, err := ParseCommandTargets([]string{"tag:Role=web"})
+	assert.NoError(err)
+	input := &CommandInput{DocumentName: DefaultCommandDocument, Commands: []string{"uptime"}, Targets: targets, MaxConcurrency: "10%", MaxErrors: "1"}
+
+	var printed []string
+	results, err := RunCommandOnTargets(context.Background(), newMockConfig(server.URL), input, func(r *CommandResult) {
+		printed = append(printed, r.InstanceId)
+	})
+	assert.NoError(err)
+	assert.Equal([]string{"i-1", "i-2"}, printed)
+	assert.Len(results, 2)
+	assert.Equal("web-2", results[1].InstanceName)
+	assert.Equal("ok", results[1].Stdout)
+	assert.True(results[1].Succeeded())
+}
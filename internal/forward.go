@@ -0,0 +1,321 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+)
+
+const (
+	portForwardingDocument         = "AWS-StartPortForwardingSession"
+	portForwardingToRemoteDocument = "AWS-StartPortForwardingSessionToRemoteHost"
+	forwardStatusOpen              = "open"
+	forwardStatusClosed            = "closed"
+	forwardStatusFailed            = "failed"
+	forwardStatusReconnecting      = "reconnecting"
+
+	// backoff of reconnecting starts at min, and it is doubled up to max.
+	forwardMinBackoff = time.Second
+	forwardMaxBackoff = time.Minute
+	// keep-alive detects a lost session in about 2 intervals.
+	forwardKeepAlivePingInterval = 30 * time.Second
+	forwardLogTimeFormat         = "2006-01-02 15:04:05"
+)
+
+type (
+	// Forward is a port forwarding through target, it forwards to target itself when Host is empty.
+	Forward struct {
+		LocalPort  string
+		Host       string
+		RemotePort string
+	}
+
+	// ForwardSession is forwards through target, which is written as output when every forward is opened or failed.
+	ForwardSession struct {
+		Target   string           `json:"target"`
+		Region   string           `json:"region"`
+		Forwards []*ForwardStatus `json:"forwards"`
+	}
+
+	// ForwardStatus is status of a forward, such as open, failed and closed.
+	ForwardStatus struct {
+		Local     string `json:"local"`
+		Remote    string `json:"remote"`
+		SessionId string `json:"sessionId"`
+		Status    string `json:"status"`
+		Error     string `json:"error,omitempty"`
+	}
+
+	// forwardState is status of a forward which is supervised by forwardSupervisor.
+	forwardState struct {
+		forward   *Forward
+		sessionId string
+		// status is empty until forward is opened or failed.
+		status string
+		err    error
+	}
+)
+
+// ParseForward parses a forward like -L of ssh, such as local:host:remote, local:remote or remote.
+// Local port is the same as remote port if it is omitted.
+func ParseForward(spec string) (*Forward, error) {
+	fields := strings.Split(strings.TrimSpace(spec), ":")
+	f := &Forward{}
+	switch len(fields) {
+	case 1:
+		f.RemotePort, f.LocalPort = fields[0], fields[0]
+	case 2:
+		f.LocalPort, f.RemotePort = fields[0], fields[1]
+	case 3:
+		f.LocalPort, f.Host, f.RemotePort = fields[0], fields[1], fields[2]
+	default:
+		return nil, fmt.Errorf("[err] invalid forward %s, ex) 15432:db.internal:5432", spec)
+	}
+
+	for _, port := range []string{f.LocalPort, f.RemotePort} {
+		if n, err := strconv.Atoi(port); err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("[err] invalid port %s of forward %s", port, spec)
+		}
+	}
+	if f.Host == "" && len(fields) == 3 {
+		return nil, fmt.Errorf("[err] invalid host of forward %s", spec)
+	}
+	return f, nil
+}
+
+// String returns forward, such as 15432 -> db.internal:5432.
+func (f *Forward) String() string {
+	host := f.Host
+	if host == "" {
+		host = "target"
+	}
+	return fmt.Sprintf("%s -> %s:%s", f.LocalPort, host, f.RemotePort)
+}
+
+// StartSessionInput returns input which starts a session of port forwarding to target.
+func (f *Forward) StartSessionInput(target string) *ssm.StartSessionInput {
+	input := &ssm.StartSessionInput{
+		DocumentName: aws.String(portForwardingDocument),
+		Parameters: map[string][]string{
+			"portNumber": {f.RemotePort},
+		},
+		Target: aws.String(target),
+	}
+	// local port is omitted when session is relayed as a stream, such as socks.
+	if f.LocalPort != "" {
+		input.Parameters["localPortNumber"] = []string{f.LocalPort}
+	}
+	if f.Host != "" {
+		input.DocumentName = aws.String(portForwardingToRemoteDocument)
+		input.Parameters["host"] = []string{f.Host}
+	}
+	return input
+}
+
+// StartForwards opens forwards through target concurrently, and supervises them until ctx is done or every forward is closed.
+// Forwards are written to out after all of them are opened or failed, and each change after that is printed to w.
+// Every session is deleted when it is over.
+// If keepAlive is true, a lost session is started again with exponential backoff, and its local port is kept.
+func StartForwards(ctx context.Context, cfg aws.Config, target string, forwards []*Forward, keepAlive bool, out *Output, w io.Writer) error {
+	if len(forwards) == 0 {
+		return fmt.Errorf("[err] not found forwards")
+	}
+
+	s := &forwardSupervisor{out: out, w: w, target: target, region: cfg.Region, pending: len(forwards), keepAlive: keepAlive}
+	for _, f := range forwards {
+		s.states = append(s.states, &forwardState{forward: f})
+	}
+
+	var wg sync.WaitGroup
+	for _, state := range s.states {
+		wg.Add(1)
+		go func(state *forwardState) {
+			defer wg.Done()
+			s.start(ctx, cfg, target, state)
+		}(state)
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, state := range s.states {
+		if state.status == forwardStatusFailed {
+			failed = append(failed, state.forward.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("[err] failed to forward %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// forwardSupervisor writes forwards when every forward is settled, and prints each change after that.
+type forwardSupervisor struct {
+	mu        sync.Mutex
+	out       *Output
+	w         io.Writer
+	target    string
+	region    string
+	states    []*forwardState
+	pending   int
+	keepAlive bool
+}
+
+// start listens local port and then starts a session, so session isn't created when local port is in use.
+// Listener is kept while a lost session is started again.
+func (s *forwardSupervisor) start(ctx context.Context, cfg aws.Config, target string, state *forwardState) {
+	listener, err := ListenPort(state.forward.LocalPort)
+	if err != nil {
+		s.set(state, forwardStatusFailed, "", err)
+		return
+	}
+	defer listener.Close()
+	if s.keepAlive {
+		listener.PingInterval = forwardKeepAlivePingInterval
+	}
+
+	backoff := forwardMinBackoff
+	for opened := false; ; {
+		session, err := CreateStartSession(ctx, cfg, state.forward.StartSessionInput(target))
+		if err == nil {
+			opened = true
+			s.set(state, forwardStatusOpen, aws.ToString(session.SessionId), nil)
+
+			startedAt := time.Now()
+			err = listener.Serve(ctx, session)
+			// ctx may be canceled already, so session is deleted with a new context.
+			if derr := DeleteStartSession(context.Background(), cfg, &ssm.TerminateSessionInput{SessionId: session.SessionId}); derr != nil && err == nil {
+				err = derr
+			}
+
+			// a session which lasted long is lost by timeout or network, so backoff starts again.
+			if time.Since(startedAt) > forwardMaxBackoff {
+				backoff = forwardMinBackoff
+			}
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			s.set(state, forwardStatusClosed, "", nil)
+			return
+		// a forward which has never been opened isn't started again, such as invalid target or permission.
+		case !s.keepAlive || !opened:
+			if err != nil {
+				s.set(state, forwardStatusFailed, "", err)
+			} else {
+				s.set(state, forwardStatusClosed, "", nil)
+			}
+			return
+		}
+
+		s.update(state, forwardStatusReconnecting, "", err)
+		if err != nil {
+			s.logf(color.RedString, "[%s] %s in %s, %s", forwardStatusReconnecting, state.forward, backoff, err.Error())
+		} else {
+			s.logf(color.YellowString, "[%s] %s in %s, session is over", forwardStatusReconnecting, state.forward, backoff)
+		}
+		select {
+		case <-ctx.Done():
+			s.set(state, forwardStatusClosed, "", nil)
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > forwardMaxBackoff {
+			backoff = forwardMaxBackoff
+		}
+	}
+}
+
+// set changes status of forward, and prints the change after status table is printed.
+func (s *forwardSupervisor) set(state *forwardState, status, sessionId string, err error) {
+	if !s.update(state, status, sessionId, err) {
+		return
+	}
+	if err != nil {
+		s.logf(color.RedString, "[%s] %s %s, %s", status, state.forward, state.sessionId, err.Error())
+	} else {
+		s.logf(color.YellowString, "[%s] %s %s", status, state.forward, state.sessionId)
+	}
+}
+
+// update changes status of forward, session id is kept if it is empty.
+// It writes forwards when every forward is settled, and returns whether forward was settled already.
+func (s *forwardSupervisor) update(state *forwardState, status, sessionId string, err error) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	settled := state.status != ""
+	state.status, state.err = status, err
+	if sessionId != "" {
+		state.sessionId = sessionId
+	}
+	if !settled {
+		s.pending--
+		if s.pending == 0 {
+			s.write()
+		}
+	}
+	return settled
+}
+
+// logf prints a change of forward with time, because forwards are supervised for a long time.
+func (s *forwardSupervisor) logf(colorf func(string, ...interface{}) string, format string, args ...interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Fprintf(s.w, "%s %s\n", time.Now().Format(forwardLogTimeFormat), colorf(format, args...))
+}
+
+// write writes forwards to output, it should be called with lock.
+func (s *forwardSupervisor) write() {
+	session := &ForwardSession{Target: s.target, Region: s.region}
+	for _, state := range s.states {
+		status := &ForwardStatus{
+			Local:     "localhost:" + state.forward.LocalPort,
+			Remote:    "target:" + state.forward.RemotePort,
+			SessionId: state.sessionId,
+			Status:    state.status,
+		}
+		if state.forward.Host != "" {
+			status.Remote = net.JoinHostPort(state.forward.Host, state.forward.RemotePort)
+		}
+		if state.err != nil {
+			status.Error = state.err.Error()
+		}
+		session.Forwards = append(session.Forwards, status)
+	}
+
+	if err := s.out.Write(session, func(w io.Writer) { printForwardStatuses(w, session.Forwards) }); err != nil {
+		fmt.Fprintln(s.w, color.RedString(err.Error()))
+	}
+}
+
+// printForwardStatuses prints statuses of forwards as aligned columns.
+func printForwardStatuses(w io.Writer, statuses []*ForwardStatus) {
+	rows := [][]string{{"LOCAL", "REMOTE", "SESSION", "STATUS"}}
+	for _, s := range statuses {
+		status := s.Status
+		if s.Error != "" {
+			status += ", " + s.Error
+		}
+		rows = append(rows, []string{s.Local, s.Remote, s.SessionId, status})
+	}
+
+	for i, line := range alignRows(rows) {
+		switch {
+		case i == 0:
+			fmt.Fprintln(w, color.New(color.Bold).Sprint(line))
+		case statuses[i-1].Status == forwardStatusOpen:
+			fmt.Fprintln(w, color.GreenString(line))
+		default:
+			fmt.Fprintln(w, color.RedString(line))
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForward(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		spec    string
+		forward *Forward
+		isErr   bool
+	}{
+		"remote":      {spec: "8080", forward: &Forward{LocalPort: "8080", RemotePort: "8080"}},
+		"local":       {spec: "18080:8080", forward: &Forward{LocalPort: "18080", RemotePort: "8080"}},
+		"host":        {spec: "15432:db.internal:5432", forward: &Forward{LocalPort: "15432", Host: "db.internal", RemotePort: "5432"}},
+		"empty host":  {spec: "15432::5432", isErr: true},
+		"invalid":     {spec: "a:b", isErr: true},
+		"out of port": {spec: "70000", isErr: true},
+		"too many":    {spec: "1:a:2:3", isErr: true},
+	}
+
+	for _, t := range tests {
+		f, err := ParseForward(t.spec)
+		assert.Equal(t.isErr, err != nil)
+		assert.Equal(t.forward, f)
+	}
+}
+
+func TestForward_StartSessionInput(t *testing.T) {
+	assert := assert.New(t)
+
+	input := (&Forward{LocalPort: "18080", RemotePort: "8080"}).StartSessionInput("i-1")
+	assert.Equal("AWS-StartPortForwardingSession", aws.ToString(input.DocumentName))
+	assert.Equal("i-1", aws.ToString(input.Target))
+	assert.Equal([]string{"8080"}, input.Parameters["portNumber"])
+	assert.Equal([]string{"18080"}, input.Parameters["localPortNumber"])
+	assert.NotContains(input.Parameters, "host")
+
+	input = (&Forward{LocalPort: "15432", Host: "db.internal", RemotePort: "5432"}).StartSessionInput("i-1")
+	assert.Equal("AWS-StartPortForwardingSessionToRemoteHost", aws.ToString(input.DocumentName))
+	assert.Equal([]string{"db.internal"}, input.Parameters["host"])
+}
+
+func TestStartForwards(t *testing.T) {
+	assert := assert.New(t)
+
+	var sessions int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.StartSession" {
+			atomic.AddInt32(&sessions, 1)
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"InvalidDocument","message":"invalid document"}`))
+	}))
+	defer server.Close()
+
+	// a port in use fails before session is started.
+	used, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	defer used.Close()
+	_, usedPort, _ := net.SplitHostPort(used.Addr().String())
+
+	free, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	_, freePort, _ := net.SplitHostPort(free.Addr().String())
+	free.Close()
+
+	buf := &bytes.Buffer{}
+	out, err := NewOutput(OutputTable, buf)
+	assert.NoError(err)
+	err = StartForwards(context.Background(), newMockConfig(server.URL), "i-1", []*Forward{
+		{LocalPort: usedPort, RemotePort: "5432", Host: "db.internal"},
+		{LocalPort: freePort, RemotePort: "6379", Host: "redis.internal"},
+	}, false, out, buf)
+	assert.Error(err)
+	assert.Equal(int32(1), atomic.LoadInt32(&sessions))
+	assert.Contains(buf.String(), "LOCAL")
+	assert.Contains(buf.String(), "db.internal:5432")
+	assert.Contains(buf.String(), "redis.internal:6379")
+
+	// forwards are written as json for scripts.
+	buf.Reset()
+	out, err = NewOutput(OutputJSON, buf)
+	assert.NoError(err)
+	err = StartForwards(context.Background(), newMockConfig(server.URL), "i-1", []*Forward{{LocalPort: usedPort, RemotePort: "5432"}}, false, out, &bytes.Buffer{})
+	assert.Error(err)
+	session := &ForwardSession{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), session))
+	assert.Equal("i-1", session.Target)
+	assert.Equal("target:5432", session.Forwards[0].Remote)
+	assert.Equal(forwardStatusFailed, session.Forwards[0].Status)
+	assert.NotEmpty(session.Forwards[0].Error)
+
+	assert.Error(StartForwards(context.Background(), newMockConfig(server.URL), "i-1", nil, false, out, buf))
+}
+
+func TestStartForwards_KeepAlive(t *testing.T) {
+	assert := assert.New(t)
+
+	// stream closes every session right after it is opened, so forward is started again.
+	upgrader := websocket.Upgrader{}
+	stream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer stream.Close()
+
+	var sessions, terminated int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.StartSession":
+			n := atomic.AddInt32(&sessions, 1)
+			fmt.Fprintf(w, `{"SessionId":"s-%d","StreamUrl":"ws%s","TokenValue":"token"}`, n, strings.TrimPrefix(stream.URL, "http"))
+		case "AmazonSSM.TerminateSession":
+			atomic.AddInt32(&terminated, 1)
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	free, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	_, port, _ := net.SplitHostPort(free.Addr().String())
+	free.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), forwardMinBackoff+500*time.Millisecond)
+	defer cancel()
+	buf := &bytes.Buffer{}
+	out, err := NewOutput(OutputTable, buf)
+	assert.NoError(err)
+	err = StartForwards(ctx, newMockConfig(server.URL), "i-1", []*Forward{{LocalPort: port, RemotePort: "5432"}}, true, out, buf)
+	assert.NoError(err)
+	assert.Equal(int32(2), atomic.LoadInt32(&sessions))
+	assert.Equal(int32(2), atomic.LoadInt32(&terminated))
+	assert.Contains(buf.String(), "[reconnecting] "+port+" -> target:5432 in 1s")
+	assert.Contains(buf.String(), "[closed]")
+}
@@ -0,0 +1,253 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssm_types "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/fatih/color"
+)
+
+const (
+	// DefaultCommandHistoryLimit is the number of recent commands in history by default.
+	DefaultCommandHistoryLimit = 20
+
+	historyTimeFormat    = "2006-01-02 15:04:05"
+	historyMaxTargets    = 3
+	historyMaxCommandLen = 50
+)
+
+// CommandHistory is a command which was sent, ListCommands keeps it for 30 days.
+type CommandHistory struct {
+	CommandId    string    `json:"commandId"`
+	DocumentName string    `json:"documentName"`
+	Status       string    `json:"status"`
+	RequestedAt  time.Time `json:"requestedAt"`
+	// Targets are instance ids, or targets such as tag:Role=web.
+	Targets []string `json:"targets"`
+	// Commands is commands parameter of document, it is empty if document doesn't have it.
+	Commands       []string `json:"commands,omitempty"`
+	TargetCount    int32    `json:"targetCount"`
+	CompletedCount int32    `json:"completedCount"`
+	ErrorCount     int32    `json:"errorCount"`
+	// Scope is profile and region where command was sent, such as prod/us-east-1.
+	Scope string `json:"scope,omitempty"`
+}
+
+// ListCommandHistory returns recent commands in scope up to limit, the newest first.
+func ListCommandHistory(ctx context.Context, cfg aws.Config, limit int) ([]*CommandHistory, error) {
+	if limit <= 0 {
+		limit = DefaultCommandHistoryLimit
+	}
+
+	pageSize := limit
+	if pageSize > maxOutputResults {
+		pageSize = maxOutputResults
+	}
+
+	var histories []*CommandHistory
+	paginator := ssm.NewListCommandsPaginator(ssm.NewFromConfig(cfg), &ssm.ListCommandsInput{MaxResults: int32(pageSize)})
+	for paginator.HasMorePages() && len(histories) < limit {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, command := range page.Commands {
+			histories = append(histories, newCommandHistory(command))
+		}
+	}
+	sortCommandHistory(histories)
+	if len(histories) > limit {
+		histories = histories[:limit]
+	}
+	return histories, nil
+}
+
+// ListCommandHistoryInScopes returns recent commands in every scope concurrently, and merges them up to limit.
+// A scope which fails is skipped with warning, and it returns an error only if every scope fails.
+func ListCommandHistoryInScopes(ctx context.Context, scopes *Scopes, limit int) ([]*CommandHistory, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		histories []*CommandHistory
+		errs      []string
+	)
+	for _, scope := range scopes.Items {
+		wg.Add(1)
+		go func(scope *Scope) {
+			defer wg.Done()
+			hs, err := ListCommandHistory(ctx, scope.Config, limit)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", scope, err.Error()))
+				return
+			}
+			for _, h := range hs {
+				h.Scope = scope.String()
+			}
+			histories = append(histories, hs...)
+		}(scope)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 && len(errs) == len(scopes.Items) {
+		return nil, fmt.Errorf("[err] failed to list commands, %s", strings.Join(errs, ", "))
+	}
+	for _, e := range errs {
+		fmt.Fprintln(color.Output, color.YellowString("[Warning] failed to list commands in %s", e))
+	}
+	sortCommandHistory(histories)
+	if limit > 0 && len(histories) > limit {
+		histories = histories[:limit]
+	}
+	return histories, nil
+}
+
+// FindCommand returns a command in scope, it returns nil if command isn't found.
+func FindCommand(ctx context.Context, cfg aws.Config, commandId string) (*CommandHistory, error) {
+	output, err := ssm.NewFromConfig(cfg).ListCommands(ctx, &ssm.ListCommandsInput{CommandId: aws.String(commandId)})
+	var invalid *ssm_types.InvalidCommandId
+	switch {
+	case errors.As(err, &invalid):
+		return nil, nil
+	case err != nil:
+		return nil, err
+	case len(output.Commands) == 0:
+		return nil, nil
+	}
+	return newCommandHistory(output.Commands[0]), nil
+}
+
+// FetchCommandResults fetches result of command per instance again, and waits for instances where command is running.
+// onResult is called per instance as soon as it is fetched, and results are returned in the order of invocations.
+func FetchCommandResults(ctx context.Context, cfg aws.Config, commandId string, onResult func(*CommandResult)) ([]*CommandResult, error) {
+	client := ssm.NewFromConfig(cfg)
+
+	var targets []*Target
+	paginator := ssm.NewListCommandInvocationsPaginator(client, &ssm.ListCommandInvocationsInput{CommandId: aws.String(commandId)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, invocation := range page.CommandInvocations {
+			targets = append(targets, &Target{Name: aws.ToString(invocation.InstanceId), InstanceName: aws.ToString(invocation.InstanceName)})
+		}
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("[err] not found invocations of command %s", commandId)
+	}
+	return WaitCommandInvocations(ctx, cfg, commandId, targets, onResult), nil
+}
+
+// IsCommandOver returns whether status of command is over, such as Success, Failed and Cancelled.
+func IsCommandOver(status string) bool {
+	return isCommandOver(ssm_types.CommandStatus(status))
+}
+
+// CancelCommand cancels command on every instance where it isn't over.
+func CancelCommand(ctx context.Context, cfg aws.Config, commandId string) error {
+	_, err := ssm.NewFromConfig(cfg).CancelCommand(ctx, &ssm.CancelCommandInput{CommandId: aws.String(commandId)})
+	return err
+}
+
+// PrintCommandHistory prints commands as aligned columns, scope column is printed if scope is set.
+func PrintCommandHistory(w io.Writer, histories []*CommandHistory) {
+	withScope := false
+	for _, h := range histories {
+		withScope = withScope || h.Scope != ""
+	}
+
+	header := []string{"COMMAND ID", "REQUESTED AT", "STATUS", "DOCUMENT", "TARGETS", "COMPLETED", "COMMAND"}
+	if withScope {
+		header = append([]string{"SCOPE"}, header...)
+	}
+	rows := [][]string{header}
+	for _, h := range histories {
+		row := []string{
+			h.CommandId,
+			h.RequestedAt.Local().Format(historyTimeFormat),
+			h.Status,
+			h.DocumentName,
+			h.targets(),
+			fmt.Sprintf("%d/%d, %d errors", h.CompletedCount, h.TargetCount, h.ErrorCount),
+			h.command(),
+		}
+		if withScope {
+			row = append([]string{h.Scope}, row...)
+		}
+		rows = append(rows, row)
+	}
+
+	for i, line := range alignRows(rows) {
+		switch {
+		case i == 0:
+			fmt.Fprintln(w, color.New(color.Bold).Sprint(line))
+		case histories[i-1].Status == string(ssm_types.CommandStatusSuccess):
+			fmt.Fprintln(w, color.GreenString(line))
+		case IsCommandOver(histories[i-1].Status):
+			fmt.Fprintln(w, color.RedString(line))
+		default:
+			fmt.Fprintln(w, color.YellowString(line))
+		}
+	}
+}
+
+// targets returns targets of command, which are shortened if there are many instances.
+func (h *CommandHistory) targets() string {
+	if len(h.Targets) > historyMaxTargets {
+		return fmt.Sprintf("%s +%d", strings.Join(h.Targets[:historyMaxTargets], ","), len(h.Targets)-historyMaxTargets)
+	}
+	return strings.Join(h.Targets, ",")
+}
+
+// command returns the first line of commands, which is shortened if it is long.
+func (h *CommandHistory) command() string {
+	if len(h.Commands) == 0 {
+		return ""
+	}
+	command := strings.TrimSpace(h.Commands[0])
+	if len(h.Commands) > 1 {
+		command += " ..."
+	}
+	if r := []rune(command); len(r) > historyMaxCommandLen {
+		command = string(r[:historyMaxCommandLen-3]) + "..."
+	}
+	return command
+}
+
+func newCommandHistory(command ssm_types.Command) *CommandHistory {
+	h := &CommandHistory{
+		CommandId:      aws.ToString(command.CommandId),
+		DocumentName:   aws.ToString(command.DocumentName),
+		Status:         string(command.Status),
+		RequestedAt:    aws.ToTime(command.RequestedDateTime),
+		Targets:        command.InstanceIds,
+		Commands:       command.Parameters[commandsParam],
+		TargetCount:    command.TargetCount,
+		CompletedCount: command.CompletedCount,
+		ErrorCount:     command.ErrorCount,
+	}
+	if len(h.Targets) == 0 {
+		for _, t := range command.Targets {
+			h.Targets = append(h.Targets, aws.ToString(t.Key)+"="+strings.Join(t.Values, ","))
+		}
+	}
+	return h
+}
+
+// sortCommandHistory sorts commands by requested time, the newest first.
+func sortCommandHistory(histories []*CommandHistory) {
+	sort.SliceStable(histories, func(i, j int) bool {
+		return histories[i].RequestedAt.After(histories[j].RequestedAt)
+	})
+}
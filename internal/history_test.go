@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newMockCommandServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		input := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "AmazonSSM.ListCommands":
+			switch input["CommandId"] {
+			case nil:
+				w.Write([]byte(`{"Commands":[
+					{"CommandId":"c-1","DocumentName":"AWS-RunShellScript","Status":"Success","RequestedDateTime":1700000000,
+					 "InstanceIds":["i-1","i-2","i-3","i-4"],"Parameters":{"commands":["uptime"]},"TargetCount":4,"CompletedCount":4},
+					{"CommandId":"c-2","DocumentName":"AWS-RunShellScript","Status":"InProgress","RequestedDateTime":1700000100,
+					 "Targets":[{"Key":"tag:Role","Values":["web"]}],"Parameters":{"commands":["systemctl restart app","sleep 1"]},"TargetCount":2,"CompletedCount":1,"ErrorCount":1}]}`))
+			case "c-2":
+				w.Write([]byte(`{"Commands":[{"CommandId":"c-2","Status":"InProgress","Targets":[{"Key":"tag:Role","Values":["web"]}]}]}`))
+			default:
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"__type":"InvalidCommandId"}`))
+			}
+		case "AmazonSSM.ListCommandInvocations":
+			w.Write([]byte(`{"CommandInvocations":[{"InstanceId":"i-1","InstanceName":"web-1","Status":"Success"},{"InstanceId":"i-2","Status":"Failed"}]}`))
+		case "AmazonSSM.GetCommandInvocation":
+			if input["InstanceId"] == "i-1" {
+				w.Write([]byte(`{"InstanceId":"i-1","Status":"Success","ResponseCode":0,"StandardOutputContent":"ok"}`))
+			} else {
+				fmt.Fprintf(w, `{"InstanceId":%q,"Status":"Failed","ResponseCode":127,"StandardErrorContent":"not found"}`, input["InstanceId"])
+			}
+		case "AmazonSSM.CancelCommand":
+			assert.Equal(t, "c-2", input["CommandId"])
+			w.Write([]byte(`{}`))
+		}
+	}))
+}
+
+func TestListCommandHistory(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockCommandServer(t)
+	defer server.Close()
+	cfg := newMockConfig(server.URL)
+
+	histories, err := ListCommandHistory(context.Background(), cfg, 10)
+	assert.NoError(err)
+	assert.Len(histories, 2)
+	// the newest first.
+	assert.Equal("c-2", histories[0].CommandId)
+	assert.Equal([]string{"tag:Role=web"}, histories[0].Targets)
+	assert.Equal("systemctl restart app ...", histories[0].command())
+	assert.Equal(time.Unix(1700000000, 0).UTC(), histories[1].RequestedAt.UTC())
+	assert.Equal("i-1,i-2,i-3 +1", histories[1].targets())
+
+	histories, err = ListCommandHistoryInScopes(context.Background(), NewScopes(&Scope{Profile: "default", Config: cfg}), 1)
+	assert.NoError(err)
+	assert.Len(histories, 1)
+	assert.Equal("default/us-east-1", histories[0].Scope)
+
+	buf := &bytes.Buffer{}
+	PrintCommandHistory(buf, histories)
+	assert.True(strings.HasPrefix(buf.String(), "SCOPE"))
+	assert.Contains(buf.String(), "1/2, 1 errors")
+}
+
+func TestFindCommand(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockCommandServer(t)
+	defer server.Close()
+	cfg := newMockConfig(server.URL)
+
+	h, err := FindCommand(context.Background(), cfg, "c-2")
+	assert.NoError(err)
+	assert.Equal("c-2", h.CommandId)
+	assert.False(IsCommandOver(h.Status))
+	assert.NoError(CancelCommand(context.Background(), cfg, h.CommandId))
+
+	h, err = FindCommand(context.Background(), cfg, "none")
+	assert.NoError(err)
+	assert.Nil(h)
+}
+
+func TestFetchCommandResults(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMockCommandServer(t)
+	defer server.Close()
+
+	results, err := FetchCommandResults(context.Background(), newMockConfig(server.URL), "c-1", nil)
+	assert.NoError(err)
+	assert.Len(results, 2)
+	assert.Equal("web-1", results[0].InstanceName)
+	assert.Equal("ok", results[0].Stdout)
+	assert.Equal(int32(127), results[1].ExitCode)
+	assert.Equal("not found", results[1].Stderr)
+}
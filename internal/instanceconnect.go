@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+)
+
+const (
+	sshKeyType = "ssh-ed25519"
+)
+
+// SSHKey is an ephemeral ssh key pair.
+type SSHKey struct {
+	// PublicKey is authorized_keys format, ex) ssh-ed25519 AAAA... gossm
+	PublicKey string
+	// PrivateKey is OpenSSH PEM format.
+	PrivateKey []byte
+}
+
+// GenerateSSHKey generates an ephemeral ed25519 key pair in memory.
+func GenerateSSHKey() (*SSHKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	wirePub := sshWire([]byte(sshKeyType), pub)
+
+	// https://github.com/openssh/openssh-portable/blob/master/PROTOCOL.key
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return nil, WrapError(err)
+	}
+	private := append(append([]byte{}, check...), check...)
+	private = append(private, sshWire([]byte(sshKeyType), pub, priv, []byte("gossm"))...)
+	for i := byte(1); len(private)%8 != 0; i++ {
+		private = append(private, i)
+	}
+
+	body := []byte("openssh-key-v1\x00")
+	body = append(body, sshWire([]byte("none"), []byte("none"), []byte{})...)
+	body = append(body, 0, 0, 0, 1)
+	body = append(body, sshWire(wirePub, private)...)
+
+	return &SSHKey{
+		PublicKey:  fmt.Sprintf("%s %s gossm", sshKeyType, base64.StdEncoding.EncodeToString(wirePub)),
+		PrivateKey: pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: body}),
+	}, nil
+}
+
+// WriteTemporaryKey writes private key to a temporary file which only owner can read.
+// Caller must remove returned file after using it.
+func (k *SSHKey) WriteTemporaryKey() (string, error) {
+	f, err := ioutil.TempFile("", "gossm-key-")
+	if err != nil {
+		return "", WrapError(err)
+	}
+	defer f.Close()
+
+	if err := f.Chmod(0600); err != nil {
+		os.Remove(f.Name())
+		return "", WrapError(err)
+	}
+	if _, err := f.Write(k.PrivateKey); err != nil {
+		os.Remove(f.Name())
+		return "", WrapError(err)
+	}
+	return f.Name(), nil
+}
+
+// SendSSHPublicKey pushes public key to instance using EC2 Instance Connect, it is available for 60 seconds.
+func SendSSHPublicKey(ctx context.Context, cfg aws.Config, instanceId, user, publicKey string) error {
+	client := ec2instanceconnect.NewFromConfig(cfg)
+
+	output, err := client.SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:     aws.String(instanceId),
+		InstanceOSUser: aws.String(user),
+		SSHPublicKey:   aws.String(publicKey),
+	})
+	if err != nil {
+		return WrapError(err)
+	}
+	if !output.Success {
+		return WrapError(fmt.Errorf("[err] failed to send ssh public key to %s", instanceId))
+	}
+	return nil
+}
+
+// sshWire encodes values as ssh wire strings which are prefixed with length.
+func sshWire(values ...[]byte) []byte {
+	var buf []byte
+	for _, v := range values {
+		size := make([]byte, 4)
+		binary.BigEndian.PutUint32(size, uint32(len(v)))
+		buf = append(buf, size...)
+		buf = append(buf, v...)
+	}
+	return buf
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+// newMockConfig returns a config which sends every request of aws services to url.
+func newMockConfig(url string) aws.Config {
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("key", "secret", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(
+			func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+				return aws.Endpoint{URL: url, HostnameImmutable: true}, nil
+			}),
+	}
+}
+
+func TestGenerateSSHKey(t *testing.T) {
+	assert := assert.New(t)
+
+	key, err := GenerateSSHKey()
+	assert.NoError(err)
+
+	fields := strings.Fields(key.PublicKey)
+	assert.Len(fields, 3)
+	assert.Equal("ssh-ed25519", fields[0])
+	wire, err := base64.StdEncoding.DecodeString(fields[1])
+	assert.NoError(err)
+	assert.Equal(sshWire([]byte("ssh-ed25519"), wire[4+len("ssh-ed25519")+4:]), wire)
+
+	block, _ := pem.Decode(key.PrivateKey)
+	assert.NotNil(block)
+	assert.Equal("OPENSSH PRIVATE KEY", block.Type)
+	assert.True(strings.HasPrefix(string(block.Bytes), "openssh-key-v1\x00"))
+	assert.Contains(string(block.Bytes), string(wire))
+
+	path, err := key.WriteTemporaryKey()
+	assert.NoError(err)
+	defer os.Remove(path)
+	info, err := os.Stat(path)
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+}
+
+func TestSendSSHPublicKey(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		input := map[string]string{}
+		json.Unmarshal(body, &input)
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		if r.Header.Get("X-Amz-Target") != "AWSEC2InstanceConnectService.SendSSHPublicKey" ||
+			input["InstanceId"] != "i-success" || input["InstanceOSUser"] != "ec2-user" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"__type":"InvalidArgsException","Message":"invalid"}`))
+			return
+		}
+		w.Write([]byte(`{"RequestId":"request","Success":true}`))
+	}))
+	defer server.Close()
+
+	tests := map[string]struct {
+		instanceId string
+		isErr      bool
+	}{
+		"success": {instanceId: "i-success", isErr: false},
+		"fail":    {instanceId: "i-fail", isErr: true},
+	}
+
+	for _, t := range tests {
+		err := SendSSHPublicKey(context.Background(), newMockConfig(server.URL), t.instanceId, "ec2-user", "ssh-ed25519 AAAA gossm")
+		assert.Equal(t.isErr, err != nil)
+	}
+}
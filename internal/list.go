@@ -0,0 +1,220 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	targetPingOnline = "Online"
+)
+
+var (
+	// DefaultListColumns are columns of targets which are listed by default.
+	DefaultListColumns = []string{"name", "id", "private-ip", "public-ip", "type", "az", "ping", "agent"}
+	// WideListColumns are columns which are added to default columns by wide list.
+	WideListColumns = []string{"platform", "os", "resource", "launch", "tags"}
+)
+
+type (
+	// TargetList is targets which are listed with columns.
+	// Table and csv have only columns, json and yaml have every field of targets.
+	TargetList struct {
+		Targets []*Target
+		Columns []string
+	}
+
+	// targetInfo is a target which is written as json and yaml.
+	targetInfo struct {
+		Id               string            `json:"id"`
+		Name             string            `json:"name"`
+		PrivateIp        string            `json:"privateIp"`
+		PublicIp         string            `json:"publicIp"`
+		PrivateDomain    string            `json:"privateDomain"`
+		PublicDomain     string            `json:"publicDomain"`
+		InstanceType     string            `json:"instanceType"`
+		AvailabilityZone string            `json:"availabilityZone"`
+		Platform         string            `json:"platform"`
+		PlatformName     string            `json:"platformName"`
+		PlatformVersion  string            `json:"platformVersion"`
+		PingStatus       string            `json:"pingStatus"`
+		AgentVersion     string            `json:"agentVersion"`
+		ResourceType     string            `json:"resourceType"`
+		LaunchTime       *time.Time        `json:"launchTime,omitempty"`
+		Tags             map[string]string `json:"tags"`
+		Profile          string            `json:"profile"`
+		Account          string            `json:"account"`
+		Region           string            `json:"region"`
+	}
+)
+
+// ListTargets returns instances in every scope which are matched with filter and query, sorted by SortTargets.
+// Cached instances are found again if they are stale, because they aren't updated in background like interactive CLI.
+// query is fuzzy search by name, id, ips and tags like interactive CLI, every instance is returned if it is empty.
+func ListTargets(ctx context.Context, scopes *Scopes, filter *TargetFilter, query string) ([]*Target, error) {
+	table, stale, err := FindInstancesInScopes(ctx, scopes, filter, false)
+	if err != nil {
+		return nil, err
+	}
+	if stale {
+		if table, _, err = FindInstancesInScopes(ctx, scopes, filter, true); err != nil {
+			return nil, err
+		}
+	}
+
+	targets := SortTargets(table)
+	if strings.TrimSpace(query) == "" {
+		return targets, nil
+	}
+
+	// matched instances keep the sorted order instead of order of score.
+	var indexes []int
+	for _, m := range NewPicker("", targetItems(targets, DefaultTargetColumns), false).Match(query) {
+		indexes = append(indexes, m.Index)
+	}
+	sort.Ints(indexes)
+	matched := make([]*Target, 0, len(indexes))
+	for _, i := range indexes {
+		matched = append(matched, targets[i])
+	}
+	return matched, nil
+}
+
+// SortTargetsBy sorts targets by columns in order, a column which starts with - is sorted in descending order.
+// Versions and ips are compared by their numbers, such as 10.0.0.9 < 10.0.0.10.
+func SortTargetsBy(targets []*Target, columns []string) error {
+	type key struct {
+		column     string
+		descending bool
+	}
+	var keys []key
+	for _, column := range columns {
+		column = strings.TrimSpace(column)
+		descending := strings.HasPrefix(column, "-")
+		if column = strings.TrimPrefix(column, "-"); column == "" {
+			continue
+		}
+		parsed, err := ParseTargetColumns([]string{column})
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key{column: parsed[0], descending: descending})
+	}
+
+	sort.SliceStable(targets, func(i, j int) bool {
+		for _, k := range keys {
+			c := compareColumn(targets[i].Column(k.column), targets[j].Column(k.column))
+			if c == 0 {
+				continue
+			}
+			if k.descending {
+				return c > 0
+			}
+			return c < 0
+		}
+		return false
+	})
+	return nil
+}
+
+// PrintTargets prints targets as aligned columns, instances which aren't online are red.
+func PrintTargets(w io.Writer, targets []*Target, columns []string) {
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = strings.ToUpper(column)
+	}
+	lines := alignRows(append([][]string{header}, targetRows(targets, columns)...))
+
+	for i, line := range lines {
+		switch {
+		case i == 0:
+			fmt.Fprintln(w, color.New(color.Bold).Sprint(line))
+		case targets[i-1].PingStatus == targetPingOnline:
+			fmt.Fprintln(w, color.GreenString(line))
+		default:
+			fmt.Fprintln(w, color.RedString(line))
+		}
+	}
+}
+
+// Rows returns columns as a header and a row per target, which are written as csv.
+func (l *TargetList) Rows() [][]string {
+	return append([][]string{l.Columns}, targetRows(l.Targets, l.Columns)...)
+}
+
+// MarshalJSON returns every field of targets regardless of columns.
+func (l *TargetList) MarshalJSON() ([]byte, error) {
+	infos := make([]*targetInfo, 0, len(l.Targets))
+	for _, t := range l.Targets {
+		info := &targetInfo{
+			Id:               t.Name,
+			Name:             t.InstanceName,
+			PrivateIp:        t.PrivateIp,
+			PublicIp:         t.PublicIp,
+			PrivateDomain:    t.PrivateDomain,
+			PublicDomain:     t.PublicDomain,
+			InstanceType:     t.InstanceType,
+			AvailabilityZone: t.AvailabilityZone,
+			Platform:         t.Platform,
+			PlatformName:     t.PlatformName,
+			PlatformVersion:  t.PlatformVersion,
+			PingStatus:       t.PingStatus,
+			AgentVersion:     t.AgentVersion,
+			ResourceType:     t.ResourceType,
+			Tags:             t.Tags,
+			Profile:          t.Profile,
+			Account:          t.Account,
+			Region:           t.Region,
+		}
+		if !t.LaunchTime.IsZero() {
+			launchTime := t.LaunchTime.UTC()
+			info.LaunchTime = &launchTime
+		}
+		if info.Tags == nil {
+			info.Tags = map[string]string{}
+		}
+		infos = append(infos, info)
+	}
+	return json.Marshal(infos)
+}
+
+// compareColumn compares values of column, values which consist of numbers and dots are compared by their numbers.
+func compareColumn(a, b string) int {
+	if na, nb := splitNumbers(a), splitNumbers(b); na != nil && nb != nil {
+		for i := 0; i < len(na) && i < len(nb); i++ {
+			if na[i] != nb[i] {
+				if na[i] < nb[i] {
+					return -1
+				}
+				return 1
+			}
+		}
+		return len(na) - len(nb)
+	}
+	return strings.Compare(a, b)
+}
+
+// splitNumbers returns numbers of value which is separated by dots such as versions and ips, otherwise it returns nil.
+func splitNumbers(value string) []int {
+	if value == "" {
+		return nil
+	}
+	fields := strings.Split(value, ".")
+	numbers := make([]int, 0, len(fields))
+	for _, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return nil
+		}
+		numbers = append(numbers, n)
+	}
+	return numbers
+}
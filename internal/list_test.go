@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.Write([]byte(`{"InstanceInformationList":[
+			{"InstanceId":"mi-2","ResourceType":"ManagedInstance","PingStatus":"Online","ComputerName":"edge-api","IPAddress":"10.0.0.10"},
+			{"InstanceId":"mi-1","ResourceType":"ManagedInstance","PingStatus":"ConnectionLost","ComputerName":"edge-db","IPAddress":"10.0.0.9"}]}`))
+	}))
+	defer server.Close()
+
+	scopes := NewScopes(&Scope{Profile: "default", Config: newMockConfig(server.URL)})
+	targets, err := ListTargets(context.Background(), scopes, nil, "")
+	assert.NoError(err)
+	assert.Len(targets, 2)
+
+	targets, err = ListTargets(context.Background(), scopes, nil, "db")
+	assert.NoError(err)
+	assert.Len(targets, 1)
+	assert.Equal("mi-1", targets[0].Name)
+}
+
+func TestSortTargetsBy(t *testing.T) {
+	assert := assert.New(t)
+
+	targets := []*Target{
+		{Name: "i-1", PrivateIp: "10.0.0.10", AvailabilityZone: "us-east-1a", AgentVersion: "3.1.9.0"},
+		{Name: "i-2", PrivateIp: "10.0.0.9", AvailabilityZone: "us-east-1b", AgentVersion: "3.1.10.0"},
+		{Name: "i-3", PrivateIp: "10.0.0.11", AvailabilityZone: "us-east-1a", AgentVersion: "3.1.10.0"},
+	}
+	ids := func() string {
+		var names []string
+		for _, t := range targets {
+			names = append(names, t.Name)
+		}
+		return strings.Join(names, ",")
+	}
+
+	// ips and versions are compared by their numbers.
+	assert.NoError(SortTargetsBy(targets, []string{"private-ip"}))
+	assert.Equal("i-2,i-1,i-3", ids())
+	assert.NoError(SortTargetsBy(targets, []string{"-agent", " id"}))
+	assert.Equal("i-2,i-3,i-1", ids())
+	assert.NoError(SortTargetsBy(targets, []string{"az", "-id", ""}))
+	assert.Equal("i-3,i-1,i-2", ids())
+
+	assert.Error(SortTargetsBy(targets, []string{"owner"}))
+}
+
+func TestTargetList(t *testing.T) {
+	assert := assert.New(t)
+
+	launch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	list := &TargetList{
+		Targets: []*Target{
+			{Name: "i-1", InstanceName: "api", PrivateIp: "10.0.0.1", PingStatus: "Online", LaunchTime: launch, Tags: map[string]string{"Team": "infra", "Env": "prod"}},
+			{Name: "i-2", InstanceName: "db, primary", PingStatus: "ConnectionLost"},
+		},
+		Columns: []string{"name", "id", "tags"},
+	}
+
+	buf := &bytes.Buffer{}
+	out, err := NewOutput(OutputCSV, buf)
+	assert.NoError(err)
+	assert.NoError(out.Write(list, nil))
+	assert.Equal("name,id,tags\napi,i-1,\"Env=prod,Team=infra\"\n\"db, primary\",i-2,\n", buf.String())
+
+	// json has every field of targets regardless of columns.
+	buf.Reset()
+	out, err = NewOutput(OutputJSON, buf)
+	assert.NoError(err)
+	assert.NoError(out.Write(list, nil))
+	var infos []map[string]interface{}
+	assert.NoError(json.Unmarshal(buf.Bytes(), &infos))
+	assert.Len(infos, 2)
+	assert.Equal("i-1", infos[0]["id"])
+	assert.Equal("10.0.0.1", infos[0]["privateIp"])
+	assert.Equal("2024-01-02T03:04:05Z", infos[0]["launchTime"])
+	assert.Equal(map[string]interface{}{"Team": "infra", "Env": "prod"}, infos[0]["tags"])
+	assert.Nil(infos[1]["launchTime"])
+	assert.Equal(map[string]interface{}{}, infos[1]["tags"])
+
+	buf.Reset()
+	PrintTargets(buf, list.Targets, list.Columns)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Equal([]string{"NAME         ID   TAGS", "api          i-1  Env=prod,Team=infra", "db, primary  i-2"}, lines)
+
+	// csv isn't supported by results which don't have rows.
+	out, _ = NewOutput(OutputCSV, buf)
+	assert.Error(out.Write([]*CommandResult{}, nil))
+}
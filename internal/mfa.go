@@ -0,0 +1,322 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/AlecAivazis/survey/v2"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/fatih/color"
+)
+
+const (
+	// DefaultMFADuration is lifetime of temporary credentials which are issued by mfa.
+	DefaultMFADuration = 6 * time.Hour
+	// MFAExpiryWarning is remaining lifetime of cached credentials, which is warned when they are used.
+	MFAExpiryWarning = 15 * time.Minute
+	// DefaultMFARoleDuration is lifetime of role which is assumed with mfa, it is the default max session duration of roles.
+	DefaultMFARoleDuration = time.Hour
+
+	virtualMFADevice = "arn:aws:iam::%s:mfa/%s"
+	mfaTimeFormat    = "2006-01-02 15:04:05"
+)
+
+type (
+	// MFACache caches temporary credentials of mfa per profile in files, such as ~/.gossm/credentials/<profile>.json.
+	MFACache struct {
+		dir string
+		now func() time.Time
+	}
+
+	// MFACredential is temporary credentials of profile which are issued by mfa.
+	MFACredential struct {
+		Profile         string    `json:"profile"`
+		Device          string    `json:"device"`
+		AccessKeyId     string    `json:"accessKeyId"`
+		SecretAccessKey string    `json:"secretAccessKey"`
+		SessionToken    string    `json:"sessionToken"`
+		Expiration      time.Time `json:"expiration"`
+	}
+
+	// MFAProfile is mfa settings of profile in shared config files.
+	MFAProfile struct {
+		Profile string
+		// Device is mfa_serial of profile, it is empty if profile doesn't need mfa.
+		Device string
+		// RoleArn is role_arn of profile, role is assumed with mfa instead of issuing a session token if it is set.
+		RoleArn string
+	}
+)
+
+// NewMFACache returns a cache of mfa credentials in dir.
+func NewMFACache(dir string) *MFACache {
+	return &MFACache{dir: dir, now: time.Now}
+}
+
+// Path returns a file of profile, profile is escaped so it can't be a path.
+func (c *MFACache) Path(profile string) string {
+	return filepath.Join(c.dir, url.PathEscape(profile)+".json")
+}
+
+// Get returns cached credentials of profile, it returns nil if they aren't cached.
+// Expired credentials are also returned, so they can be warned.
+func (c *MFACache) Get(profile string) (*MFACredential, error) {
+	buf, err := ioutil.ReadFile(c.Path(profile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	cred := &MFACredential{}
+	if err := json.Unmarshal(buf, cred); err != nil || cred.Profile != profile {
+		return nil, fmt.Errorf("[err] invalid mfa credential file %s", c.Path(profile))
+	}
+	return cred, nil
+}
+
+// Set caches credentials of profile, the file is readable only by owner.
+func (c *MFACache) Set(cred *MFACredential) error {
+	buf, err := json.MarshalIndent(cred, "", "  ")
+	if err != nil {
+		return WrapError(err)
+	}
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return WrapError(err)
+	}
+
+	// write a temporary file and then rename it, so other processes don't read a partial file.
+	path := c.Path(cred.Profile)
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, buf, 0600); err != nil {
+		return WrapError(err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return WrapError(err)
+	}
+	return nil
+}
+
+// List returns cached credentials of every profile, sorted by profile.
+func (c *MFACache) List() ([]*MFACredential, error) {
+	files, err := ioutil.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	var creds []*MFACredential
+	for _, f := range files {
+		if f.IsDir() || filepath.Ext(f.Name()) != ".json" {
+			continue
+		}
+		profile, err := url.PathUnescape(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		cred, err := c.Get(profile)
+		if err != nil || cred == nil {
+			continue
+		}
+		creds = append(creds, cred)
+	}
+	sort.Slice(creds, func(i, j int) bool { return creds[i].Profile < creds[j].Profile })
+	return creds, nil
+}
+
+// Clear removes cached credentials of profile, it removes credentials of every profile if profile is empty.
+func (c *MFACache) Clear(profile string) error {
+	var err error
+	if profile == "" {
+		err = os.RemoveAll(c.dir)
+	} else if err = os.Remove(c.Path(profile)); os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// Remaining returns remaining lifetime of cached credentials, it is 0 if they are expired.
+func (c *MFACache) Remaining(cred *MFACredential) time.Duration {
+	if remaining := cred.Expiration.Sub(c.now()); remaining > 0 {
+		return remaining.Truncate(time.Second)
+	}
+	return 0
+}
+
+// Credentials returns credentials of AWS, which expire at expiration of mfa.
+func (cred *MFACredential) Credentials() aws.Credentials {
+	return aws.Credentials{
+		AccessKeyID:     cred.AccessKeyId,
+		SecretAccessKey: cred.SecretAccessKey,
+		SessionToken:    cred.SessionToken,
+		Source:          "gossm mfa",
+		CanExpire:       true,
+		Expires:         cred.Expiration,
+	}
+}
+
+// Retrieve returns credentials of mfa, so MFACredential can be a credentials provider of config.
+func (cred *MFACredential) Retrieve(context.Context) (aws.Credentials, error) {
+	return cred.Credentials(), nil
+}
+
+// FindMFADevice returns virtual mfa device of user whose credentials are in cfg.
+func FindMFADevice(ctx context.Context, cfg aws.Config) (string, error) {
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", WrapError(err)
+	}
+	// user arn is arn:aws:iam::<account>:user/<path>/<name>, and virtual device has the same name.
+	arn := aws.ToString(identity.Arn)
+	if !strings.Contains(arn, ":user/") {
+		return "", fmt.Errorf("[err] not found mfa device of %s, it isn't an user", arn)
+	}
+	name := arn[strings.LastIndex(arn, "/")+1:]
+	return fmt.Sprintf(virtualMFADevice, aws.ToString(identity.Account), name), nil
+}
+
+// GetMFASessionToken issues temporary credentials of profile with mfa code of device.
+func GetMFASessionToken(ctx context.Context, cfg aws.Config, profile, device, code string, duration time.Duration) (*MFACredential, error) {
+	output, err := sts.NewFromConfig(cfg).GetSessionToken(ctx, &sts.GetSessionTokenInput{
+		DurationSeconds: aws.Int32(int32(duration.Seconds())),
+		SerialNumber:    aws.String(device),
+		TokenCode:       aws.String(code),
+	})
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return &MFACredential{
+		Profile:         profile,
+		Device:          device,
+		AccessKeyId:     aws.ToString(output.Credentials.AccessKeyId),
+		SecretAccessKey: aws.ToString(output.Credentials.SecretAccessKey),
+		SessionToken:    aws.ToString(output.Credentials.SessionToken),
+		Expiration:      aws.ToTime(output.Credentials.Expiration),
+	}, nil
+}
+
+// AssumeMFARole issues temporary credentials of profile by assuming role with mfa,
+// cfg is created by NewMFASharedConfig so settings of role such as external_id are read from shared config files.
+func AssumeMFARole(ctx context.Context, cfg aws.Config, profile, device string) (*MFACredential, error) {
+	cred, err := cfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	if !cred.CanExpire {
+		return nil, fmt.Errorf("[err] credentials of profile %s aren't temporary, role isn't assumed", profile)
+	}
+	return &MFACredential{
+		Profile:         profile,
+		Device:          device,
+		AccessKeyId:     cred.AccessKeyID,
+		SecretAccessKey: cred.SecretAccessKey,
+		SessionToken:    cred.SessionToken,
+		Expiration:      cred.Expires,
+	}, nil
+}
+
+// FindMFAProfile returns mfa settings of profile in shared config files, device is empty if profile isn't in files.
+func FindMFAProfile(ctx context.Context, profile string, sharedConfigFiles, sharedCredentialsFiles []string) (*MFAProfile, error) {
+	shared, err := config.LoadSharedConfigProfile(ctx, profile, func(o *config.LoadSharedConfigOptions) {
+		o.ConfigFiles = sharedConfigFiles
+		o.CredentialsFiles = sharedCredentialsFiles
+	})
+	if errors.As(err, &config.SharedConfigProfileNotExistError{}) {
+		return &MFAProfile{Profile: profile}, nil
+	}
+	if err != nil {
+		return nil, WrapError(err)
+	}
+	return &MFAProfile{Profile: profile, Device: shared.MFASerial, RoleArn: shared.RoleARN}, nil
+}
+
+// NewMFASharedConfig creates a config of profile in shared files like NewSharedConfig,
+// role of profile which has mfa_serial is assumed with a code of tokenProvider.
+func NewMFASharedConfig(ctx context.Context, profile string, sharedConfigFiles, sharedCredentialsFiles []string, tokenProvider func() (string, error)) (aws.Config, error) {
+	if ctx == nil {
+		return aws.Config{}, WrapError(ErrInvalidParams)
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithSharedConfigProfile(profile),
+		config.WithSharedConfigFiles(sharedConfigFiles),
+		config.WithSharedCredentialsFiles(sharedCredentialsFiles),
+		config.WithAssumeRoleCredentialOptions(func(o *stscreds.AssumeRoleOptions) {
+			o.TokenProvider = tokenProvider
+			// session of role is 15 minutes by default, it is too short to be cached.
+			if o.Duration == 0 {
+				o.Duration = DefaultMFARoleDuration
+			}
+		}),
+	)
+	if err != nil {
+		return aws.Config{}, WrapError(err)
+	}
+	return cfg, nil
+}
+
+// AskMFACode asks you which types a code of mfa device for profile.
+func AskMFACode(profile, device string) (string, error) {
+	prompt := &survey.Input{
+		Message: fmt.Sprintf("Type mfa code of %s for profile %s:", device, profile),
+	}
+	var code string
+	if err := survey.AskOne(prompt, &code, survey.WithValidator(validateMFACode), promptStdio); err != nil {
+		return "", WrapError(err)
+	}
+	return strings.TrimSpace(code), nil
+}
+
+// validateMFACode validates a code of mfa device, which is 6 digits.
+func validateMFACode(ans interface{}) error {
+	code, _ := ans.(string)
+	code = strings.TrimSpace(code)
+	if len(code) != 6 || strings.Trim(code, "0123456789") != "" {
+		return fmt.Errorf("mfa code must be 6 digits")
+	}
+	return nil
+}
+
+// PrintMFACredentials prints cached credentials as aligned columns without secrets, expired ones are red.
+func PrintMFACredentials(w io.Writer, cache *MFACache, creds []*MFACredential) {
+	rows := [][]string{{"PROFILE", "DEVICE", "EXPIRATION", "REMAINING"}}
+	for _, cred := range creds {
+		remaining := "expired"
+		if r := cache.Remaining(cred); r > 0 {
+			remaining = r.String()
+		}
+		rows = append(rows, []string{cred.Profile, cred.Device, cred.Expiration.Local().Format(mfaTimeFormat), remaining})
+	}
+
+	for i, line := range alignRows(rows) {
+		switch {
+		case i == 0:
+			fmt.Fprintln(w, color.New(color.Bold).Sprint(line))
+		case cache.Remaining(creds[i-1]) == 0:
+			fmt.Fprintln(w, color.RedString(line))
+		case cache.Remaining(creds[i-1]) < MFAExpiryWarning:
+			fmt.Fprintln(w, color.YellowString(line))
+		default:
+			fmt.Fprintln(w, color.GreenString(line))
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMFACache(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := filepath.Join(t.TempDir(), "credentials")
+	now := time.Now()
+	cache := &MFACache{dir: dir, now: func() time.Time { return now }}
+
+	cred, err := cache.Get("dev")
+	assert.NoError(err)
+	assert.Nil(cred)
+	creds, err := cache.List()
+	assert.NoError(err)
+	assert.Empty(creds)
+
+	assert.NoError(cache.Set(&MFACredential{Profile: "prod", AccessKeyId: "key", SecretAccessKey: "secret", SessionToken: "token", Expiration: now.Add(time.Hour)}))
+	assert.NoError(cache.Set(&MFACredential{Profile: "dev/a", AccessKeyId: "key", Expiration: now.Add(-time.Minute)}))
+
+	// file is readable only by owner, and profile can't be a path.
+	info, err := os.Stat(cache.Path("prod"))
+	assert.NoError(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+	assert.Equal(dir, filepath.Dir(cache.Path("dev/a")))
+
+	cred, err = cache.Get("prod")
+	assert.NoError(err)
+	assert.Equal("token", cred.SessionToken)
+	assert.Equal(time.Hour, cache.Remaining(cred))
+	creds, err = cache.List()
+	assert.NoError(err)
+	assert.Len(creds, 2)
+	assert.Equal("dev/a", creds[0].Profile)
+	assert.Equal(time.Duration(0), cache.Remaining(creds[0]))
+
+	// credentials of mfa expire at expiration.
+	awsCred, err := cred.Retrieve(context.Background())
+	assert.NoError(err)
+	assert.Equal("key", awsCred.AccessKeyID)
+	assert.True(awsCred.CanExpire)
+	assert.Equal(cred.Expiration, awsCred.Expires)
+
+	buf := &bytes.Buffer{}
+	PrintMFACredentials(buf, cache, creds)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(lines, 3)
+	assert.True(strings.HasSuffix(lines[1], "expired"))
+	assert.True(strings.HasSuffix(lines[2], "1h0m0s"))
+
+	assert.NoError(cache.Clear("dev/a"))
+	assert.NoError(cache.Clear("none"))
+	creds, err = cache.List()
+	assert.NoError(err)
+	assert.Len(creds, 1)
+	assert.NoError(cache.Clear(""))
+	_, err = os.Stat(dir)
+	assert.True(os.IsNotExist(err))
+}
+
+func TestGetMFASessionToken(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "text/xml")
+		switch r.Form.Get("Action") {
+		case "GetCallerIdentity":
+			w.Write([]byte(`<GetCallerIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetCallerIdentityResult>
+				<Arn>arn:aws:iam::123456789012:user/devops/gossm</Arn><UserId>USER</UserId><Account>123456789012</Account>
+				</GetCallerIdentityResult></GetCallerIdentityResponse>`))
+		case "GetSessionToken":
+			assert.Equal("arn:aws:iam::123456789012:mfa/gossm", r.Form.Get("SerialNumber"))
+			assert.Equal("123456", r.Form.Get("TokenCode"))
+			assert.Equal("3600", r.Form.Get("DurationSeconds"))
+			w.Write([]byte(`<GetSessionTokenResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><GetSessionTokenResult><Credentials>
+				<AccessKeyId>key</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+				<Expiration>2030-01-02T03:04:05Z</Expiration></Credentials></GetSessionTokenResult></GetSessionTokenResponse>`))
+		}
+	}))
+	defer server.Close()
+	cfg := newMockConfig(server.URL)
+
+	device, err := FindMFADevice(context.Background(), cfg)
+	assert.NoError(err)
+	assert.Equal("arn:aws:iam::123456789012:mfa/gossm", device)
+
+	cred, err := GetMFASessionToken(context.Background(), cfg, "prod", device, "123456", time.Hour)
+	assert.NoError(err)
+	assert.Equal(&MFACredential{Profile: "prod", Device: device, AccessKeyId: "key", SecretAccessKey: "secret", SessionToken: "token",
+		Expiration: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}, cred)
+}
+
+func TestFindMFAProfile(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config")
+	credFile := filepath.Join(dir, "credentials")
+	assert.NoError(os.WriteFile(configFile, []byte(`[profile hub]
+mfa_serial = arn:aws:iam::123456789012:mfa/gossm
+[profile workload]
+role_arn = arn:aws:iam::210987654321:role/admin
+source_profile = hub
+mfa_serial = arn:aws:iam::123456789012:mfa/gossm
+[profile dev]
+region = us-east-1
+`), 0600))
+	assert.NoError(os.WriteFile(credFile, []byte("[hub]\naws_access_key_id = key\naws_secret_access_key = secret\n"), 0600))
+
+	tests := map[string]*MFAProfile{
+		"hub":      {Profile: "hub", Device: "arn:aws:iam::123456789012:mfa/gossm"},
+		"workload": {Profile: "workload", Device: "arn:aws:iam::123456789012:mfa/gossm", RoleArn: "arn:aws:iam::210987654321:role/admin"},
+		"dev":      {Profile: "dev"},
+		"none":     {Profile: "none"},
+	}
+	for profile, expected := range tests {
+		mfaProfile, err := FindMFAProfile(context.Background(), profile, []string{configFile}, []string{credFile})
+		assert.NoError(err)
+		assert.Equal(expected, mfaProfile, profile)
+	}
+
+	// role of profile with mfa_serial needs a token provider.
+	cfg, err := NewMFASharedConfig(context.Background(), "workload", []string{configFile}, []string{credFile},
+		func() (string, error) { return "123456", nil })
+	assert.NoError(err)
+	assert.NotNil(cfg.Credentials)
+}
+
+func TestAssumeMFARole(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		assert.Equal("AssumeRole", r.Form.Get("Action"))
+		assert.Equal("arn:aws:iam::210987654321:role/admin", r.Form.Get("RoleArn"))
+		assert.Equal("arn:aws:iam::123456789012:mfa/gossm", r.Form.Get("SerialNumber"))
+		assert.Equal("123456", r.Form.Get("TokenCode"))
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/"><AssumeRoleResult><Credentials>
+			<AccessKeyId>key</AccessKeyId><SecretAccessKey>secret</SecretAccessKey><SessionToken>token</SessionToken>
+			<Expiration>2030-01-02T03:04:05Z</Expiration></Credentials></AssumeRoleResult></AssumeRoleResponse>`))
+	}))
+	defer server.Close()
+
+	device := "arn:aws:iam::123456789012:mfa/gossm"
+	cfg := newMockConfig(server.URL)
+	cfg.Credentials = stscreds.NewAssumeRoleProvider(sts.NewFromConfig(cfg), "arn:aws:iam::210987654321:role/admin",
+		func(o *stscreds.AssumeRoleOptions) {
+			o.SerialNumber = aws.String(device)
+			o.TokenProvider = func() (string, error) { return "123456", nil }
+		})
+
+	cred, err := AssumeMFARole(context.Background(), cfg, "workload", device)
+	assert.NoError(err)
+	assert.Equal(&MFACredential{Profile: "workload", Device: device, AccessKeyId: "key", SecretAccessKey: "secret", SessionToken: "token",
+		Expiration: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)}, cred)
+
+	// static credentials aren't assumed role.
+	_, err = AssumeMFARole(context.Background(), newMockConfig(server.URL), "workload", device)
+	assert.Error(err)
+}
+
+func TestValidateMFACode(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.NoError(validateMFACode("123456"))
+	assert.NoError(validateMFACode(" 012345 "))
+	assert.Error(validateMFACode("12345"))
+	assert.Error(validateMFACode("12345a"))
+	assert.Error(validateMFACode(nil))
+}
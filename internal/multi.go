@@ -0,0 +1,355 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+const (
+	// multiCommandPrefix starts a line which controls multi session instead of being broadcast, such as :hosts.
+	multiCommandPrefix = ":"
+	// multiInterrupt is ctrl+c which is broadcast to interrupt running commands.
+	multiInterrupt = "\x03"
+	// multiFlushDelay is how long a partial line waits for the rest, a prompt of shell is written after it.
+	multiFlushDelay = 100 * time.Millisecond
+)
+
+var (
+	// multiColors are colors of hosts, which are used in turn.
+	multiColors = []color.Attribute{color.FgCyan, color.FgGreen, color.FgMagenta, color.FgYellow, color.FgBlue,
+		color.FgHiCyan, color.FgHiGreen, color.FgHiMagenta, color.FgHiYellow, color.FgHiBlue}
+)
+
+type (
+	// MultiSession is shell sessions to multiple hosts, where a typed line is broadcast to every enabled host.
+	// Output of each host is written line by line with a colorized prefix of host.
+	MultiSession struct {
+		mu    sync.Mutex
+		w     io.Writer
+		hosts []*multiHost
+		width int
+		// open is the number of hosts whose session isn't over, done is closed when it is 0.
+		open int
+		done chan struct{}
+		wg   sync.WaitGroup
+	}
+
+	// multiHost is a session of MultiSession.
+	multiHost struct {
+		name    string
+		prefix  string
+		stream  io.ReadWriteCloser
+		enabled bool
+		closed  bool
+	}
+)
+
+// NewMultiSession returns a multi session which writes output of hosts to w.
+func NewMultiSession(w io.Writer) *MultiSession {
+	return &MultiSession{w: w, done: make(chan struct{})}
+}
+
+// Add adds a session of host, and output of stream is written from Run until it is over.
+// Hosts should be added before Run, so their prefixes are aligned.
+func (m *MultiSession) Add(name string, stream io.ReadWriteCloser) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := &multiHost{name: name, stream: stream, enabled: true}
+	m.hosts = append(m.hosts, h)
+	if len(name) > m.width {
+		m.width = len(name)
+	}
+	for i, host := range m.hosts {
+		c := color.New(multiColors[i%len(multiColors)])
+		host.prefix = c.Sprintf("[%-*s]", m.width, host.name)
+	}
+}
+
+// start starts relays of every host, hosts are counted before any relay so that done is closed once after all of them.
+func (m *MultiSession) start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.open = len(m.hosts)
+	if m.open == 0 {
+		close(m.done)
+		return
+	}
+	for _, h := range m.hosts {
+		m.wg.Add(1)
+		go m.relay(h)
+	}
+}
+
+// relay writes output of host line by line with prefix, and marks host closed when its session is over.
+// A partial line such as a prompt is written when the rest doesn't arrive in multiFlushDelay.
+func (m *MultiSession) relay(h *multiHost) {
+	defer m.wg.Done()
+
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		buf := make([]byte, 4096)
+		for {
+			n, err := h.stream.Read(buf)
+			if n > 0 {
+				chunks <- append([]byte(nil), buf[:n]...)
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	var (
+		pending []byte
+		flush   <-chan time.Time
+	)
+	for over := false; !over; {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				over = true
+				break
+			}
+			pending = append(pending, chunk...)
+			for {
+				i := bytes.IndexByte(pending, '\n')
+				if i < 0 {
+					break
+				}
+				m.writeLine(h, pending[:i+1])
+				pending = pending[i+1:]
+			}
+			flush = nil
+			if len(pending) > 0 {
+				flush = time.After(multiFlushDelay)
+			}
+		case <-flush:
+			m.writeLine(h, pending)
+			pending, flush = nil, nil
+		}
+	}
+	if len(pending) > 0 {
+		m.writeLine(h, pending)
+	}
+
+	m.mu.Lock()
+	h.closed, h.enabled = true, false
+	m.open--
+	all := m.open == 0
+	m.mu.Unlock()
+	m.printLine(h, color.RedString("session is over"))
+	if all {
+		close(m.done)
+	}
+}
+
+// writeLine writes a line of host with prefix, carriage returns of terminal are removed.
+func (m *MultiSession) writeLine(h *multiHost, line []byte) {
+	m.printLine(h, string(bytes.TrimRight(bytes.ReplaceAll(line, []byte("\r"), nil), "\n")))
+}
+
+// printLine writes a line with prefix of host.
+func (m *MultiSession) printLine(h *multiHost, line string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(m.w, "%s %s\n", h.prefix, line)
+}
+
+func (m *MultiSession) printf(format string, args ...interface{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(m.w, format+"\n", args...)
+}
+
+// Run broadcasts each line of r to enabled hosts until r is over, ctx is done or every session is over.
+// Lines which start with : control hosts instead of being broadcast,
+//
+//	:hosts                   list hosts and whether they are enabled
+//	:toggle <host|index>...  enable or disable hosts
+//	:only <host|index>...    enable only these hosts
+//	:all                     enable every host
+//	:quit                    close every session
+func (m *MultiSession) Run(ctx context.Context, r io.Reader) error {
+	defer m.Close()
+	m.start()
+
+	lines := make(chan string)
+	errs := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		scanner := bufio.NewScanner(r)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+		errs <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-m.done:
+			return nil
+		case err := <-errs:
+			if err != nil {
+				return WrapError(err)
+			}
+			return nil
+		case line := <-lines:
+			if strings.HasPrefix(line, multiCommandPrefix) {
+				if quit := m.control(strings.Fields(strings.TrimPrefix(line, multiCommandPrefix))); quit {
+					return nil
+				}
+				continue
+			}
+			m.Broadcast(line + "\n")
+		}
+	}
+}
+
+// Interrupt broadcasts ctrl+c to enabled hosts, such as a long running command.
+func (m *MultiSession) Interrupt() {
+	m.Broadcast(multiInterrupt)
+}
+
+// Broadcast writes data to every enabled host.
+func (m *MultiSession) Broadcast(data string) {
+	m.mu.Lock()
+	var hosts []*multiHost
+	for _, h := range m.hosts {
+		if h.enabled {
+			hosts = append(hosts, h)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, h := range hosts {
+		if _, err := io.WriteString(h.stream, data); err != nil {
+			m.printLine(h, color.RedString("[err] %s", err.Error()))
+		}
+	}
+}
+
+// control runs a command of multi session, and it returns whether sessions should be closed.
+func (m *MultiSession) control(fields []string) bool {
+	if len(fields) == 0 {
+		m.printHosts()
+		return false
+	}
+
+	switch cmd, args := fields[0], fields[1:]; cmd {
+	case "quit", "exit":
+		return true
+	case "hosts", "list":
+	case "all":
+		m.setEnabled(func(int, *multiHost) bool { return true })
+	case "toggle", "only":
+		indexes, err := m.findHosts(args)
+		if err != nil {
+			m.printf("%s", color.RedString(err.Error()))
+			return false
+		}
+		m.setEnabled(func(i int, h *multiHost) bool {
+			if cmd == "only" {
+				return indexes[i]
+			}
+			return h.enabled != indexes[i]
+		})
+	default:
+		m.printf("%s", color.RedString("[err] unknown command :%s, such as :hosts, :toggle, :only, :all and :quit", cmd))
+		return false
+	}
+	m.printHosts()
+	return false
+}
+
+// findHosts returns indexes of hosts which are matched with names or 1-based indexes.
+func (m *MultiSession) findHosts(args []string) (map[int]bool, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("[err] not found hosts, ex) :toggle 1 web-2")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	indexes := map[int]bool{}
+	for _, arg := range args {
+		found := false
+		for i, h := range m.hosts {
+			if h.name == arg || strconv.Itoa(i+1) == arg {
+				indexes[i], found = true, true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("[err] not found host %s", arg)
+		}
+	}
+	return indexes, nil
+}
+
+// setEnabled changes whether hosts are enabled, closed hosts are kept disabled.
+func (m *MultiSession) setEnabled(enabled func(i int, h *multiHost) bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, h := range m.hosts {
+		h.enabled = enabled(i, h) && !h.closed
+	}
+}
+
+// printHosts prints hosts with index and status.
+func (m *MultiSession) printHosts() {
+	m.mu.Lock()
+	lines := make([]string, 0, len(m.hosts))
+	for i, h := range m.hosts {
+		status := color.GreenString("on")
+		switch {
+		case h.closed:
+			status = color.RedString("closed")
+		case !h.enabled:
+			status = color.YellowString("off")
+		}
+		lines = append(lines, fmt.Sprintf("%d %s %s", i+1, h.prefix, status))
+	}
+	m.mu.Unlock()
+	m.printf("%s", strings.Join(lines, "\n"))
+}
+
+// Hosts returns names of enabled hosts in order.
+func (m *MultiSession) Hosts() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var names []string
+	for _, h := range m.hosts {
+		if h.enabled {
+			names = append(names, h.name)
+		}
+	}
+	return names
+}
+
+// Close closes every session, and waits until their output is written.
+func (m *MultiSession) Close() {
+	m.mu.Lock()
+	hosts := append([]*multiHost{}, m.hosts...)
+	m.mu.Unlock()
+	for _, h := range hosts {
+		h.stream.Close()
+	}
+	m.wg.Wait()
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syncBuffer is a buffer which is written by multiple goroutines.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// newMockShell returns a stream of shell which replies each line with host name like a terminal.
+func newMockShell(name string) io.ReadWriteCloser {
+	client, server := net.Pipe()
+	go func() {
+		defer server.Close()
+		scanner := bufio.NewScanner(server)
+		for scanner.Scan() {
+			if scanner.Text() == "exit" {
+				return
+			}
+			io.WriteString(server, name+" "+scanner.Text()+"\r\n")
+		}
+	}()
+	return client
+}
+
+func TestMultiSession(t *testing.T) {
+	assert := assert.New(t)
+
+	out := &syncBuffer{}
+	m := NewMultiSession(out)
+	m.Add("web-1", newMockShell("web-1"))
+	m.Add("web-2", newMockShell("web-2"))
+	m.Add("web-10", newMockShell("web-10"))
+	assert.Equal([]string{"web-1", "web-2", "web-10"}, m.Hosts())
+
+	r, w := io.Pipe()
+	done := make(chan error)
+	go func() { done <- m.Run(context.Background(), r) }()
+
+	send := func(line string, expected ...string) {
+		io.WriteString(w, line+"\n")
+		for _, e := range expected {
+			assert.Eventually(func() bool { return strings.Contains(out.String(), e) }, time.Second, 5*time.Millisecond, e)
+		}
+	}
+
+	// output is prefixed with aligned host names.
+	send("uptime", "[web-1 ] web-1 uptime\n", "[web-2 ] web-2 uptime\n", "[web-10] web-10 uptime\n")
+
+	send(":toggle 2", "2 [web-2 ] off")
+	assert.Equal([]string{"web-1", "web-10"}, m.Hosts())
+	send("whoami", "[web-1 ] web-1 whoami", "[web-10] web-10 whoami")
+
+	send(":only web-10", "1 [web-1 ] off")
+	assert.Equal([]string{"web-10"}, m.Hosts())
+	send("id", "[web-10] web-10 id")
+
+	send(":unknown", "unknown command :unknown")
+	send(":toggle web-3", "not found host web-3")
+
+	// a session which is over is kept disabled.
+	send("exit", "[web-10] session is over")
+	send(":all", "3 [web-10] closed")
+	assert.Equal([]string{"web-1", "web-2"}, m.Hosts())
+
+	send(":quit")
+	assert.NoError(<-done)
+	assert.NotContains(out.String(), "web-2 whoami")
+	assert.NotContains(out.String(), "web-1 id")
+}
+
+func TestMultiSession_AllOver(t *testing.T) {
+	assert := assert.New(t)
+
+	m := NewMultiSession(&syncBuffer{})
+	m.Add("web-1", newMockShell("web-1"))
+	m.Add("web-2", newMockShell("web-2"))
+
+	// Run is over when every session is over, even if input isn't over.
+	r, w := io.Pipe()
+	defer w.Close()
+	done := make(chan error)
+	go func() { done <- m.Run(context.Background(), r) }()
+	io.WriteString(w, "exit\n")
+
+	select {
+	case err := <-done:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		assert.Fail("multi session isn't over")
+	}
+}
+
+func TestMultiSession_ClosedBeforeRun(t *testing.T) {
+	assert := assert.New(t)
+
+	// the first session is over before the next one is added, Run waits for the rest of them.
+	closed := newMockShell("web-1")
+	io.WriteString(closed, "exit\n")
+	m := NewMultiSession(&syncBuffer{})
+	m.Add("web-1", closed)
+	time.Sleep(50 * time.Millisecond)
+	m.Add("web-2", newMockShell("web-2"))
+
+	r, w := io.Pipe()
+	defer w.Close()
+	done := make(chan error)
+	go func() { done <- m.Run(context.Background(), r) }()
+
+	select {
+	case <-done:
+		assert.Fail("multi session is over before every session is over")
+	case <-time.After(100 * time.Millisecond):
+	}
+	io.WriteString(w, "exit\n")
+	select {
+	case err := <-done:
+		assert.NoError(err)
+	case <-time.After(time.Second):
+		assert.Fail("multi session isn't over")
+	}
+}
+
+func TestMultiSession_Prompt(t *testing.T) {
+	assert := assert.New(t)
+
+	// prompt of shell doesn't end with a new line, so it is written after a while.
+	client, server := net.Pipe()
+	defer server.Close()
+	go io.WriteString(server, "web-1 $ ")
+
+	out := &syncBuffer{}
+	m := NewMultiSession(out)
+	m.Add("web-1", client)
+
+	r, w := io.Pipe()
+	defer w.Close()
+	go m.Run(context.Background(), r)
+	assert.Eventually(func() bool { return strings.Contains(out.String(), "[web-1] web-1 $ \n") }, time.Second, 5*time.Millisecond)
+}
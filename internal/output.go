@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/AlecAivazis/survey/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// OutputTable is colorized tables and text for people, it is the default.
+	OutputTable = "table"
+	// OutputJSON is indented json for scripts.
+	OutputJSON = "json"
+	// OutputYAML is yaml for scripts.
+	OutputYAML = "yaml"
+	// OutputCSV is csv for spreadsheets, it is supported by results which have rows such as list.
+	OutputCSV = "csv"
+)
+
+var (
+	// OutputFormats are formats of output.
+	OutputFormats = []string{OutputTable, OutputJSON, OutputYAML, OutputCSV}
+
+	// promptStdio writes prompts to stderr, so stdout has only output even if targets are selected interactively.
+	promptStdio = survey.WithStdio(os.Stdin, os.Stderr, os.Stderr)
+)
+
+// Rows is results which are written as csv, the first row is a header.
+type Rows interface {
+	Rows() [][]string
+}
+
+// Output writes results to w as a table for people, or as json and yaml for scripts.
+// Decorative text such as progress and warnings isn't output, it should be written to stderr.
+type Output struct {
+	Format string
+	w      io.Writer
+}
+
+// NewOutput returns an output which writes results to w in format, format is table if it is empty.
+func NewOutput(format string, w io.Writer) (*Output, error) {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if format == "" {
+		format = OutputTable
+	}
+	for _, f := range OutputFormats {
+		if f == format {
+			return &Output{Format: format, w: w}, nil
+		}
+	}
+	return nil, fmt.Errorf("[err] invalid output %s, such as %s", format, strings.Join(OutputFormats, ", "))
+}
+
+// Structured returns whether results are written for scripts, such as json, yaml and csv.
+func (o *Output) Structured() bool {
+	return o.Format != OutputTable
+}
+
+// Writer returns a writer of results.
+func (o *Output) Writer() io.Writer {
+	return o.w
+}
+
+// Write writes v as json or yaml by its json tags, and calls table instead if format is table.
+// v should implement Rows to be written as csv, and nothing is written as a table if table is nil.
+func (o *Output) Write(v interface{}, table func(w io.Writer)) error {
+	switch o.Format {
+	case OutputJSON:
+		encoder := json.NewEncoder(o.w)
+		encoder.SetIndent("", "  ")
+		return WrapError(encoder.Encode(v))
+	case OutputYAML:
+		data, err := marshalYAML(v)
+		if err != nil {
+			return WrapError(err)
+		}
+		_, err = o.w.Write(data)
+		return WrapError(err)
+	case OutputCSV:
+		rows, ok := v.(Rows)
+		if !ok {
+			return fmt.Errorf("[err] csv output isn't supported, use table, json or yaml")
+		}
+		writer := csv.NewWriter(o.w)
+		if err := writer.WriteAll(rows.Rows()); err != nil {
+			return WrapError(err)
+		}
+		return nil
+	default:
+		if table != nil {
+			table(o.w)
+		}
+		return nil
+	}
+}
+
+// marshalYAML marshals v by its json tags, so fields have the same names and order as json.
+func marshalYAML(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	node := &yaml.Node{}
+	if err := yaml.Unmarshal(data, node); err != nil {
+		return nil, err
+	}
+	// json is parsed as flow style and quoted strings of yaml, which are changed to plain block style.
+	clearStyle(node)
+
+	buf := &bytes.Buffer{}
+	encoder := yaml.NewEncoder(buf)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(node); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func clearStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		clearStyle(child)
+	}
+}
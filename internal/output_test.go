@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewOutput(t *testing.T) {
+	assert := assert.New(t)
+
+	out, err := NewOutput("", &bytes.Buffer{})
+	assert.NoError(err)
+	assert.Equal(OutputTable, out.Format)
+	assert.False(out.Structured())
+
+	out, err = NewOutput(" JSON ", &bytes.Buffer{})
+	assert.NoError(err)
+	assert.Equal(OutputJSON, out.Format)
+	assert.True(out.Structured())
+
+	_, err = NewOutput("xml", &bytes.Buffer{})
+	assert.Error(err)
+}
+
+func TestOutput_Write(t *testing.T) {
+	assert := assert.New(t)
+
+	results := []*CommandResult{
+		{CommandId: "c-1", InstanceId: "i-1", Status: "Success", Stdout: "ok\nfine\n"},
+		{CommandId: "c-1", InstanceId: "i-2", Status: "Failed", ExitCode: 1, Stderr: "true"},
+	}
+	table := func(w io.Writer) { fmt.Fprint(w, "table") }
+
+	tests := map[string]struct {
+		format string
+		output string
+	}{
+		"table": {format: OutputTable, output: "table"},
+		"json": {format: OutputJSON, output: `[
+  {
+    "commandId": "c-1",
+    "instanceId": "i-1",
+    "instanceName": "",
+    "status": "Success",
+    "exitCode": 0,
+    "stdout": "ok\nfine\n",
+    "stderr": "",
+    "truncated": false
+  },
+  {
+    "commandId": "c-1",
+    "instanceId": "i-2",
+    "instanceName": "",
+    "status": "Failed",
+    "exitCode": 1,
+    "stdout": "",
+    "stderr": "true",
+    "truncated": false
+  }
+]
+`},
+		// fields are in the same order as json, and strings which look like other types are quoted.
+		"yaml": {format: OutputYAML, output: `- commandId: c-1
+  instanceId: i-1
+  instanceName: ""
+  status: Success
+  exitCode: 0
+  stdout: |
+    ok
+    fine
+  stderr: ""
+  truncated: false
+- commandId: c-1
+  instanceId: i-2
+  instanceName: ""
+  status: Failed
+  exitCode: 1
+  stdout: ""
+  stderr: "true"
+  truncated: false
+`},
+	}
+	for name, tt := range tests {
+		buf := &bytes.Buffer{}
+		out, err := NewOutput(tt.format, buf)
+		assert.NoError(err, name)
+		assert.NoError(out.Write(results, table), name)
+		assert.Equal(tt.output, buf.String(), name)
+	}
+
+	// nothing is written as a table without table.
+	buf := &bytes.Buffer{}
+	out, _ := NewOutput(OutputTable, buf)
+	assert.NoError(out.Write(results, nil))
+	assert.Empty(buf.String())
+}
@@ -0,0 +1,436 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/AlecAivazis/survey/v2/terminal"
+	"github.com/fatih/color"
+	"golang.org/x/term"
+)
+
+const (
+	defaultPickerPageSize = 20
+
+	// scores of fuzzy matching, which are similar to fzf.
+	scoreMatch        = 16
+	scoreGapStart     = -3
+	scoreGapExtension = -1
+	bonusBoundary     = 8
+	bonusConsecutive  = 4
+	bonusFirstChar    = 8
+)
+
+var (
+	pickerHighlight = color.New(color.FgCyan, color.Bold)
+	pickerFocus     = color.New(color.FgGreen, color.Bold)
+)
+
+type (
+	// PickerItem is an item of Picker.
+	PickerItem struct {
+		// Label is shown, and matched runes in label are highlighted.
+		Label string
+		// Keywords are matched with query as well as label, but aren't shown.
+		Keywords []string
+		// Id identifies item when items are updated, label is used if it is empty.
+		Id string
+	}
+
+	// PickerMatch is an item matched with query.
+	PickerMatch struct {
+		Index     int
+		Score     int
+		Positions []int
+	}
+
+	// Picker selects items with fuzzy search, query is separated by space and every term must be matched.
+	Picker struct {
+		Message  string
+		Items    []PickerItem
+		Multi    bool
+		PageSize int
+		Stdio    terminal.Stdio
+		// Updates replaces items while picker is running, selected items are kept by id.
+		Updates <-chan []PickerItem
+	}
+)
+
+// NewPicker returns a picker which uses standard input and error, so stdout has only output.
+func NewPicker(message string, items []PickerItem, multi bool) *Picker {
+	return &Picker{
+		Message:  message,
+		Items:    items,
+		Multi:    multi,
+		PageSize: defaultPickerPageSize,
+		Stdio:    terminal.Stdio{In: os.Stdin, Out: os.Stderr, Err: os.Stderr},
+	}
+}
+
+// Match returns items matched with query, which are sorted by score.
+func (p *Picker) Match(query string) []*PickerMatch {
+	terms := strings.Fields(query)
+
+	var matches []*PickerMatch
+Items:
+	for i, item := range p.Items {
+		match := &PickerMatch{Index: i}
+		label := []rune(item.Label)
+		positions := map[int]bool{}
+		for _, t := range terms {
+			term := []rune(t)
+			best, ok := 0, false
+			if score, pos, matched := fuzzyMatch(term, label); matched {
+				best, ok = score, true
+				for _, p := range pos {
+					positions[p] = true
+				}
+			}
+			for _, keyword := range item.Keywords {
+				if score, _, matched := fuzzyMatch(term, []rune(keyword)); matched && (!ok || score > best) {
+					best, ok = score, true
+				}
+			}
+			if !ok {
+				continue Items
+			}
+			match.Score += best
+		}
+
+		for pos := range positions {
+			match.Positions = append(match.Positions, pos)
+		}
+		sort.Ints(match.Positions)
+		matches = append(matches, match)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].Score > matches[j].Score
+	})
+	return matches
+}
+
+// Run asks you which selects items, and returns indexes of selected items in Items when it is completed.
+// Arrow keys move, Tab toggles an item in multi selection, and Enter completes.
+func (p *Picker) Run(query string) ([]int, error) {
+	if len(p.Items) == 0 {
+		return nil, fmt.Errorf("[err] not found items")
+	}
+	pageSize := p.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPickerPageSize
+	}
+
+	rr := terminal.NewRuneReader(p.Stdio)
+	if err := rr.SetTermMode(); err != nil {
+		return nil, WrapError(err)
+	}
+	defer rr.RestoreTermMode()
+
+	cursor := &terminal.Cursor{In: p.Stdio.In, Out: p.Stdio.Out}
+	cursor.Hide()
+	defer cursor.Show()
+
+	var (
+		mu       sync.Mutex
+		finished bool
+		input    = []rune(query)
+		focus    = 0
+		selected = map[string]bool{}
+		matches  []*PickerMatch
+		drawn    = 0
+	)
+	width := 0
+	if w, _, err := term.GetSize(int(p.Stdio.Out.Fd())); err == nil {
+		width = w
+	}
+	erase := func() {
+		for i := 0; i < drawn; i++ {
+			cursor.PreviousLine(1)
+			terminal.EraseLine(p.Stdio.Out, terminal.ERASE_LINE_ALL)
+		}
+		drawn = 0
+	}
+	draw := func() {
+		matches = p.Match(string(input))
+		if focus >= len(matches) {
+			focus = len(matches) - 1
+		}
+		if focus < 0 {
+			focus = 0
+		}
+
+		erase()
+		lines := p.render(string(input), matches, focus, selected, pageSize, width)
+		for _, line := range lines {
+			fmt.Fprintln(p.Stdio.Out, line)
+		}
+		drawn = len(lines)
+	}
+	// finish must be called with lock, it prevents updates from drawing after picker is completed.
+	finish := func() {
+		finished = true
+		erase()
+	}
+
+	mu.Lock()
+	draw()
+	mu.Unlock()
+
+	if p.Updates != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case items, ok := <-p.Updates:
+					if !ok {
+						return
+					}
+					mu.Lock()
+					if !finished && len(items) > 0 {
+						p.Items = items
+						draw()
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for {
+		r, _, err := rr.ReadRune()
+
+		mu.Lock()
+		if err != nil {
+			finish()
+			mu.Unlock()
+			return nil, WrapError(err)
+		}
+
+		switch r {
+		case terminal.KeyInterrupt, terminal.KeyEscape, terminal.KeyEndTransmission:
+			finish()
+			mu.Unlock()
+			return nil, terminal.InterruptErr
+		case terminal.KeyEnter, '\n':
+			var indexes []int
+			if p.Multi {
+				for i, item := range p.Items {
+					if selected[item.key()] {
+						indexes = append(indexes, i)
+					}
+				}
+			}
+			if len(indexes) == 0 && len(matches) > 0 {
+				indexes = []int{matches[focus].Index}
+			}
+			if len(indexes) == 0 {
+				break
+			}
+
+			finish()
+			var labels []string
+			for _, i := range indexes {
+				labels = append(labels, strings.Join(strings.Fields(p.Items[i].Label), " "))
+			}
+			fmt.Fprintf(p.Stdio.Out, "%s %s %s\n", color.GreenString("?"), p.Message, color.CyanString(strings.Join(labels, ", ")))
+			mu.Unlock()
+			return indexes, nil
+		case terminal.KeyArrowUp:
+			focus--
+		case terminal.KeyArrowDown:
+			focus++
+		case terminal.KeyTab:
+			if p.Multi && len(matches) > 0 {
+				key := p.Items[matches[focus].Index].key()
+				selected[key] = !selected[key]
+				focus++
+			}
+		case terminal.KeyBackspace, terminal.KeyDelete:
+			if len(input) > 0 {
+				input = input[:len(input)-1]
+			}
+		case terminal.KeyDeleteWord:
+			input = []rune(strings.TrimRightFunc(string(input), unicode.IsSpace))
+			for len(input) > 0 && !unicode.IsSpace(input[len(input)-1]) {
+				input = input[:len(input)-1]
+			}
+		case terminal.KeyDeleteLine, '\x15': // Ctrl+X, Ctrl+U
+			input = input[:0]
+		default:
+			if unicode.IsPrint(r) {
+				input = append(input, r)
+				focus = 0
+			}
+		}
+		draw()
+		mu.Unlock()
+	}
+}
+
+// render returns lines of picker, which are prompt, a page of matched items and status.
+func (p *Picker) render(query string, matches []*PickerMatch, focus int, selected map[string]bool, pageSize, width int) []string {
+	var lines []string
+	help := "type to search"
+	if p.Multi {
+		help += ", tab to select"
+	}
+	lines = append(lines, fmt.Sprintf("%s %s [%s] %s", color.GreenString("?"), p.Message, help, query))
+
+	start := 0
+	if focus >= pageSize {
+		start = focus - pageSize + 1
+	}
+	end := start + pageSize
+	if end > len(matches) {
+		end = len(matches)
+	}
+
+	for i := start; i < end; i++ {
+		match := matches[i]
+		prefix := "  "
+		if i == focus {
+			prefix = pickerFocus.Sprint("> ")
+		}
+		if p.Multi {
+			if selected[p.Items[match.Index].key()] {
+				prefix += pickerFocus.Sprint("[x] ")
+			} else {
+				prefix += "[ ] "
+			}
+		}
+		lines = append(lines, prefix+highlight(p.Items[match.Index].Label, match.Positions, width-8))
+	}
+
+	lines = append(lines, color.New(color.Faint).Sprintf("  %d/%d", len(matches), len(p.Items)))
+	return lines
+}
+
+// key returns id of item, which is label if id is empty.
+func (i PickerItem) key() string {
+	if i.Id != "" {
+		return i.Id
+	}
+	return i.Label
+}
+
+// highlight colors runes of label at positions, label is truncated with max runes when max is positive.
+func highlight(label string, positions []int, max int) string {
+	runes := []rune(label)
+	if max > 0 && len(runes) > max {
+		runes = runes[:max]
+	}
+
+	marked := make(map[int]bool, len(positions))
+	for _, pos := range positions {
+		marked[pos] = true
+	}
+
+	var sb strings.Builder
+	for i, r := range runes {
+		if marked[i] {
+			sb.WriteString(pickerHighlight.Sprint(string(r)))
+		} else {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+// fuzzyMatch matches pattern with text case-insensitively like fzf, and returns the best score and matched positions.
+// From every occurrence of the first rune, it finds pattern as subsequence, and then shrinks it backward to prefer the shortest one.
+func fuzzyMatch(pattern, text []rune) (int, []int, bool) {
+	if len(pattern) == 0 {
+		return 0, nil, true
+	}
+
+	var (
+		bestScore     int
+		bestPositions []int
+	)
+	for start := 0; start < len(text); start++ {
+		if !equalFold(text[start], pattern[0]) {
+			continue
+		}
+		positions := matchFrom(pattern, text, start)
+		if positions == nil {
+			// pattern can't be matched after start, so next starts are not matched too.
+			break
+		}
+		if score := scorePositions(text, positions); bestPositions == nil || score > bestScore {
+			bestScore, bestPositions = score, positions
+		}
+	}
+	if bestPositions == nil {
+		return 0, nil, false
+	}
+	return bestScore, bestPositions, true
+}
+
+// matchFrom returns the shortest positions of pattern in text from start, and returns nil if it isn't matched.
+func matchFrom(pattern, text []rune, start int) []int {
+	// forward scan
+	pi, end := 0, -1
+	for ti := start; ti < len(text); ti++ {
+		if equalFold(text[ti], pattern[pi]) {
+			pi++
+			if pi == len(pattern) {
+				end = ti
+				break
+			}
+		}
+	}
+	if end == -1 {
+		return nil
+	}
+
+	// backward scan
+	positions := make([]int, len(pattern))
+	pi = len(pattern) - 1
+	for ti := end; ti >= start && pi >= 0; ti-- {
+		if equalFold(text[ti], pattern[pi]) {
+			positions[pi] = ti
+			pi--
+		}
+	}
+	return positions
+}
+
+// scorePositions scores matched positions, which gives bonus to boundary of words and consecutive runes.
+func scorePositions(text []rune, positions []int) int {
+	score := 0
+	for i, pos := range positions {
+		score += scoreMatch
+		if pos == 0 || isBoundary(text[pos-1]) {
+			score += bonusBoundary
+			if i == 0 {
+				score += bonusFirstChar
+			}
+		}
+		if i > 0 {
+			if gap := pos - positions[i-1] - 1; gap == 0 {
+				score += bonusConsecutive
+			} else {
+				score += scoreGapStart + scoreGapExtension*(gap-1)
+			}
+		}
+	}
+	return score
+}
+
+// equalFold returns whether runes are equal case-insensitively.
+func equalFold(a, b rune) bool {
+	return unicode.ToLower(a) == unicode.ToLower(b)
+}
+
+// isBoundary returns whether r separates words.
+func isBoundary(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("-_./:=,()[]", r)
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		pattern   string
+		text      string
+		positions []int
+		ok        bool
+	}{
+		"empty":       {pattern: "", text: "api", ok: true},
+		"prefix":      {pattern: "api", text: "api-server", positions: []int{0, 1, 2}, ok: true},
+		"subsequence": {pattern: "AS", text: "api-server", positions: []int{0, 4}, ok: true},
+		"shortest":    {pattern: "ab", text: "a-a-ab", positions: []int{4, 5}, ok: true},
+		"not matched": {pattern: "db", text: "api-server", ok: false},
+	}
+
+	for _, t := range tests {
+		_, positions, ok := fuzzyMatch([]rune(t.pattern), []rune(t.text))
+		assert.Equal(t.ok, ok)
+		assert.Equal(t.positions, positions)
+	}
+
+	// boundary and consecutive matches are preferred.
+	boundary, _, _ := fuzzyMatch([]rune("api"), []rune("prod-api"))
+	scattered, _, _ := fuzzyMatch([]rune("api"), []rune("xaxpxi"))
+	assert.Greater(boundary, scattered)
+}
+
+func TestPicker_Match(t *testing.T) {
+	assert := assert.New(t)
+
+	picker := NewPicker("Choose", []PickerItem{
+		{Label: "api       i-1  10.0.3.1", Keywords: []string{"Env=prod"}},
+		{Label: "api       i-2  10.0.4.2", Keywords: []string{"Env=dev"}},
+		{Label: "database  i-3  10.0.3.3", Keywords: []string{"Env=prod"}},
+		{Label: "rapid-io  i-4  10.0.5.4", Keywords: []string{"Env=prod"}},
+	}, false)
+
+	tests := map[string]struct {
+		query   string
+		indexes []int
+	}{
+		"all":        {query: "", indexes: []int{0, 1, 2, 3}},
+		"name":       {query: "api", indexes: []int{0, 1, 3}},
+		"multi term": {query: "prod api 10.0.3", indexes: []int{0}},
+		"keyword":    {query: "env=dev", indexes: []int{1}},
+		"none":       {query: "cache", indexes: nil},
+	}
+
+	for _, t := range tests {
+		var indexes []int
+		for _, m := range picker.Match(t.query) {
+			indexes = append(indexes, m.Index)
+		}
+		assert.Equal(t.indexes, indexes)
+	}
+
+	matches := picker.Match("api i-1")
+	assert.Equal([]int{0, 1, 2, 10, 11, 12}, matches[0].Positions)
+}
+
+func TestPicker_render(t *testing.T) {
+	assert := assert.New(t)
+	color.NoColor = true
+
+	picker := NewPicker("Choose", []PickerItem{{Label: "api i-1"}, {Label: "db i-2"}, {Label: "web i-3"}}, true)
+	lines := picker.render("i", picker.Match("i"), 1, map[string]bool{"api i-1": true}, 2, 0)
+	assert.Equal(4, len(lines))
+	assert.True(strings.HasSuffix(lines[0], "] i"))
+	assert.Equal("  [x] api i-1", lines[1])
+	assert.Equal("> [ ] db i-2", lines[2])
+	assert.Equal("  3/3", lines[3])
+
+	assert.Equal("abc", highlight("abcdef", []int{1}, 3))
+}
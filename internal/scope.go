@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/fatih/color"
+)
+
+const (
+	// DefaultScopeParallelism is the number of scopes which are found concurrently by default.
+	DefaultScopeParallelism = 8
+)
+
+type (
+	// Scope is a pair of profile and region where instances are found, Config has credentials of profile and region.
+	Scope struct {
+		Profile string
+		Account string
+		Config  aws.Config
+		// Cache is optional, instances are found without cache if it is nil.
+		Cache *TargetCache
+	}
+
+	// Scopes are found concurrently with bounded parallelism.
+	Scopes struct {
+		Items       []*Scope
+		Parallelism int
+	}
+)
+
+// NewScopes returns scopes which are found with default parallelism.
+func NewScopes(items ...*Scope) *Scopes {
+	return &Scopes{Items: items, Parallelism: DefaultScopeParallelism}
+}
+
+// Region returns region of scope.
+func (s *Scope) Region() string {
+	return s.Config.Region
+}
+
+// String returns profile and region of scope, such as prod/us-east-1.
+func (s *Scope) String() string {
+	return s.Profile + "/" + s.Region()
+}
+
+// Find returns a scope where target is found, it returns nil if target doesn't belong to any scope.
+func (s *Scopes) Find(target *Target) *Scope {
+	for _, scope := range s.Items {
+		if scope.Profile == target.Profile && scope.Region() == target.Region {
+			return scope
+		}
+	}
+	return nil
+}
+
+// Count returns the number of distinct profiles and regions in scopes.
+func (s *Scopes) Count() (profiles int, regions int) {
+	p, r := map[string]bool{}, map[string]bool{}
+	for _, scope := range s.Items {
+		p[scope.Profile] = true
+		r[scope.Region()] = true
+	}
+	return len(p), len(r)
+}
+
+// FindInstancesInScopes finds instances in every scope concurrently, and merges them with profile, account and region.
+// Cached instances are used unless refresh is true, and it returns whether any of them are stale.
+// A scope which fails is skipped with warning, and it returns an error only if every scope fails.
+func FindInstancesInScopes(ctx context.Context, scopes *Scopes, filter *TargetFilter, refresh bool) (map[string]*Target, bool, error) {
+	table, stale, errs := findInstancesInScopes(ctx, scopes, filter, refresh)
+	if len(errs) > 0 && len(errs) == len(scopes.Items) {
+		return nil, false, fmt.Errorf("[err] failed to find instances, %s", strings.Join(errs, ", "))
+	}
+	for _, e := range errs {
+		fmt.Fprintln(color.Output, color.YellowString("[Warning] failed to find instances in %s", e))
+	}
+	return table, stale, nil
+}
+
+// findInstancesInScopes returns merged instances of scopes, and errors of scopes which fail.
+func findInstancesInScopes(ctx context.Context, scopes *Scopes, filter *TargetFilter, refresh bool) (map[string]*Target, bool, []string) {
+	parallelism := scopes.Parallelism
+	if parallelism <= 0 {
+		parallelism = DefaultScopeParallelism
+	}
+
+	type result struct {
+		table map[string]*Target
+		stale bool
+		err   error
+	}
+	var (
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, parallelism)
+		results = make([]result, len(scopes.Items))
+	)
+	for i, scope := range scopes.Items {
+		wg.Add(1)
+		go func(i int, scope *Scope) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var r result
+			if refresh {
+				r.table, r.err = RefreshInstances(ctx, scope.Config, filter, scope.Cache)
+			} else {
+				r.table, r.stale, r.err = FindInstancesWithCache(ctx, scope.Config, filter, scope.Cache)
+			}
+			results[i] = r
+		}(i, scope)
+	}
+	wg.Wait()
+
+	var (
+		merged = map[string]*Target{}
+		stale  bool
+		errs   []string
+	)
+	for i, r := range results {
+		scope := scopes.Items[i]
+		if r.err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", scope, r.err.Error()))
+			continue
+		}
+		stale = stale || r.stale
+		for id, t := range r.table {
+			// the same account can be found by multiple profiles, the first one is used.
+			if _, ok := merged[id]; ok {
+				continue
+			}
+			t.Profile, t.Account, t.Region = scope.Profile, scope.Account, scope.Region()
+			merged[id] = t
+		}
+	}
+	return merged, stale, errs
+}
+
+// FindRegions returns regions which are enabled in account, such as opted-in regions.
+func FindRegions(ctx context.Context, cfg aws.Config) ([]string, error) {
+	output, err := ec2.NewFromConfig(cfg).DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	regions := make([]string, 0, len(output.Regions))
+	for _, region := range output.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	sort.Strings(regions)
+	return regions, nil
+}
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindInstancesInScopes(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		region := strings.Trim(r.URL.Path, "/")
+		if region == "eu-west-1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		fmt.Fprintf(w, `{"InstanceInformationList":[{"InstanceId":"mi-%s","ResourceType":"ManagedInstance","PingStatus":"Online","ComputerName":"edge-%s"}]}`, region, region)
+	}))
+	defer server.Close()
+
+	scope := func(profile, account, region string) *Scope {
+		// endpoint has region as path, so server responds instances per region.
+		cfg := newMockConfig(server.URL + "/" + region)
+		cfg.Region = region
+		// retries of forbidden errors aren't needed.
+		cfg.RetryMaxAttempts = 1
+		return &Scope{Profile: profile, Account: account, Config: cfg}
+	}
+
+	scopes := NewScopes(scope("dev", "1", "us-east-1"), scope("prod", "2", "us-west-2"), scope("prod", "2", "eu-west-1"))
+	scopes.Parallelism = 2
+	table, stale, err := FindInstancesInScopes(context.Background(), scopes, nil, false)
+	assert.NoError(err)
+	assert.False(stale)
+	assert.Len(table, 2)
+	assert.Equal("dev", table["mi-us-east-1"].Profile)
+	assert.Equal("1", table["mi-us-east-1"].Account)
+	assert.Equal("us-east-1", table["mi-us-east-1"].Region)
+	assert.Equal("prod", table["mi-us-west-2"].Profile)
+	assert.Equal("us-west-2", table["mi-us-west-2"].Region)
+
+	assert.Equal(scopes.Items[1], scopes.Find(table["mi-us-west-2"]))
+	assert.Nil(scopes.Find(&Target{Profile: "dev", Region: "eu-west-1"}))
+	profiles, regions := scopes.Count()
+	assert.Equal(2, profiles)
+	assert.Equal(3, regions)
+
+	// it fails only if every scope fails.
+	_, _, err = FindInstancesInScopes(context.Background(), NewScopes(scope("prod", "2", "eu-west-1")), nil, true)
+	assert.Error(err)
+}
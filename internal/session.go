@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/fatih/color"
+	"github.com/gjbae1212/gossm/internal/datachannel"
+	"github.com/xtaci/smux"
+	"golang.org/x/term"
+)
+
+const (
+	terminalSizeInterval = 500 * time.Millisecond
+)
+
+// OpenDataChannel opens a data channel of the started session.
+func OpenDataChannel(ctx context.Context, session *ssm.StartSessionOutput) (*datachannel.DataChannel, error) {
+	if ctx == nil || session == nil || session.StreamUrl == nil || session.TokenValue == nil {
+		return nil, WrapError(ErrInvalidParams)
+	}
+
+	dc := datachannel.New(aws.ToString(session.StreamUrl), aws.ToString(session.TokenValue))
+	if err := dc.Open(ctx); err != nil {
+		return nil, WrapError(err)
+	}
+	return dc, nil
+}
+
+// sessionStream is a data channel of session, and session is deleted when it is closed.
+type sessionStream struct {
+	*datachannel.DataChannel
+	cfg       aws.Config
+	sessionId *string
+	once      sync.Once
+}
+
+// OpenSessionStream starts a session with input and opens its data channel, session is deleted when stream is closed.
+func OpenSessionStream(ctx context.Context, cfg aws.Config, input *ssm.StartSessionInput) (io.ReadWriteCloser, error) {
+	session, err := CreateStartSession(ctx, cfg, input)
+	if err != nil {
+		return nil, err
+	}
+
+	dc, err := OpenDataChannel(ctx, session)
+	if err != nil {
+		DeleteStartSession(context.Background(), cfg, &ssm.TerminateSessionInput{SessionId: session.SessionId})
+		return nil, err
+	}
+	return &sessionStream{DataChannel: dc, cfg: cfg, sessionId: session.SessionId}, nil
+}
+
+// Close closes data channel and deletes session.
+func (s *sessionStream) Close() error {
+	var err error
+	s.once.Do(func() {
+		s.DataChannel.Close()
+		err = DeleteStartSession(context.Background(), s.cfg, &ssm.TerminateSessionInput{SessionId: s.sessionId})
+	})
+	return err
+}
+
+// StartShellSession connects current terminal to the started session.
+func StartShellSession(ctx context.Context, session *ssm.StartSessionOutput) error {
+	dc, err := OpenDataChannel(ctx, session)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+
+	// raw mode passes every key, such as ctrl+c, to remote shell.
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return WrapError(err)
+		}
+		defer term.Restore(fd, state)
+		go watchTerminalSize(dc, int(os.Stdout.Fd()))
+	}
+
+	go func() {
+		io.Copy(dc, os.Stdin)
+	}()
+
+	if _, err := io.Copy(os.Stdout, dc); err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// StartStreamSession relays reader and writer with the started session, such as stdin and stdout of ProxyCommand.
+func StartStreamSession(ctx context.Context, session *ssm.StartSessionOutput, r io.Reader, w io.Writer) error {
+	dc, err := OpenDataChannel(ctx, session)
+	if err != nil {
+		return err
+	}
+	defer dc.Close()
+
+	go func() {
+		io.Copy(dc, r)
+		dc.Close()
+	}()
+
+	if _, err := io.Copy(w, dc); err != nil {
+		return WrapError(err)
+	}
+	return nil
+}
+
+// StartPortForwardingSession listens local port and relays its connections with the started session.
+func StartPortForwardingSession(ctx context.Context, session *ssm.StartSessionOutput, localPort string) error {
+	listener, err := ListenPort(localPort)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+	return listener.Serve(ctx, session)
+}
+
+// PortListener accepts connections of local port, it is kept while sessions are started again.
+type PortListener struct {
+	// PingInterval is an interval of pings which detect a lost session. (default is datachannel.DefaultPingInterval)
+	PingInterval time.Duration
+
+	listener net.Listener
+	conns    chan net.Conn
+	err      error
+	done     chan struct{}
+	once     sync.Once
+}
+
+// ListenPort listens local port, and accepts connections until it is closed.
+func ListenPort(localPort string) (*PortListener, error) {
+	listener, err := net.Listen("tcp", net.JoinHostPort("localhost", localPort))
+	if err != nil {
+		return nil, WrapError(err)
+	}
+
+	l := &PortListener{listener: listener, conns: make(chan net.Conn), done: make(chan struct{})}
+	go func() {
+		defer close(l.conns)
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				l.err = err
+				return
+			}
+			select {
+			case l.conns <- conn:
+			case <-l.done:
+				conn.Close()
+				return
+			}
+		}
+	}()
+	return l, nil
+}
+
+// Port returns local port of listener.
+func (l *PortListener) Port() string {
+	_, port, err := net.SplitHostPort(l.listener.Addr().String())
+	if err != nil {
+		return l.listener.Addr().String()
+	}
+	return port
+}
+
+// Close stops accepting connections.
+func (l *PortListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.listener.Close()
+}
+
+// Serve relays connections with the started session until ctx is done or session is over.
+// Connections are multiplexed in a session if the agent supports it, otherwise a session relays only one connection at once.
+// Connections which are accepted while no session is served wait for next session.
+func (l *PortListener) Serve(ctx context.Context, session *ssm.StartSessionOutput) error {
+	if ctx == nil || session == nil || session.StreamUrl == nil || session.TokenValue == nil {
+		return WrapError(ErrInvalidParams)
+	}
+
+	dc := datachannel.New(aws.ToString(session.StreamUrl), aws.ToString(session.TokenValue))
+	dc.SetPingInterval(l.PingInterval)
+	dc.SetClientVersion(datachannel.MuxClientVersion)
+	if err := dc.Open(ctx); err != nil {
+		return WrapError(err)
+	}
+	defer dc.Close()
+
+	fmt.Fprintf(color.Output, "%s %s\n", color.GreenString("Port %s opened for session", l.Port()),
+		color.YellowString(aws.ToString(session.SessionId)))
+
+	// close data channel when ctx is done, so that current connection is closed by relay.
+	go func() {
+		select {
+		case <-ctx.Done():
+			dc.Close()
+		case <-dc.Done():
+		}
+	}()
+
+	// version of the agent is notified in handshake, which decides how connections are relayed.
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-dc.Done():
+		return WrapError(dc.Err())
+	case <-dc.Ready():
+	}
+	if datachannel.SupportsMux(dc.AgentVersion()) {
+		return l.serveMux(ctx, dc)
+	}
+	return l.serveBasic(ctx, dc)
+}
+
+// serveMux opens a stream of smux per connection, so connections are relayed concurrently in a session.
+func (l *PortListener) serveMux(ctx context.Context, dc *datachannel.DataChannel) error {
+	config := smux.DefaultConfig()
+	config.KeepAliveDisabled = !datachannel.SupportsSmuxKeepAlive(dc.AgentVersion())
+	mux, err := smux.Client(dc, config)
+	if err != nil {
+		return WrapError(err)
+	}
+	defer mux.Close()
+
+	for {
+		var conn net.Conn
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-dc.Done():
+			return WrapError(dc.Err())
+		case <-mux.CloseChan():
+			if ctx.Err() != nil {
+				return nil
+			}
+			return WrapError(fmt.Errorf("[err] multiplexed session is closed"))
+		case c, ok := <-l.conns:
+			if !ok {
+				return WrapError(l.err)
+			}
+			conn = c
+		}
+		fmt.Fprintf(color.Output, "%s %s\n", color.GreenString("Connection accepted from"), color.YellowString(conn.RemoteAddr().String()))
+
+		stream, err := mux.OpenStream()
+		if err != nil {
+			conn.Close()
+			return WrapError(err)
+		}
+		go relayStream(conn, stream)
+	}
+}
+
+// relayStream relays a connection with a stream of smux, and closes both when either is over.
+func relayStream(conn net.Conn, stream *smux.Stream) {
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			conn.Close()
+			stream.Close()
+		})
+	}
+	go func() {
+		io.Copy(stream, conn)
+		closeBoth()
+	}()
+	io.Copy(conn, stream)
+	closeBoth()
+}
+
+// serveBasic relays connections one by one, the agent reconnects to port for next connection.
+func (l *PortListener) serveBasic(ctx context.Context, dc *datachannel.DataChannel) error {
+	relay := &portRelay{}
+	go relay.output(dc)
+
+	for {
+		var conn net.Conn
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-dc.Done():
+			return WrapError(dc.Err())
+		case c, ok := <-l.conns:
+			if !ok {
+				return WrapError(l.err)
+			}
+			conn = c
+		}
+		fmt.Fprintf(color.Output, "%s %s\n", color.GreenString("Connection accepted from"), color.YellowString(conn.RemoteAddr().String()))
+
+		relay.set(conn)
+		io.Copy(dc, conn)
+		relay.set(nil)
+		conn.Close()
+
+		if ctx.Err() != nil {
+			return nil
+		}
+		select {
+		case <-dc.Done():
+			return WrapError(dc.Err())
+		default:
+		}
+
+		// notify the agent that current connection is over, and then it reconnects for next connection.
+		if err := dc.SendFlag(datachannel.DisconnectToPort); err != nil {
+			return WrapError(err)
+		}
+	}
+}
+
+// portRelay writes output of a session to the current connection.
+type portRelay struct {
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (r *portRelay) set(conn net.Conn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.conn = conn
+}
+
+func (r *portRelay) output(dc *datachannel.DataChannel) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := dc.Read(buf)
+		if n > 0 {
+			r.mu.Lock()
+			if r.conn != nil {
+				r.conn.Write(buf[:n])
+			}
+			r.mu.Unlock()
+		}
+		if err != nil {
+			r.mu.Lock()
+			if r.conn != nil {
+				r.conn.Close()
+			}
+			r.mu.Unlock()
+			return
+		}
+	}
+}
+
+// watchTerminalSize notifies size of terminal whenever it is changed.
+func watchTerminalSize(dc *datachannel.DataChannel, fd int) {
+	var cols, rows int
+	ticker := time.NewTicker(terminalSizeInterval)
+	defer ticker.Stop()
+
+	for {
+		if width, height, err := term.GetSize(fd); err == nil && (width != cols || height != rows) {
+			if err := dc.SetSize(uint32(width), uint32(height)); err != nil {
+				return
+			}
+			cols, rows = width, height
+		}
+
+		select {
+		case <-dc.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
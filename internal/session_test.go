@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/gjbae1212/gossm/internal/datachannel"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/xtaci/smux"
+)
+
+func TestOpenDataChannel(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		ctx     context.Context
+		session *ssm.StartSessionOutput
+		isErr   bool
+	}{
+		"empty":   {ctx: context.Background(), isErr: true},
+		"no-ctx":  {session: &ssm.StartSessionOutput{StreamUrl: aws.String("ws://localhost:0"), TokenValue: aws.String("token")}, isErr: true},
+		"no-url":  {ctx: context.Background(), session: &ssm.StartSessionOutput{TokenValue: aws.String("token")}, isErr: true},
+		"refused": {ctx: context.Background(), session: &ssm.StartSessionOutput{StreamUrl: aws.String("ws://localhost:0"), TokenValue: aws.String("token")}, isErr: true},
+	}
+
+	for _, t := range tests {
+		_, err := OpenDataChannel(t.ctx, t.session)
+		assert.Equal(t.isErr, err != nil)
+	}
+}
+
+// newMuxAgent returns a stream of mock agent which multiplexes connections with smux, and echoes every stream.
+func newMuxAgent(t *testing.T, agentVersion string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+
+		var (
+			mu  sync.Mutex
+			seq int64
+		)
+		write := func(messageType string, payloadType datachannel.PayloadType, payload []byte) {
+			mu.Lock()
+			defer mu.Unlock()
+			msg := &datachannel.Message{MessageType: messageType, SchemaVersion: 1, MessageId: uuid.New(), PayloadType: payloadType, Payload: payload}
+			if messageType == datachannel.OutputStreamMessage {
+				msg.SequenceNumber = seq
+				seq++
+			}
+			data, _ := msg.MarshalBinary()
+			conn.WriteMessage(websocket.BinaryMessage, data)
+		}
+		write(datachannel.OutputStreamMessage, datachannel.HandshakeRequest, []byte(fmt.Sprintf(`{"AgentVersion":"%s","RequestedClientActions":[
+			{"ActionType":"SessionType","ActionParameters":{"SessionType":"Port","Properties":{"portNumber":"80","type":"LocalPortForwarding"}}}]}`, agentVersion)))
+
+		// payloads of client are streams of smux, and streams are echoed.
+		agentConn, clientConn := net.Pipe()
+		defer agentConn.Close()
+		go func() {
+			mux, err := smux.Server(agentConn, smux.DefaultConfig())
+			if err != nil {
+				return
+			}
+			for {
+				stream, err := mux.AcceptStream()
+				if err != nil {
+					return
+				}
+				go io.Copy(stream, stream)
+			}
+		}()
+		go func() {
+			buf := make([]byte, 1024)
+			for {
+				n, err := clientConn.Read(buf)
+				if err != nil {
+					return
+				}
+				write(datachannel.OutputStreamMessage, datachannel.Output, append([]byte(nil), buf[:n]...))
+			}
+		}()
+
+		var expected int64
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			msg := &datachannel.Message{}
+			if err := msg.UnmarshalBinary(data); err != nil || msg.MessageType != datachannel.InputStreamMessage {
+				continue
+			}
+			write(datachannel.AcknowledgeMessage, 0, []byte(fmt.Sprintf(`{"AcknowledgedMessageType":"%s","AcknowledgedMessageId":"%s","AcknowledgedMessageSequenceNumber":%d,"IsSequentialMessage":true}`,
+				msg.MessageType, msg.MessageId, msg.SequenceNumber)))
+			if msg.SequenceNumber != expected {
+				continue
+			}
+			expected++
+
+			switch msg.PayloadType {
+			case datachannel.HandshakeResponse:
+				write(datachannel.OutputStreamMessage, datachannel.HandshakeComplete, []byte(`{}`))
+			case datachannel.Output:
+				clientConn.Write(msg.Payload)
+			}
+		}
+	}))
+}
+
+func TestPortListener_ServeMux(t *testing.T) {
+	assert := assert.New(t)
+
+	server := newMuxAgent(t, "3.1.1511.0")
+	defer server.Close()
+
+	listener, err := ListenPort("0")
+	assert.NoError(err)
+	defer listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- listener.Serve(ctx, &ssm.StartSessionOutput{
+			SessionId:  aws.String("s-1"),
+			StreamUrl:  aws.String("ws" + strings.TrimPrefix(server.URL, "http")),
+			TokenValue: aws.String("token"),
+		})
+	}()
+
+	// connections are relayed concurrently in a session, the first one is still open while the second one is used.
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", net.JoinHostPort("localhost", listener.Port()))
+		assert.NoError(err)
+		defer conn.Close()
+		conns = append(conns, conn)
+	}
+	for i := len(conns) - 1; i >= 0; i-- {
+		conns[i].SetDeadline(time.Now().Add(5 * time.Second))
+		message := fmt.Sprintf("hello %d", i)
+		_, err := conns[i].Write([]byte(message))
+		assert.NoError(err)
+		buf := make([]byte, len(message))
+		_, err = io.ReadFull(conns[i], buf)
+		assert.NoError(err)
+		assert.Equal(message, string(buf))
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("serve isn't stopped")
+	}
+}
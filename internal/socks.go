@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/fatih/color"
+)
+
+// SOCKS5 protocol, https://datatracker.ietf.org/doc/html/rfc1928
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCommandConnect = 0x01
+
+	socksAddressIPv4   = 0x01
+	socksAddressDomain = 0x03
+	socksAddressIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddressNotSupported = 0x08
+)
+
+// SocksServer is a SOCKS5 server which supports CONNECT without authentication.
+type SocksServer struct {
+	// Dial opens a stream to host and port.
+	Dial func(ctx context.Context, host, port string) (io.ReadWriteCloser, error)
+	// Log writes a line per connection, it is ignored if nil.
+	Log io.Writer
+
+	// logMu serializes lines of connections which are handled concurrently.
+	logMu sync.Mutex
+}
+
+// SocksSession is a SOCKS5 server through target, which is written as output when it is listening.
+type SocksSession struct {
+	Listen string `json:"listen"`
+	Target string `json:"target"`
+	Region string `json:"region"`
+}
+
+// NewSSMSocksServer returns a SOCKS5 server, which opens AWS-StartPortForwardingSessionToRemoteHost through target per CONNECT.
+func NewSSMSocksServer(cfg aws.Config, target string) *SocksServer {
+	return &SocksServer{
+		Dial: func(ctx context.Context, host, port string) (io.ReadWriteCloser, error) {
+			return openRemoteHostStream(ctx, cfg, target, host, port)
+		},
+	}
+}
+
+// Serve accepts connections of listener until ctx is done, and closes listener when it is over.
+func (s *SocksServer) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return WrapError(err)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.handle(ctx, conn)
+		}()
+	}
+}
+
+// handle negotiates SOCKS5 with client, and then relays connection with a stream to requested host and port.
+// Connection and stream are closed when ctx is done, so that Serve doesn't wait for clients which are still open.
+func (s *SocksServer) handle(ctx context.Context, conn net.Conn) {
+	done := make(chan struct{})
+	defer close(done)
+	defer conn.Close()
+	closeOnDone(ctx, done, conn)
+
+	host, port, err := s.negotiate(conn)
+	if err != nil {
+		s.logf(color.RedString("[err] %s %v", conn.RemoteAddr(), err))
+		return
+	}
+	address := net.JoinHostPort(host, port)
+
+	stream, err := s.Dial(ctx, host, port)
+	if err != nil {
+		writeSocksReply(conn, socksReplyHostUnreachable)
+		s.logf(color.RedString("[err] %s -> %s %v", conn.RemoteAddr(), address, err))
+		return
+	}
+	defer stream.Close()
+	closeOnDone(ctx, done, stream)
+
+	if err := writeSocksReply(conn, socksReplySucceeded); err != nil {
+		return
+	}
+	s.logf(color.GreenString("[connect] %s -> %s", conn.RemoteAddr(), address))
+
+	// stream is closed when client is over, and client is closed when stream is over.
+	go func() {
+		io.Copy(stream, conn)
+		stream.Close()
+	}()
+	io.Copy(conn, stream)
+}
+
+// negotiate reads greeting and request of client, and returns requested host and port.
+func (s *SocksServer) negotiate(conn net.Conn) (string, string, error) {
+	// greeting: version, the number of methods, methods
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", "", err
+	}
+	if header[0] != socksVersion {
+		return "", "", fmt.Errorf("[err] unsupported socks version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", "", err
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", "", err
+	}
+	if method == socksMethodNoAcceptable {
+		return "", "", fmt.Errorf("[err] not found acceptable authentication method")
+	}
+
+	// request: version, command, reserved, address type, address, port
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil {
+		return "", "", err
+	}
+	if request[0] != socksVersion {
+		return "", "", fmt.Errorf("[err] unsupported socks version %d", request[0])
+	}
+	if request[1] != socksCommandConnect {
+		writeSocksReply(conn, socksReplyCommandNotSupported)
+		return "", "", fmt.Errorf("[err] unsupported socks command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddressIPv4, socksAddressIPv6:
+		size := net.IPv4len
+		if request[3] == socksAddressIPv6 {
+			size = net.IPv6len
+		}
+		ip := make([]byte, size)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", "", err
+		}
+		host = net.IP(ip).String()
+	case socksAddressDomain:
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(conn, size); err != nil {
+			return "", "", err
+		}
+		domain := make([]byte, size[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", "", err
+		}
+		host = string(domain)
+	default:
+		writeSocksReply(conn, socksReplyAddressNotSupported)
+		return "", "", fmt.Errorf("[err] unsupported socks address type %d", request[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", "", err
+	}
+	return host, strconv.Itoa(int(binary.BigEndian.Uint16(port))), nil
+}
+
+// closeOnDone closes c when ctx is done before done is closed.
+func closeOnDone(ctx context.Context, done <-chan struct{}, c io.Closer) {
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-done:
+		}
+	}()
+}
+
+func (s *SocksServer) logf(line string) {
+	if s.Log != nil {
+		s.logMu.Lock()
+		defer s.logMu.Unlock()
+		fmt.Fprintln(s.Log, line)
+	}
+}
+
+// writeSocksReply writes reply with an empty bound address, because clients don't use it with CONNECT.
+func writeSocksReply(conn net.Conn, reply byte) error {
+	_, err := conn.Write([]byte{socksVersion, reply, 0x00, socksAddressIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// openRemoteHostStream starts AWS-StartPortForwardingSessionToRemoteHost through target, and opens its data channel.
+func openRemoteHostStream(ctx context.Context, cfg aws.Config, target, host, port string) (io.ReadWriteCloser, error) {
+	return OpenSessionStream(ctx, cfg, (&Forward{Host: host, RemotePort: port}).StartSessionInput(target))
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// socksConnect sends greeting and CONNECT of domain to a SOCKS5 server, and returns reply code.
+func socksConnect(conn net.Conn, host string, port int) (byte, error) {
+	if _, err := conn.Write([]byte{0x05, 0x01, 0x00}); err != nil {
+		return 0, err
+	}
+	greeting := make([]byte, 2)
+	if _, err := io.ReadFull(conn, greeting); err != nil {
+		return 0, err
+	}
+
+	request := append([]byte{0x05, 0x01, 0x00, 0x03, byte(len(host))}, host...)
+	request = append(request, 0, 0)
+	binary.BigEndian.PutUint16(request[len(request)-2:], uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return 0, err
+	}
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return 0, err
+	}
+	return reply[1], nil
+}
+
+func newEchoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(t, err)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestSocksServer(t *testing.T) {
+	assert := assert.New(t)
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	var (
+		mu     sync.Mutex
+		dialed []string
+	)
+	server := &SocksServer{
+		Dial: func(ctx context.Context, host, port string) (io.ReadWriteCloser, error) {
+			mu.Lock()
+			dialed = append(dialed, net.JoinHostPort(host, port))
+			mu.Unlock()
+			// every host is relayed to echo server, such as a remote host through bastion.
+			return net.Dial("tcp", echo.Addr().String())
+		},
+		Log: &bytes.Buffer{},
+	}
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Serve(ctx, listener) }()
+
+	for _, host := range []string{"db.internal", "redis.internal"} {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		assert.NoError(err)
+		port, _ := strconv.Atoi(echoPort)
+		reply, err := socksConnect(conn, host, port)
+		assert.NoError(err)
+		assert.Equal(byte(socksReplySucceeded), reply)
+
+		_, err = conn.Write([]byte("hello " + host))
+		assert.NoError(err)
+		buf := make([]byte, len("hello "+host))
+		_, err = io.ReadFull(conn, buf)
+		assert.NoError(err)
+		assert.Equal("hello "+host, string(buf))
+		conn.Close()
+	}
+	assert.Equal([]string{"db.internal:" + echoPort, "redis.internal:" + echoPort}, dialed)
+
+	// unsupported command, such as BIND, is rejected.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(err)
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	io.ReadFull(conn, make([]byte, 2))
+	conn.Write([]byte{0x05, 0x02, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+	reply := make([]byte, 10)
+	_, err = io.ReadFull(conn, reply)
+	assert.NoError(err)
+	assert.Equal(byte(socksReplyCommandNotSupported), reply[1])
+	conn.Close()
+
+	// request of other version is rejected without reply.
+	conn, err = net.Dial("tcp", listener.Addr().String())
+	assert.NoError(err)
+	conn.Write([]byte{0x05, 0x01, 0x00})
+	io.ReadFull(conn, make([]byte, 2))
+	conn.Write([]byte{0x04, 0x01, 0x00, 0x01, 127, 0, 0, 1, 0, 80})
+	_, err = io.ReadFull(conn, reply)
+	assert.Error(err)
+	conn.Close()
+
+	cancel()
+	assert.NoError(<-done)
+}
+
+func TestSocksServer_Cancel(t *testing.T) {
+	assert := assert.New(t)
+
+	echo := newEchoServer(t)
+	defer echo.Close()
+	_, echoPort, _ := net.SplitHostPort(echo.Addr().String())
+
+	server := &SocksServer{
+		Dial: func(ctx context.Context, host, port string) (io.ReadWriteCloser, error) {
+			return net.Dial("tcp", echo.Addr().String())
+		},
+	}
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Serve(ctx, listener) }()
+
+	// client keeps its connection open while relay is active.
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(err)
+	defer conn.Close()
+	port, _ := strconv.Atoi(echoPort)
+	reply, err := socksConnect(conn, "db.internal", port)
+	assert.NoError(err)
+	assert.Equal(byte(socksReplySucceeded), reply)
+	_, err = conn.Write([]byte("ping"))
+	assert.NoError(err)
+	_, err = io.ReadFull(conn, make([]byte, 4))
+	assert.NoError(err)
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(err)
+	case <-time.After(5 * time.Second):
+		assert.Fail("serve isn't stopped while relay is active")
+	}
+
+}
+
+func TestNewSSMSocksServer(t *testing.T) {
+	assert := assert.New(t)
+
+	var (
+		mu     sync.Mutex
+		inputs []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.StartSession" {
+			input := map[string]interface{}{}
+			json.NewDecoder(r.Body).Decode(&input)
+			mu.Lock()
+			inputs = append(inputs, input)
+			mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"__type":"TargetNotConnected","message":"target is not connected"}`))
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	assert.NoError(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	socks := NewSSMSocksServer(newMockConfig(server.URL), "i-1")
+	go socks.Serve(ctx, listener)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(err)
+	defer conn.Close()
+	reply, err := socksConnect(conn, "db.internal", 5432)
+	assert.NoError(err)
+	assert.Equal(byte(socksReplyHostUnreachable), reply)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Len(inputs, 1)
+	assert.Equal("AWS-StartPortForwardingSessionToRemoteHost", inputs[0]["DocumentName"])
+	assert.Equal("i-1", inputs[0]["Target"])
+	assert.Equal(map[string]interface{}{
+		"host":       []interface{}{"db.internal"},
+		"portNumber": []interface{}{"5432"},
+	}, inputs[0]["Parameters"])
+}
@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	sshConfigBeginMarker = "# BEGIN GOSSM MANAGED BLOCK"
+	sshConfigEndMarker   = "# END GOSSM MANAGED BLOCK"
+)
+
+// SSHConfigOption is an option for Host blocks of ssh config.
+type SSHConfigOption struct {
+	Profile      string
+	Region       string
+	User         string
+	IdentityFile string
+	ProxyCommand string
+}
+
+// GenerateSSHConfig generates managed block which has a Host block per instance.
+// A Host has aliases which are Name tag and instance id, Name tag is suffixed with instance id when it is duplicated.
+func GenerateSSHConfig(table map[string]*Target, opt *SSHConfigOption) string {
+	if opt == nil {
+		opt = &SSHConfigOption{}
+	}
+
+	names := map[string]int{}
+	for _, target := range table {
+		names[sshHostAlias(target.InstanceName)]++
+	}
+
+	var sb strings.Builder
+	sb.WriteString(sshConfigMarker(sshConfigBeginMarker, opt) + "\n")
+	sb.WriteString("# generated by gossm, don't edit this block. it is overwritten whenever gossm regenerates it.\n")
+	for _, target := range SortTargets(table) {
+		hosts := []string{target.Name}
+		if alias := sshHostAlias(target.InstanceName); alias != "" {
+			if names[alias] > 1 {
+				alias = fmt.Sprintf("%s-%s", alias, target.Name)
+			}
+			hosts = append([]string{alias}, hosts...)
+		}
+
+		sb.WriteString("\n")
+		sb.WriteString(fmt.Sprintf("Host %s\n", strings.Join(hosts, " ")))
+		sb.WriteString(fmt.Sprintf("  HostName %s\n", target.Name))
+		if opt.User != "" {
+			sb.WriteString(fmt.Sprintf("  User %s\n", opt.User))
+		}
+		if opt.IdentityFile != "" {
+			sb.WriteString(fmt.Sprintf("  IdentityFile %s\n", opt.IdentityFile))
+		}
+		if opt.ProxyCommand != "" {
+			sb.WriteString(fmt.Sprintf("  ProxyCommand %s\n", opt.ProxyCommand))
+		}
+	}
+	sb.WriteString(sshConfigMarker(sshConfigEndMarker, opt) + "\n")
+	return sb.String()
+}
+
+// MergeSSHConfig replaces managed block of the same profile and region in existing ssh config.
+// Lines outside managed block are preserved, and managed block is appended when it doesn't exist.
+func MergeSSHConfig(existing, block string, opt *SSHConfigOption) string {
+	if opt == nil {
+		opt = &SSHConfigOption{}
+	}
+	begin := sshConfigMarker(sshConfigBeginMarker, opt)
+	end := sshConfigMarker(sshConfigEndMarker, opt)
+
+	start := strings.Index(existing, begin)
+	if start != -1 {
+		if stop := strings.Index(existing[start:], end); stop != -1 {
+			stop = start + stop + len(end)
+			if stop < len(existing) && existing[stop] == '\n' {
+				stop++
+			}
+			return existing[:start] + block + existing[stop:]
+		}
+	}
+
+	if existing != "" && !strings.HasSuffix(existing, "\n") {
+		existing += "\n"
+	}
+	if existing != "" {
+		existing += "\n"
+	}
+	return existing + block
+}
+
+// WriteSSHConfig writes managed block into ssh config file, and returns whether file is changed.
+func WriteSSHConfig(path string, table map[string]*Target, opt *SSHConfigOption) (bool, error) {
+	existing, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return false, WrapError(err)
+	}
+
+	merged := MergeSSHConfig(string(existing), GenerateSSHConfig(table, opt), opt)
+	if merged == string(existing) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return false, WrapError(err)
+	}
+	if err := ioutil.WriteFile(path, []byte(merged), 0600); err != nil {
+		return false, WrapError(err)
+	}
+	return true, nil
+}
+
+func sshConfigMarker(marker string, opt *SSHConfigOption) string {
+	return fmt.Sprintf("%s (profile: %s, region: %s)", marker, opt.Profile, opt.Region)
+}
+
+// sshHostAlias returns Name tag which can be used as Host of ssh config.
+func sshHostAlias(name string) string {
+	return strings.Join(strings.Fields(name), "-")
+}
@@ -0,0 +1,93 @@
+package internal
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGenerateSSHConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		table    map[string]*Target
+		opt      *SSHConfigOption
+		contains []string
+	}{
+		"success": {
+			table: map[string]*Target{
+				"i-1": {Name: "i-1", InstanceName: "api server"},
+				"i-2": {Name: "i-2", InstanceName: "db"},
+				"i-3": {Name: "i-3", InstanceName: "db"},
+				"i-4": {Name: "i-4", InstanceName: ""},
+			},
+			opt: &SSHConfigOption{Profile: "default", Region: "us-east-1", User: "ec2-user",
+				IdentityFile: "~/.ssh/id_rsa", ProxyCommand: "gossm proxy %h %p"},
+			contains: []string{
+				"# BEGIN GOSSM MANAGED BLOCK (profile: default, region: us-east-1)",
+				"Host api-server i-1\n  HostName i-1\n  User ec2-user\n  IdentityFile ~/.ssh/id_rsa\n  ProxyCommand gossm proxy %h %p\n",
+				"Host db-i-2 i-2\n",
+				"Host db-i-3 i-3\n",
+				"Host i-4\n",
+				"# END GOSSM MANAGED BLOCK (profile: default, region: us-east-1)\n",
+			},
+		},
+		"empty": {
+			contains: []string{"# BEGIN GOSSM MANAGED BLOCK", "# END GOSSM MANAGED BLOCK"},
+		},
+	}
+
+	for _, t := range tests {
+		result := GenerateSSHConfig(t.table, t.opt)
+		for _, c := range t.contains {
+			assert.Contains(result, c)
+		}
+	}
+}
+
+func TestMergeSSHConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	opt := &SSHConfigOption{Profile: "default", Region: "us-east-1"}
+	other := &SSHConfigOption{Profile: "prod", Region: "us-east-1"}
+	oldBlock := GenerateSSHConfig(map[string]*Target{"i-0": {Name: "i-0", InstanceName: "old"}}, opt)
+	newBlock := GenerateSSHConfig(map[string]*Target{"i-1": {Name: "i-1", InstanceName: "new"}}, opt)
+	otherBlock := GenerateSSHConfig(map[string]*Target{"i-2": {Name: "i-2", InstanceName: "other"}}, other)
+
+	tests := map[string]struct {
+		existing string
+		output   string
+	}{
+		"empty":    {existing: "", output: newBlock},
+		"append":   {existing: "Host custom\n  User me", output: "Host custom\n  User me\n\n" + newBlock},
+		"replace":  {existing: "Host custom\n  User me\n\n" + oldBlock + "\nHost after\n", output: "Host custom\n  User me\n\n" + newBlock + "\nHost after\n"},
+		"multiple": {existing: otherBlock + "\n" + oldBlock, output: otherBlock + "\n" + newBlock},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, MergeSSHConfig(t.existing, newBlock, opt))
+	}
+}
+
+func TestWriteSSHConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "ssh", "gossm_config")
+	table := map[string]*Target{"i-1": {Name: "i-1", InstanceName: "api"}}
+	opt := &SSHConfigOption{Profile: "default", Region: "us-east-1"}
+
+	changed, err := WriteSSHConfig(path, table, opt)
+	assert.NoError(err)
+	assert.True(changed)
+
+	changed, err = WriteSSHConfig(path, table, opt)
+	assert.NoError(err)
+	assert.False(changed)
+
+	content, err := ioutil.ReadFile(path)
+	assert.NoError(err)
+	assert.True(strings.Contains(string(content), "Host api i-1"))
+}
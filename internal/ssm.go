@@ -43,9 +43,28 @@ var (
 
 type (
 	Target struct {
-		Name          string
-		PublicDomain  string
-		PrivateDomain string
+		// Name is instance id.
+		Name             string
+		InstanceName     string
+		PublicDomain     string
+		PrivateDomain    string
+		PublicIp         string
+		PrivateIp        string
+		InstanceType     string
+		AvailabilityZone string
+		Platform         string
+		PlatformName     string
+		PlatformVersion  string
+		LaunchTime       time.Time
+		PingStatus       string
+		AgentVersion     string
+		// ResourceType is EC2Instance or ManagedInstance, which is registered by hybrid activation.
+		ResourceType string
+		Tags         map[string]string
+		// Profile, Account and Region are scope where instance is found.
+		Profile string
+		Account string
+		Region  string
 	}
 
 	User struct {
@@ -68,7 +87,7 @@ func AskUser() (*User, error) {
 		Message: "Type your connect ssh user (default: root):",
 	}
 	var user string
-	survey.AskOne(prompt, &user)
+	survey.AskOne(prompt, &user, promptStdio)
 	user = strings.TrimSpace(user)
 	if user == "" {
 		user = "root"
@@ -102,75 +121,126 @@ func AskRegion(ctx context.Context, cfg aws.Config) (*Region, error) {
 	}
 	if err := survey.AskOne(prompt, &region, survey.WithIcons(func(icons *survey.IconSet) {
 		icons.SelectFocus.Format = "green+hb"
-	}), survey.WithPageSize(20)); err != nil {
+	}), survey.WithPageSize(20), promptStdio); err != nil {
 		return nil, err
 	}
 
 	return &Region{Name: region}, nil
 }
 
-// AskTarget asks you which selects an instance.
-func AskTarget(ctx context.Context, cfg aws.Config) (*Target, error) {
-	table, err := FindInstances(ctx, cfg)
+// AskTarget asks you which selects an instance, instances are shown with columns.
+// It returns an instance without asking when only one instance is matched with query.
+// Instances are found in every scope, they are loaded from cache of scope and stale instances are updated while asking.
+func AskTarget(ctx context.Context, scopes *Scopes, filter *TargetFilter, columns []string, query string) (*Target, error) {
+	targets, err := askTargets(ctx, scopes, filter, columns, query,
+		fmt.Sprintf("Choose a target in AWS: (%s)", strings.Join(columns, ", ")), false)
 	if err != nil {
 		return nil, err
 	}
-
-	options := make([]string, 0, len(table))
-	for k, _ := range table {
-		options = append(options, k)
-	}
-	sort.Strings(options)
-	if len(options) == 0 {
+	if len(targets) == 0 {
 		return nil, fmt.Errorf("not found ec2 instances")
 	}
+	return targets[0], nil
+}
 
-	prompt := &survey.Select{
-		Message: "Choose a target in AWS:",
-		Options: options,
-	}
-
-	selectKey := ""
-	if err := survey.AskOne(prompt, &selectKey, survey.WithIcons(func(icons *survey.IconSet) {
-		icons.SelectFocus.Format = "green+hb"
-	}), survey.WithPageSize(20)); err != nil {
+// AskMultiTarget asks you which selects multi targets, instances are shown with columns.
+// It returns an instance without asking when only one instance is matched with query.
+// Instances are found in every scope, they are loaded from cache of scope and stale instances are updated while asking.
+func AskMultiTarget(ctx context.Context, scopes *Scopes, filter *TargetFilter, columns []string, query string) ([]*Target, error) {
+	targets, err := askTargets(ctx, scopes, filter, columns, query,
+		fmt.Sprintf("Choose targets in AWS: (%s)", strings.Join(columns, ", ")), true)
+	if err != nil {
 		return nil, err
 	}
-
-	return table[selectKey], nil
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("not found multi-target")
+	}
+	return targets, nil
 }
 
-// AskMultiTarget asks you which selects multi targets.
-func AskMultiTarget(ctx context.Context, cfg aws.Config) ([]*Target, error) {
-	table, err := FindInstances(ctx, cfg)
+// askTargets asks you which selects targets using fuzzy picker, which searches name, id, ips and tags of targets.
+func askTargets(ctx context.Context, scopes *Scopes, filter *TargetFilter, columns []string, query, message string, multi bool) ([]*Target, error) {
+	table, stale, err := FindInstancesInScopes(ctx, scopes, filter, false)
 	if err != nil {
 		return nil, err
 	}
+	// query selects without asking and empty instances can't be asked, so they need fresh instances.
+	if stale && (strings.TrimSpace(query) != "" || len(table) == 0) {
+		if table, _, err = FindInstancesInScopes(ctx, scopes, filter, true); err != nil {
+			return nil, err
+		}
+		stale = false
+	}
 
-	options := make([]string, 0, len(table))
-	for k, _ := range table {
-		options = append(options, k)
+	targets := SortTargets(table)
+	if len(targets) == 0 {
+		return nil, nil
 	}
-	sort.Strings(options)
-	if len(options) == 0 {
-		return nil, fmt.Errorf("not found multi-target")
+
+	var (
+		mu     sync.Mutex
+		lookup = make(map[string]*Target, len(targets))
+	)
+	for _, t := range targets {
+		lookup[t.Name] = t
+	}
+
+	picker := NewPicker(message, targetItems(targets, columns), multi)
+	if strings.TrimSpace(query) != "" {
+		matches := picker.Match(query)
+		switch len(matches) {
+		case 0:
+			return nil, fmt.Errorf("not found targets matched with query %s", query)
+		case 1:
+			return []*Target{targets[matches[0].Index]}, nil
+		}
 	}
 
-	prompt := &survey.MultiSelect{
-		Message: "Choose targets in AWS:",
-		Options: options,
+	if stale {
+		updates := make(chan []PickerItem, 1)
+		picker.Updates = updates
+		go func() {
+			// warnings can't be printed while asking, so picker keeps current instances if any scope fails.
+			refreshed, _, errs := findInstancesInScopes(ctx, scopes, filter, true)
+			if len(errs) > 0 {
+				return
+			}
+			targets := SortTargets(refreshed)
+			mu.Lock()
+			for _, t := range targets {
+				lookup[t.Name] = t
+			}
+			mu.Unlock()
+			updates <- targetItems(targets, columns)
+		}()
 	}
 
-	var selectKeys []string
-	if err := survey.AskOne(prompt, &selectKeys, survey.WithPageSize(20)); err != nil {
+	indexes, err := picker.Run(query)
+	if err != nil {
 		return nil, err
 	}
 
-	var targets []*Target
-	for _, k := range selectKeys {
-		targets = append(targets, table[k])
+	mu.Lock()
+	defer mu.Unlock()
+	var selected []*Target
+	for _, i := range indexes {
+		selected = append(selected, lookup[picker.Items[i].Id])
 	}
-	return targets, nil
+	return selected, nil
+}
+
+// targetItems returns items of picker, which are identified by instance id.
+func targetItems(targets []*Target, columns []string) []PickerItem {
+	labels := FormatTargets(targets, columns)
+	items := make([]PickerItem, 0, len(targets))
+	for i, t := range targets {
+		keywords := []string{t.InstanceName, t.Name, t.PrivateIp, t.PublicIp}
+		for k, v := range t.Tags {
+			keywords = append(keywords, fmt.Sprintf("%s=%s", k, v))
+		}
+		items = append(items, PickerItem{Label: labels[i], Keywords: keywords, Id: t.Name})
+	}
+	return items
 }
 
 // AskPorts asks you which select ports.
@@ -186,7 +256,7 @@ func AskPorts() (port *Port, retErr error) {
 			Prompt: &survey.Input{Message: "Local port number to forward:"},
 		},
 	}
-	if err := survey.Ask(prompts, port); err != nil {
+	if err := survey.Ask(prompts, port, promptStdio); err != nil {
 		retErr = WrapError(err)
 		return
 	}
@@ -206,36 +276,70 @@ func AskPorts() (port *Port, retErr error) {
 	return
 }
 
-// FindInstances returns all of instances-map with running state.
-func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, error) {
+// FindInstances returns all of instances-map keyed by instance id, which are narrowed by filter.
+// Instances are listed from ssm, EC2 instances with running state are enriched by ec2.
+// Managed instances aren't EC2, so they are excluded when filter has filters of ec2.
+func FindInstances(ctx context.Context, cfg aws.Config, filter *TargetFilter) (map[string]*Target, error) {
 	var (
 		client     = ec2.NewFromConfig(cfg)
 		table      = make(map[string]*Target)
-		outputFunc = func(table map[string]*Target, output *ec2.DescribeInstancesOutput) {
+		outputFunc = func(table map[string]*Target, output *ec2.DescribeInstancesOutput, infos map[string]ssm_types.InstanceInformation) {
 			for _, rv := range output.Reservations {
 				for _, inst := range rv.Instances {
-					name := ""
+					tags := make(map[string]string, len(inst.Tags))
 					for _, tag := range inst.Tags {
-						if aws.ToString(tag.Key) == "Name" {
-							name = aws.ToString(tag.Value)
-							break
-						}
+						tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
 					}
-					table[fmt.Sprintf("%s\t(%s)", name, *inst.InstanceId)] = &Target{
+					target := &Target{
 						Name:          aws.ToString(inst.InstanceId),
+						InstanceName:  tags["Name"],
 						PublicDomain:  aws.ToString(inst.PublicDnsName),
 						PrivateDomain: aws.ToString(inst.PrivateDnsName),
+						PublicIp:      aws.ToString(inst.PublicIpAddress),
+						PrivateIp:     aws.ToString(inst.PrivateIpAddress),
+						InstanceType:  string(inst.InstanceType),
+						LaunchTime:    launchTime(inst.LaunchTime),
+						Tags:          tags,
 					}
+					if inst.Placement != nil {
+						target.AvailabilityZone = aws.ToString(inst.Placement.AvailabilityZone)
+					}
+					if info, ok := infos[target.Name]; ok {
+						setInstanceInformation(target, info)
+					}
+					table[target.Name] = target
 				}
 			}
 		}
 	)
 
-	// get instance ids which possibly can connect to instances using ssm.
-	instances, err := FindInstanceIdsWithConnectedSSM(ctx, cfg)
+	// get instances which possibly can connect to instances using ssm.
+	infos, err := findInstanceInformation(ctx, cfg, filter)
 	if err != nil {
 		return nil, err
 	}
+	infoTable := make(map[string]ssm_types.InstanceInformation, len(infos))
+	instances := make([]string, 0, len(infos))
+	for _, info := range infos {
+		if info.ResourceType != ssm_types.ResourceTypeEc2Instance {
+			if len(filter.ec2Filters()) == 0 {
+				target := &Target{
+					Name:         aws.ToString(info.InstanceId),
+					InstanceName: aws.ToString(info.Name),
+					PrivateIp:    aws.ToString(info.IPAddress),
+					Tags:         map[string]string{},
+				}
+				if target.InstanceName == "" {
+					target.InstanceName = aws.ToString(info.ComputerName)
+				}
+				setInstanceInformation(target, info)
+				table[target.Name] = target
+			}
+			continue
+		}
+		infoTable[aws.ToString(info.InstanceId)] = info
+		instances = append(instances, aws.ToString(info.InstanceId))
+	}
 
 	for len(instances) > 0 {
 		max := len(instances)
@@ -245,35 +349,58 @@ func FindInstances(ctx context.Context, cfg aws.Config) (map[string]*Target, err
 		}
 		output, err := client.DescribeInstances(ctx,
 			&ec2.DescribeInstancesInput{
-				Filters: []ec2_types.Filter{
+				Filters: append([]ec2_types.Filter{
 					{Name: aws.String("instance-state-name"), Values: []string{"running"}},
 					{Name: aws.String("instance-id"), Values: instances[:max]},
-				},
+				}, filter.ec2Filters()...),
 			})
 		if err != nil {
 			return nil, err
 		}
-		outputFunc(table, output)
+		outputFunc(table, output, infoTable)
 		instances = instances[max:]
 	}
 
 	return table, nil
 }
 
-// FindInstanceIdsWithConnectedSSM asks you which selects instances.
-func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config) ([]string, error) {
+// setInstanceInformation sets fields of target from information of ssm.
+func setInstanceInformation(target *Target, info ssm_types.InstanceInformation) {
+	target.Platform = string(info.PlatformType)
+	target.PlatformName = aws.ToString(info.PlatformName)
+	target.PlatformVersion = aws.ToString(info.PlatformVersion)
+	target.PingStatus = string(info.PingStatus)
+	target.AgentVersion = aws.ToString(info.AgentVersion)
+	target.ResourceType = string(info.ResourceType)
+}
+
+// FindInstanceIdsWithConnectedSSM asks you which selects instances, which are narrowed by filter.
+func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config, filter *TargetFilter) ([]string, error) {
+	infos, err := findInstanceInformation(ctx, cfg, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	instances := make([]string, 0, len(infos))
+	for _, info := range infos {
+		instances = append(instances, aws.ToString(info.InstanceId))
+	}
+	return instances, nil
+}
+
+// findInstanceInformation returns information of instances registered to ssm, which are narrowed by filter.
+func findInstanceInformation(ctx context.Context, cfg aws.Config, filter *TargetFilter) ([]ssm_types.InstanceInformation, error) {
 	var (
-		instances  []string
+		instances  []ssm_types.InstanceInformation
 		client     = ssm.NewFromConfig(cfg)
-		outputFunc = func(instances []string, output *ssm.DescribeInstanceInformationOutput) []string {
-			for _, inst := range output.InstanceInformationList {
-				instances = append(instances, aws.ToString(inst.InstanceId))
-			}
-			return instances
+		outputFunc = func(instances []ssm_types.InstanceInformation, output *ssm.DescribeInstanceInformationOutput) []ssm_types.InstanceInformation {
+			return append(instances, output.InstanceInformationList...)
 		}
 	)
 
-	output, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{MaxResults: maxOutputResults})
+	output, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+		Filters:    filter.ssmFilters(),
+		MaxResults: maxOutputResults})
 	if err != nil {
 		return nil, err
 	}
@@ -287,6 +414,7 @@ func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config) ([]str
 				break
 			}
 			nextOutput, err := client.DescribeInstanceInformation(ctx, &ssm.DescribeInstanceInformationInput{
+				Filters:    filter.ssmFilters(),
 				NextToken:  aws.String(token),
 				MaxResults: maxOutputResults})
 			if err != nil {
@@ -301,7 +429,7 @@ func FindInstanceIdsWithConnectedSSM(ctx context.Context, cfg aws.Config) ([]str
 	return instances, nil
 }
 
-// FindInstanceIdByIp returns instance ids by ip.
+// FindInstanceIdByIp returns instance ids by ip, it also finds managed instances by ip.
 func FindInstanceIdByIp(ctx context.Context, cfg aws.Config, ip string) (string, error) {
 	var (
 		instanceId string
@@ -363,7 +491,90 @@ func FindInstanceIdByIp(ctx context.Context, cfg aws.Config, ip string) (string,
 		}
 	}
 
-	return "", nil
+	// managed instances aren't EC2, so they are found in ssm.
+	return findManagedInstanceId(ctx, cfg, func(info ssm_types.InstanceInformation) bool {
+		return aws.ToString(info.IPAddress) == ip
+	})
+}
+
+// FindInstanceIdByName returns instance id by Name tag, it also finds managed instances by name or computer name.
+// Name tags aren't unique, so it returns an error with matched instance ids if several instances are matched.
+func FindInstanceIdByName(ctx context.Context, cfg aws.Config, name string) (string, error) {
+	var (
+		ids    []string
+		client = ec2.NewFromConfig(cfg)
+		token  string
+	)
+	for {
+		input := &ec2.DescribeInstancesInput{
+			Filters: []ec2_types.Filter{
+				{Name: aws.String("instance-state-name"), Values: []string{"running"}},
+				{Name: aws.String("tag:Name"), Values: []string{name}},
+			},
+		}
+		if token != "" {
+			input.NextToken = aws.String(token)
+		}
+		output, err := client.DescribeInstances(ctx, input)
+		if err != nil {
+			return "", err
+		}
+		for _, rv := range output.Reservations {
+			for _, inst := range rv.Instances {
+				ids = append(ids, aws.ToString(inst.InstanceId))
+			}
+		}
+		if token = aws.ToString(output.NextToken); token == "" {
+			break
+		}
+	}
+
+	if len(ids) == 0 {
+		// managed instances aren't EC2, so they are found in ssm.
+		var err error
+		ids, err = findManagedInstanceIds(ctx, cfg, func(info ssm_types.InstanceInformation) bool {
+			return aws.ToString(info.Name) == name || aws.ToString(info.ComputerName) == name
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	switch len(ids) {
+	case 0:
+		return "", nil
+	case 1:
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("[err] %s is matched with several instances %s, use instance id instead", name, strings.Join(ids, ", "))
+	}
+}
+
+// findManagedInstanceId returns id of managed instance which is matched, managed instances are registered by hybrid activation.
+func findManagedInstanceId(ctx context.Context, cfg aws.Config, matched func(info ssm_types.InstanceInformation) bool) (string, error) {
+	ids, err := findManagedInstanceIds(ctx, cfg, matched)
+	if err != nil || len(ids) == 0 {
+		return "", err
+	}
+	return ids[0], nil
+}
+
+// findManagedInstanceIds returns ids of every managed instance which is matched.
+func findManagedInstanceIds(ctx context.Context, cfg aws.Config, matched func(info ssm_types.InstanceInformation) bool) ([]string, error) {
+	infos, err := findInstanceInformation(ctx, cfg, &TargetFilter{SSM: []ssm_types.InstanceInformationStringFilter{
+		{Key: aws.String("ResourceType"), Values: []string{string(ssm_types.ResourceTypeManagedInstance)}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []string
+	for _, info := range infos {
+		if matched(info) {
+			ids = append(ids, aws.ToString(info.InstanceId))
+		}
+	}
+	return ids, nil
 }
 
 // FindDomainByInstanceId returns domain by instance id.
@@ -433,7 +644,7 @@ func AskHost() (host string, retErr error) {
 	prompt := &survey.Input{
 		Message: "Type your host address you want to forward to:",
 	}
-	survey.AskOne(prompt, &host)
+	survey.AskOne(prompt, &host, promptStdio)
 	host = strings.TrimSpace(host)
 	if host == "" {
 		retErr = errors.New("you must specify a host address")
@@ -452,72 +663,42 @@ func CreateStartSession(ctx context.Context, cfg aws.Config, input *ssm.StartSes
 // DeleteStartSession creates session.
 func DeleteStartSession(ctx context.Context, cfg aws.Config, input *ssm.TerminateSessionInput) error {
 	client := ssm.NewFromConfig(cfg)
-	fmt.Printf("%s %s \n", color.YellowString("Delete Session"),
+	fmt.Fprintf(color.Output, "%s %s \n", color.YellowString("Delete Session"),
 		color.YellowString(aws.ToString(input.SessionId)))
 
 	_, err := client.TerminateSession(ctx, input)
 	return err
 }
 
-// SendCommand send commands to instance targets.
-func SendCommand(ctx context.Context, cfg aws.Config, targets []*Target, command string) (*ssm.SendCommandOutput, error) {
+// SendCommand send document of input to instance targets, or to Targets of input if targets are empty.
+func SendCommand(ctx context.Context, cfg aws.Config, targets []*Target, input *CommandInput) (*ssm.SendCommandOutput, error) {
 	client := ssm.NewFromConfig(cfg)
 
-	// only support to linux (window = "AWS-RunPowerShellScript")
-	docName := "AWS-RunShellScript"
-
 	var ids []string
 	for _, t := range targets {
 		ids = append(ids, t.Name)
 	}
 
-	input := &ssm.SendCommandInput{
-		DocumentName:   &docName,
+	send := &ssm.SendCommandInput{
+		DocumentName:   aws.String(input.DocumentName),
 		InstanceIds:    ids,
-		TimeoutSeconds: 60,
+		TimeoutSeconds: input.deliveryTimeoutSeconds(),
 		CloudWatchOutputConfig: &ssm_types.CloudWatchOutputConfig{
 			CloudWatchOutputEnabled: true,
 		},
-		Parameters: map[string][]string{"commands": []string{command}},
+		Parameters: input.parameters(),
 	}
-
-	return client.SendCommand(ctx, input)
-}
-
-// PrintCommandInvocation watches command invocations.
-func PrintCommandInvocation(ctx context.Context, cfg aws.Config, inputs []*ssm.GetCommandInvocationInput) {
-	client := ssm.NewFromConfig(cfg)
-
-	wg := new(sync.WaitGroup)
-	for _, input := range inputs {
-		wg.Add(1)
-		go func(input *ssm.GetCommandInvocationInput) {
-		Exit:
-			for {
-				select {
-				case <-time.After(1 * time.Second):
-					output, err := client.GetCommandInvocation(ctx, input)
-					if err != nil {
-						color.Red("%v", err)
-						break Exit
-					}
-					status := strings.ToLower(string(output.Status))
-					switch status {
-					case "pending", "inprogress", "delayed":
-					case "success":
-						fmt.Printf("[%s][%s] %s\n", color.GreenString("success"), color.YellowString(*output.InstanceId), color.GreenString(*output.StandardOutputContent))
-						break Exit
-					default:
-						fmt.Printf("[%s][%s] %s\n", color.RedString("err"), color.YellowString(*output.InstanceId), color.RedString(*output.StandardErrorContent))
-						break Exit
-					}
-				}
-			}
-			wg.Done()
-		}(input)
+	// rate of instance targets is controlled by caller, such as RunCommandInWindow.
+	if len(ids) == 0 {
+		send.Targets = input.Targets
+		if input.MaxConcurrency != "" {
+			send.MaxConcurrency = aws.String(input.MaxConcurrency)
+		}
+		if input.MaxErrors != "" {
+			send.MaxErrors = aws.String(input.MaxErrors)
+		}
 	}
-
-	wg.Wait()
+	return client.SendCommand(ctx, send)
 }
 
 // GenerateSSHExecCommand generates ssh exec command.
@@ -544,8 +725,9 @@ func GenerateSSHExecCommand(exec, identity, user, domain string) (newExec string
 	return
 }
 
+// PrintReady prints command, region and target which are ready, it is written to color.Output as decorative text.
 func PrintReady(cmd, region, target string) {
-	fmt.Printf("[%s] region: %s, target: %s\n", color.GreenString(cmd), color.YellowString(region), color.YellowString(target))
+	fmt.Fprintf(color.Output, "[%s] region: %s, target: %s\n", color.GreenString(cmd), color.YellowString(region), color.YellowString(target))
 }
 
 // CallProcess calls process.
@@ -3,6 +3,10 @@ package internal
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"testing"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
@@ -12,7 +16,7 @@ import (
 func TestFindInstances(t *testing.T) {
 	assert := assert.New(t)
 
-	cfg, err := NewConfig(context.Background(), "", "", "", "", "")
+	cfg, err := NewConfig(context.Background(), "", "", "", "", nil)
 	assert.NoError(err)
 
 	tests := map[string]struct {
@@ -28,7 +32,7 @@ func TestFindInstances(t *testing.T) {
 	}
 
 	for _, t := range tests {
-		result, err := FindInstances(t.ctx, t.cfg)
+		result, err := FindInstances(t.ctx, t.cfg, nil)
 		assert.Equal(t.isErr, err != nil)
 		fmt.Println(len(result))
 	}
@@ -36,7 +40,7 @@ func TestFindInstances(t *testing.T) {
 func TestFindInstanceIdsWithConnectedSSM(t *testing.T) {
 	assert := assert.New(t)
 
-	cfg, err := NewConfig(context.Background(), "", "", "", "", "")
+	cfg, err := NewConfig(context.Background(), "", "", "", "", nil)
 	assert.NoError(err)
 
 	tests := map[string]struct {
@@ -52,7 +56,7 @@ func TestFindInstanceIdsWithConnectedSSM(t *testing.T) {
 	}
 
 	for _, t := range tests {
-		result, err := FindInstanceIdsWithConnectedSSM(t.ctx, t.cfg)
+		result, err := FindInstanceIdsWithConnectedSSM(t.ctx, t.cfg, nil)
 		assert.Equal(t.isErr, err != nil)
 		fmt.Println(len(result))
 	}
@@ -61,7 +65,7 @@ func TestFindInstanceIdsWithConnectedSSM(t *testing.T) {
 func TestFindInstanceIdByIp(t *testing.T) {
 	assert := assert.New(t)
 
-	cfg, err := NewConfig(context.Background(), "", "", "", "", "")
+	cfg, err := NewConfig(context.Background(), "", "", "", "", nil)
 	assert.NoError(err)
 
 	tests := map[string]struct {
@@ -85,10 +89,63 @@ func TestFindInstanceIdByIp(t *testing.T) {
 	}
 }
 
+func TestFindInstanceIdByName(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.DescribeInstanceInformation" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.Write([]byte(`{"InstanceInformationList":[
+				{"InstanceId":"mi-1","ResourceType":"ManagedInstance","Name":"edge","ComputerName":"edge-1.local"},
+				{"InstanceId":"mi-2","ResourceType":"ManagedInstance","Name":"","ComputerName":"edge-2.local"}]}`))
+			return
+		}
+
+		values, _ := url.ParseQuery(string(body))
+		if values.Get("Action") != "DescribeInstances" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// instances of web are launched by an auto scaling group, so they have the same Name tag.
+		var items string
+		switch values.Get("Filter.2.Value.1") {
+		case "api":
+			items = `<item><instanceId>i-1</instanceId></item>`
+		case "web":
+			items = `<item><instanceId>i-2</instanceId></item><item><instanceId>i-3</instanceId></item>`
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet><item><instancesSet>` +
+			items + `</instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+	}))
+	defer server.Close()
+
+	tests := map[string]struct {
+		name  string
+		id    string
+		isErr bool
+	}{
+		"ec2":       {name: "api", id: "i-1"},
+		"ambiguous": {name: "web", isErr: true},
+		"managed":   {name: "edge-2.local", id: "mi-2"},
+		"not found": {name: "unknown", id: ""},
+	}
+
+	for name, t := range tests {
+		id, err := FindInstanceIdByName(context.Background(), newMockConfig(server.URL), t.name)
+		assert.Equal(t.isErr, err != nil, name)
+		assert.Equal(t.id, id, name)
+		if t.isErr {
+			assert.Contains(err.Error(), "i-2, i-3", name)
+		}
+	}
+}
+
 func TestFindDomainByInstanceId(t *testing.T) {
 	assert := assert.New(t)
 
-	cfg, err := NewConfig(context.Background(), "", "", "", "", "")
+	cfg, err := NewConfig(context.Background(), "", "", "", "", nil)
 	assert.NoError(err)
 
 	tests := map[string]struct {
@@ -124,3 +181,56 @@ func TestSendCommand(t *testing.T)            {}
 func TestPrintCommandInvocation(t *testing.T) {}
 func TestGenerateSSHExecCommand(t *testing.T) {}
 func TestPrintReady(t *testing.T)             {}
+
+func TestFindInstances_ManagedInstance(t *testing.T) {
+	assert := assert.New(t)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Amz-Target") == "AmazonSSM.DescribeInstanceInformation" {
+			w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+			w.Write([]byte(`{"InstanceInformationList":[
+				{"InstanceId":"i-1","ResourceType":"EC2Instance","PingStatus":"Online","PlatformType":"Linux"},
+				{"InstanceId":"mi-1","ResourceType":"ManagedInstance","PingStatus":"Online","PlatformType":"Linux",
+				 "Name":"","ComputerName":"edge-1.local","IPAddress":"192.168.0.10","AgentVersion":"3.1.0.0"}]}`))
+			return
+		}
+
+		values, _ := url.ParseQuery(string(body))
+		if values.Get("Action") != "DescribeInstances" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "text/xml")
+		w.Write([]byte(`<DescribeInstancesResponse xmlns="http://ec2.amazonaws.com/doc/2016-11-15/"><reservationSet><item><instancesSet><item>
+			<instanceId>i-1</instanceId><privateIpAddress>10.0.0.1</privateIpAddress><instanceType>t3.micro</instanceType>
+			<placement><availabilityZone>us-east-1a</availabilityZone></placement>
+			<tagSet><item><key>Name</key><value>api</value></item></tagSet>
+			</item></instancesSet></item></reservationSet></DescribeInstancesResponse>`))
+	}))
+	defer server.Close()
+
+	cfg := newMockConfig(server.URL)
+
+	table, err := FindInstances(context.Background(), cfg, nil)
+	assert.NoError(err)
+	assert.Len(table, 2)
+	assert.Equal("api", table["i-1"].InstanceName)
+	assert.Equal("10.0.0.1", table["i-1"].PrivateIp)
+	assert.Equal("us-east-1a", table["i-1"].AvailabilityZone)
+	assert.Equal("EC2Instance", table["i-1"].ResourceType)
+	assert.Equal("edge-1.local", table["mi-1"].InstanceName)
+	assert.Equal("192.168.0.10", table["mi-1"].PrivateIp)
+	assert.Equal("ManagedInstance", table["mi-1"].ResourceType)
+
+	// managed instances can't be narrowed by filters of ec2.
+	filter, err := ParseTargetFilter([]string{"tag:Env=prod"}, nil)
+	assert.NoError(err)
+	table, err = FindInstances(context.Background(), cfg, filter)
+	assert.NoError(err)
+	assert.Len(table, 1)
+
+	id, err := FindInstanceIdByIp(context.Background(), cfg, "192.168.0.10")
+	assert.NoError(err)
+	assert.Equal("mi-1", id)
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	targetLaunchTimeFormat = "2006-01-02 15:04"
+)
+
+var (
+	// DefaultTargetColumns are columns of targets which are shown in interactive CLI by default.
+	DefaultTargetColumns = []string{"name", "id", "private-ip", "type", "az", "ping"}
+
+	// targetColumns maps column names to values of target, tag:<key> is also available.
+	targetColumns = map[string]func(t *Target) string{
+		"name":       func(t *Target) string { return t.InstanceName },
+		"id":         func(t *Target) string { return t.Name },
+		"private-ip": func(t *Target) string { return t.PrivateIp },
+		"public-ip":  func(t *Target) string { return t.PublicIp },
+		"type":       func(t *Target) string { return t.InstanceType },
+		"az":         func(t *Target) string { return t.AvailabilityZone },
+		"platform":   func(t *Target) string { return t.Platform },
+		"os":         func(t *Target) string { return strings.TrimSpace(t.PlatformName + " " + t.PlatformVersion) },
+		"ping":       func(t *Target) string { return t.PingStatus },
+		"agent":      func(t *Target) string { return t.AgentVersion },
+		"resource":   func(t *Target) string { return t.ResourceType },
+		"profile":    func(t *Target) string { return t.Profile },
+		"account":    func(t *Target) string { return t.Account },
+		"region":     func(t *Target) string { return t.Region },
+		"tags":       func(t *Target) string { return formatTags(t.Tags) },
+		"launch": func(t *Target) string {
+			if t.LaunchTime.IsZero() {
+				return ""
+			}
+			return t.LaunchTime.Local().Format(targetLaunchTimeFormat)
+		},
+	}
+)
+
+// ParseTargetColumns validates column names, such as name, id, private-ip, public-ip, type, az, platform, os,
+// ping, agent, resource, profile, account, region, launch, tags and tag:<key>. It returns default columns when columns are empty.
+func ParseTargetColumns(columns []string) ([]string, error) {
+	var parsed []string
+	for _, column := range columns {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		if _, ok := targetColumns[column]; !ok && !strings.HasPrefix(column, "tag:") {
+			return nil, fmt.Errorf("[err] unknown column %s", column)
+		}
+		parsed = append(parsed, column)
+	}
+
+	if len(parsed) == 0 {
+		return DefaultTargetColumns, nil
+	}
+	return parsed, nil
+}
+
+// Column returns a value of target matched with column name.
+func (t *Target) Column(column string) string {
+	if strings.HasPrefix(column, "tag:") {
+		key := strings.TrimPrefix(column, "tag:")
+		for k, v := range t.Tags {
+			// column names are lowered, so tag key is compared case-insensitively.
+			if strings.EqualFold(k, key) {
+				return v
+			}
+		}
+		return ""
+	}
+	if f, ok := targetColumns[column]; ok {
+		return f(t)
+	}
+	return ""
+}
+
+// formatTags returns tags as key=value which are sorted by key.
+func formatTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// SortTargets returns targets sorted by profile, region, Name tag and instance id.
+func SortTargets(table map[string]*Target) []*Target {
+	targets := make([]*Target, 0, len(table))
+	for _, t := range table {
+		targets = append(targets, t)
+	}
+	sort.Slice(targets, func(i, j int) bool {
+		if targets[i].Profile != targets[j].Profile {
+			return targets[i].Profile < targets[j].Profile
+		}
+		if targets[i].Region != targets[j].Region {
+			return targets[i].Region < targets[j].Region
+		}
+		if targets[i].InstanceName != targets[j].InstanceName {
+			return targets[i].InstanceName < targets[j].InstanceName
+		}
+		return targets[i].Name < targets[j].Name
+	})
+	return targets
+}
+
+// FormatTargets returns a line per target, which has columns aligned with each other.
+func FormatTargets(targets []*Target, columns []string) []string {
+	return alignRows(targetRows(targets, columns))
+}
+
+// targetRows returns values of columns per target.
+func targetRows(targets []*Target, columns []string) [][]string {
+	rows := make([][]string, 0, len(targets))
+	for _, t := range targets {
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = t.Column(column)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// alignRows returns a line per row, which has columns aligned with each other.
+func alignRows(rows [][]string) []string {
+	var widths []int
+	for _, row := range rows {
+		for i, value := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if w := len([]rune(value)); w > widths[i] {
+				widths[i] = w
+			}
+		}
+	}
+
+	lines := make([]string, 0, len(rows))
+	for _, row := range rows {
+		var sb strings.Builder
+		for i, value := range row {
+			sb.WriteString(value)
+			if i < len(row)-1 {
+				sb.WriteString(strings.Repeat(" ", widths[i]-len([]rune(value))+2))
+			}
+		}
+		lines = append(lines, strings.TrimRight(sb.String(), " "))
+	}
+	return lines
+}
+
+// launchTime returns zero time when t is nil.
+func launchTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
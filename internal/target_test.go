@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTargetColumns(t *testing.T) {
+	assert := assert.New(t)
+
+	tests := map[string]struct {
+		columns []string
+		output  []string
+		isErr   bool
+	}{
+		"default": {columns: nil, output: DefaultTargetColumns},
+		"custom":  {columns: []string{"Name", " public-ip", "tag:Team", ""}, output: []string{"name", "public-ip", "tag:team"}},
+		"unknown": {columns: []string{"name", "owner"}, isErr: true},
+	}
+
+	for _, t := range tests {
+		columns, err := ParseTargetColumns(t.columns)
+		assert.Equal(t.isErr, err != nil)
+		if err == nil {
+			assert.Equal(t.output, columns)
+		}
+	}
+}
+
+func TestTarget_Column(t *testing.T) {
+	assert := assert.New(t)
+
+	target := &Target{Name: "i-1", InstanceName: "api", PlatformName: "Amazon Linux", PlatformVersion: "2",
+		Tags: map[string]string{"Team": "infra"}, Account: "123456789012", Region: "us-west-2"}
+
+	tests := map[string]struct {
+		column string
+		output string
+	}{
+		"id":      {column: "id", output: "i-1"},
+		"name":    {column: "name", output: "api"},
+		"os":      {column: "os", output: "Amazon Linux 2"},
+		"tag":     {column: "tag:team", output: "infra"},
+		"no tag":  {column: "tag:env", output: ""},
+		"launch":  {column: "launch", output: ""},
+		"account": {column: "account", output: "123456789012"},
+		"region":  {column: "region", output: "us-west-2"},
+		"tags":    {column: "tags", output: "Team=infra"},
+		"unknown": {column: "owner", output: ""},
+	}
+
+	for _, t := range tests {
+		assert.Equal(t.output, target.Column(t.column))
+	}
+}
+
+func TestFormatTargets(t *testing.T) {
+	assert := assert.New(t)
+
+	table := map[string]*Target{
+		"i-2": {Name: "i-2", InstanceName: "api", PrivateIp: "10.0.0.2", LaunchTime: time.Now()},
+		"i-1": {Name: "i-1", InstanceName: "api", PrivateIp: "10.0.10.1"},
+		"i-3": {Name: "i-3", InstanceName: "database", PrivateIp: ""},
+	}
+
+	lines := FormatTargets(SortTargets(table), []string{"name", "id", "private-ip"})
+	assert.Equal([]string{
+		"api       i-1  10.0.10.1",
+		"api       i-2  10.0.0.2",
+		"database  i-3",
+	}, lines)
+}